@@ -0,0 +1,280 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const AdmissionControllerPath = "/validate-cc-mode"
+
+// admissionReview is the subset of an admission.k8s.io/v1 AdmissionReview
+// used by the CC mode admission controller.
+type admissionReview struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Request    *admissionRequest  `json:"request,omitempty"`
+	Response   *admissionResponse `json:"response,omitempty"`
+}
+
+type admissionRequest struct {
+	UID       string          `json:"uid"`
+	Operation string          `json:"operation"`
+	Object    json.RawMessage `json:"object,omitempty"`
+	OldObject json.RawMessage `json:"oldObject,omitempty"`
+}
+
+type admissionResponse struct {
+	UID     string         `json:"uid"`
+	Allowed bool           `json:"allowed"`
+	Result  *metav1.Status `json:"status,omitempty"`
+}
+
+// CCModeAdmissionController validates the CC mode label of the current node
+// before the API server persists it. It registers a
+// ValidatingWebhookConfiguration named cc-manager-<node>, selecting the node
+// by its kubernetes.io/hostname label, that calls the daemon on the pod IP
+// with a self-signed certificate generated at startup and kept in memory.
+// This suits development environments, production clusters should deploy a
+// dedicated webhook.
+type CCModeAdmissionController struct {
+	clientset *kubernetes.Clientset
+	name      string
+	hostname  string
+	podIP     string
+	port      int
+
+	caBundle []byte
+	cert     tls.Certificate
+}
+
+func NewCCModeAdmissionController(clientset *kubernetes.Clientset, node *v1.Node, podIP string, port int) (*CCModeAdmissionController, error) {
+	hostname := node.Labels[v1.LabelHostname]
+	if hostname == "" {
+		return nil, fmt.Errorf("node has no %s label to select it by", v1.LabelHostname)
+	}
+	ip := net.ParseIP(podIP)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid pod IP '%s'", podIP)
+	}
+	caBundle, cert, err := generateAdmissionCertificate(ip)
+	if err != nil {
+		return nil, err
+	}
+	return &CCModeAdmissionController{
+		clientset: clientset,
+		name:      "cc-manager-" + node.Name,
+		hostname:  hostname,
+		podIP:     podIP,
+		port:      port,
+		caBundle:  caBundle,
+		cert:      cert,
+	}, nil
+}
+
+// generateAdmissionCertificate returns the PEM encoded certificate of a new
+// self-signed CA and a serving certificate for ip signed by it.
+func generateAdmissionCertificate(ip net.IP) ([]byte, tls.Certificate, error) {
+	now := time.Now()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, tls.Certificate{}, fmt.Errorf("error generating CA key: %s", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "k8s-cc-manager admission CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, tls.Certificate{}, fmt.Errorf("error creating CA certificate: %s", err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, tls.Certificate{}, fmt.Errorf("error parsing CA certificate: %s", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, tls.Certificate{}, fmt.Errorf("error generating serving key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: ip.String()},
+		IPAddresses:  []net.IP{ip},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, tls.Certificate{}, fmt.Errorf("error creating serving certificate: %s", err)
+	}
+
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	return caBundle, cert, nil
+}
+
+// TLSConfig returns the configuration to serve the admission controller with.
+func (a *CCModeAdmissionController) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{a.cert},
+	}
+}
+
+// Register creates or updates the ValidatingWebhookConfiguration.
+func (a *CCModeAdmissionController) Register(ctx context.Context) error {
+	url := fmt.Sprintf("https://%s%s", net.JoinHostPort(a.podIP, fmt.Sprint(a.port)), AdmissionControllerPath)
+	// a daemon that is down must not block node updates
+	failurePolicy := admissionregistrationv1.Ignore
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	timeout := int32(5)
+	config := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: a.name},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{{
+			Name: "cc-mode.k8s-cc-manager.nvidia.com",
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{
+				URL:      &url,
+				CABundle: a.caBundle,
+			},
+			Rules: []admissionregistrationv1.RuleWithOperations{{
+				Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+				Rule: admissionregistrationv1.Rule{
+					APIGroups:   []string{""},
+					APIVersions: []string{"v1"},
+					Resources:   []string{ResourceNodes},
+				},
+			}},
+			ObjectSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{v1.LabelHostname: a.hostname},
+			},
+			FailurePolicy:           &failurePolicy,
+			SideEffects:             &sideEffects,
+			TimeoutSeconds:          &timeout,
+			AdmissionReviewVersions: []string{"v1"},
+		}},
+	}
+
+	configs := a.clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	existing, err := configs.Get(ctx, a.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if _, err := configs.Create(ctx, config, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("error creating ValidatingWebhookConfiguration %s: %s", a.name, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error getting ValidatingWebhookConfiguration %s: %s", a.name, err)
+	}
+	config.ResourceVersion = existing.ResourceVersion
+	if _, err := configs.Update(ctx, config, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("error updating ValidatingWebhookConfiguration %s: %s", a.name, err)
+	}
+	return nil
+}
+
+// Deregister deletes the ValidatingWebhookConfiguration.
+func (a *CCModeAdmissionController) Deregister(ctx context.Context) error {
+	err := a.clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Delete(ctx, a.name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error deleting ValidatingWebhookConfiguration %s: %s", a.name, err)
+	}
+	return nil
+}
+
+// ServeHTTP answers an AdmissionReview. A node is denied if it changes the
+// CC mode label to a value that is not a valid CC mode; an unchanged or
+// removed label is always allowed.
+func (a *CCModeAdmissionController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var review admissionReview
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&review); err != nil || review.Request == nil {
+		http.Error(w, "invalid AdmissionReview", http.StatusBadRequest)
+		return
+	}
+
+	response := &admissionResponse{UID: review.Request.UID, Allowed: true}
+	if err := validateCCModeAdmission(review.Request); err != nil {
+		log.Infof("Denied node %s: %s", review.Request.Operation, err)
+		response.Allowed = false
+		response.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: err.Error(),
+			Reason:  metav1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(admissionReview{
+		APIVersion: review.APIVersion,
+		Kind:       review.Kind,
+		Response:   response,
+	})
+}
+
+func validateCCModeAdmission(request *admissionRequest) error {
+	var node, old v1.Node
+	if err := json.Unmarshal(request.Object, &node); err != nil {
+		return fmt.Errorf("error decoding node: %s", err)
+	}
+	if len(request.OldObject) != 0 {
+		if err := json.Unmarshal(request.OldObject, &old); err != nil {
+			return fmt.Errorf("error decoding node: %s", err)
+		}
+	}
+	mode := node.Labels[CCModeConfigLabel]
+	if mode == "" || mode == old.Labels[CCModeConfigLabel] {
+		return nil
+	}
+	if err := ValidateCCMode(mode); err != nil {
+		return fmt.Errorf("invalid %s label: %s", CCModeConfigLabel, err)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	AlertPolicyConfigMapKey = "alert-policy"
+
+	CCModeAlertCondition v1.NodeConditionType = "NVIDIACCModeAlert"
+)
+
+// CCModeAlertPolicy holds the thresholds above which CC mode changes are
+// alerted on. Thresholds that are not set are not checked.
+type CCModeAlertPolicy struct {
+	MaxChangeLatencySeconds *int     `json:"maxChangeLatencySeconds,omitempty"`
+	MaxConsecutiveFailures  *int     `json:"maxConsecutiveFailures,omitempty"`
+	CriticalModes           []string `json:"criticalModes,omitempty"`
+}
+
+// ParseCCModeAlertPolicy parses and validates the alert-policy key of a
+// ConfigMap, in YAML or JSON.
+func ParseCCModeAlertPolicy(data string) (CCModeAlertPolicy, error) {
+	var policy CCModeAlertPolicy
+	if err := yaml.UnmarshalStrict([]byte(data), &policy); err != nil {
+		return CCModeAlertPolicy{}, fmt.Errorf("error parsing alert policy: %s", err)
+	}
+	if policy.MaxChangeLatencySeconds != nil && *policy.MaxChangeLatencySeconds <= 0 {
+		return CCModeAlertPolicy{}, fmt.Errorf("maxChangeLatencySeconds must be a positive value")
+	}
+	if policy.MaxConsecutiveFailures != nil && *policy.MaxConsecutiveFailures <= 0 {
+		return CCModeAlertPolicy{}, fmt.Errorf("maxConsecutiveFailures must be a positive value")
+	}
+	for _, mode := range policy.CriticalModes {
+		if err := ValidateCCMode(mode); err != nil {
+			return CCModeAlertPolicy{}, fmt.Errorf("invalid critical mode: %s", err)
+		}
+	}
+	return policy, nil
+}
+
+// CCModeAlerter checks the outcome of every CC mode change against the
+// current alert policy. It reports threshold violations with the
+// NVIDIACCModeAlert node condition and a Warning event.
+type CCModeAlerter struct {
+	mutex               sync.Mutex
+	policy              CCModeAlertPolicy
+	consecutiveFailures int
+}
+
+func NewCCModeAlerter(policy CCModeAlertPolicy) *CCModeAlerter {
+	return &CCModeAlerter{policy: policy}
+}
+
+// SetPolicy replaces the alert policy.
+func (a *CCModeAlerter) SetPolicy(policy CCModeAlertPolicy) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.policy = policy
+}
+
+// Observe records the outcome of a change to mode which took latency and
+// updates the alert condition. The condition is written by the next Sync of
+// conditions.
+func (a *CCModeAlerter) Observe(conditions *StatusConditionController, events *NodeEventRecorder, mode string, latency time.Duration, err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if err != nil {
+		a.consecutiveFailures++
+	} else {
+		a.consecutiveFailures = 0
+	}
+
+	var alerts []string
+	if a.policy.MaxChangeLatencySeconds != nil && latency > time.Duration(*a.policy.MaxChangeLatencySeconds)*time.Second {
+		alerts = append(alerts, fmt.Sprintf("change to CC mode %s took %s, more than %ds", mode, latency.Round(time.Second), *a.policy.MaxChangeLatencySeconds))
+	}
+	if a.policy.MaxConsecutiveFailures != nil && a.consecutiveFailures >= *a.policy.MaxConsecutiveFailures {
+		alerts = append(alerts, fmt.Sprintf("%d consecutive CC mode changes failed", a.consecutiveFailures))
+	}
+	if err != nil {
+		for _, critical := range a.policy.CriticalModes {
+			if mode == critical {
+				alerts = append(alerts, fmt.Sprintf("change to critical CC mode %s failed", mode))
+				break
+			}
+		}
+	}
+
+	if len(alerts) == 0 {
+		conditions.SetCondition(CCModeAlertCondition, v1.ConditionFalse, "WithinAlertPolicy", "CC mode changes are within the alert policy thresholds")
+		return
+	}
+	message := strings.Join(alerts, "; ")
+	log.Warnf("CC mode alert policy threshold exceeded: %s", message)
+	conditions.SetCondition(CCModeAlertCondition, v1.ConditionTrue, "AlertPolicyThresholdExceeded", message)
+	events.Eventf(v1.EventTypeWarning, "CCModeAlert", "CC mode alert policy threshold exceeded: %s", message)
+}
+
+// ParseNamespacedName splits a namespace/name reference.
+func ParseNamespacedName(value string) (string, string, error) {
+	namespace, name, found := strings.Cut(value, "/")
+	if !found || namespace == "" || name == "" || strings.Contains(name, "/") {
+		return "", "", fmt.Errorf("invalid reference '%s', expected namespace/name", value)
+	}
+	return namespace, name, nil
+}
+
+// LoadCCModeAlertPolicy reads the alert policy from the ConfigMap.
+func LoadCCModeAlertPolicy(ctx context.Context, clientset *kubernetes.Clientset, namespace, name string) (CCModeAlertPolicy, error) {
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return CCModeAlertPolicy{}, fmt.Errorf("error getting ConfigMap %s/%s: %s", namespace, name, err)
+	}
+	return alertPolicyFromConfigMap(cm)
+}
+
+// WatchCCModeAlertPolicy updates the policy of alerter whenever the ConfigMap
+// changes. Invalid updates are logged and ignored, and deleting the
+// ConfigMap disables all alerts.
+func WatchCCModeAlertPolicy(clientset *kubernetes.Clientset, namespace, name string, alerter *CCModeAlerter) chan struct{} {
+	listWatch := cache.NewListWatchFromClient(
+		clientset.CoreV1().RESTClient(),
+		"configmaps",
+		namespace,
+		fields.OneTermEqualSelector("metadata.name", name),
+	)
+
+	update := func(obj interface{}) {
+		policy, err := alertPolicyFromConfigMap(obj.(*v1.ConfigMap))
+		if err != nil {
+			log.Errorf("Ignoring update of ConfigMap %s/%s: %s", namespace, name, err)
+			return
+		}
+		log.Infof("Updating CC mode alert policy from ConfigMap %s/%s", namespace, name)
+		alerter.SetPolicy(policy)
+	}
+
+	_, controller := cache.NewInformer(
+		listWatch, &v1.ConfigMap{}, 0,
+		PanicRecoveryMiddleware("alert policy", cache.ResourceEventHandlerFuncs{
+			AddFunc: update,
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				update(newObj)
+			},
+			DeleteFunc: func(obj interface{}) {
+				log.Warnf("ConfigMap %s/%s was deleted, disabling CC mode alerts", namespace, name)
+				alerter.SetPolicy(CCModeAlertPolicy{})
+			},
+		}),
+	)
+
+	stop := make(chan struct{})
+	go controller.Run(stop)
+	return stop
+}
+
+func alertPolicyFromConfigMap(cm *v1.ConfigMap) (CCModeAlertPolicy, error) {
+	data, ok := cm.Data[AlertPolicyConfigMapKey]
+	if !ok {
+		return CCModeAlertPolicy{}, fmt.Errorf("ConfigMap %s/%s has no '%s' key", cm.Namespace, cm.Name, AlertPolicyConfigMapKey)
+	}
+	return ParseCCModeAlertPolicy(data)
+}
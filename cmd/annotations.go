@@ -0,0 +1,176 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	CCModeAppliedAnnotation   = "nvidia.com/cc.mode.applied"
+	CCModeAppliedAtAnnotation = "nvidia.com/cc.mode.applied-at"
+)
+
+// NodeAnnotationCache holds the annotations of the current node as last read
+// from the API server, so that annotation patches do not need to Get the node
+// first. Entries older than the TTL are considered stale.
+type NodeAnnotationCache struct {
+	clientset *kubernetes.Clientset
+	patcher   *NodeLabelPatcher
+	nodeName  string
+	ttl       time.Duration
+
+	mutex       sync.Mutex
+	annotations map[string]string
+	fetched     time.Time
+}
+
+func NewNodeAnnotationCache(clientset *kubernetes.Clientset, patcher *NodeLabelPatcher, nodeName string, ttl time.Duration) *NodeAnnotationCache {
+	return &NodeAnnotationCache{
+		clientset: clientset,
+		patcher:   patcher,
+		nodeName:  nodeName,
+		ttl:       ttl,
+	}
+}
+
+// Get returns the node annotations, fetching the node if the cache is empty
+// or stale.
+func (c *NodeAnnotationCache) Get(ctx context.Context) (map[string]string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.annotations != nil && time.Since(c.fetched) < c.ttl {
+		return c.annotations, nil
+	}
+
+	node, err := c.clientset.CoreV1().Nodes().Get(ctx, c.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting node %s: %s", c.nodeName, err)
+	}
+	c.store(node.Annotations)
+	return c.annotations, nil
+}
+
+// Patch writes the annotations in a single patch if any of them differs from
+// the cached ones. All of them are sent so that server-side apply keeps
+// owning the unchanged keys. A successful patch invalidates the cache by
+// replacing it with the annotations returned by the API server.
+func (c *NodeAnnotationCache) Patch(ctx context.Context, annotations map[string]string) error {
+	return c.PatchWithOps(ctx, annotations, nil)
+}
+
+// PatchWithOps is Patch, sending extraOps RFC 6902 operations in the same
+// JSON patch if there are any.
+func (c *NodeAnnotationCache) PatchWithOps(ctx context.Context, annotations map[string]string, extraOps []json.RawMessage) error {
+	current, err := c.Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	changed := len(extraOps) != 0
+	for key, value := range annotations {
+		if v, ok := current[key]; !ok || v != value {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.annotations = nil
+	var node *v1.Node
+	if len(extraOps) != 0 {
+		node, err = c.patcher.PatchAnnotationsWithOps(ctx, annotations, extraOps)
+	} else {
+		node, err = c.patcher.PatchAnnotations(ctx, annotations)
+	}
+	if err != nil {
+		return err
+	}
+	c.store(node.Annotations)
+	return nil
+}
+
+// Remove deletes the annotations from the node with a merge patch and
+// replaces the cache with the annotations returned by the API server.
+func (c *NodeAnnotationCache) Remove(ctx context.Context, keys ...string) error {
+	annotations := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		annotations[key] = nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding node annotations patch: %s", err)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.annotations = nil
+	node, err := c.clientset.CoreV1().Nodes().Patch(ctx, c.nodeName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("error removing node annotations: %s", err)
+	}
+	c.store(node.Annotations)
+	return nil
+}
+
+// Invalidate drops the cached annotations, e.g. after the node was patched
+// without going through the cache.
+func (c *NodeAnnotationCache) Invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.annotations = nil
+}
+
+func (c *NodeAnnotationCache) store(annotations map[string]string) {
+	c.annotations = make(map[string]string, len(annotations))
+	for key, value := range annotations {
+		c.annotations[key] = value
+	}
+	c.fetched = time.Now()
+}
+
+// writeAppliedAnnotations records a successfully applied cc mode on the node,
+// together with the operations of --json-patch-ops.
+func writeAppliedAnnotations(ctx context.Context, annotations *NodeAnnotationCache, mode string) error {
+	return annotations.PatchWithOps(ctx, appliedAnnotations(mode), jsonPatchOps)
+}
+
+// appliedAnnotations returns the annotations recording mode as applied now.
+func appliedAnnotations(mode string) map[string]string {
+	return map[string]string{
+		CCModeAppliedAnnotation:   mode,
+		CCModeAppliedAtAnnotation: time.Now().UTC().Format(time.RFC3339),
+	}
+}
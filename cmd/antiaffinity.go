@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// currentPodName returns the name of the pod the daemon runs in, from the
+// POD_NAME env if set, or the hostname otherwise.
+func currentPodName() string {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name
+	}
+	name, _ := os.Hostname()
+	return name
+}
+
+// findConflictingPods returns the other running or pending pods on the node
+// which match selector.
+func findConflictingPods(ctx context.Context, clientset *kubernetes.Clientset, nodeName string, selector labels.Selector) ([]string, error) {
+	pods, err := clientset.CoreV1().Pods(v1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing pods on node %s: %s", nodeName, err)
+	}
+
+	self := currentPodName()
+	var conflicting []string
+	for _, pod := range pods.Items {
+		if pod.Name == self {
+			continue
+		}
+		if pod.Status.Phase != v1.PodRunning && pod.Status.Phase != v1.PodPending {
+			continue
+		}
+		conflicting = append(conflicting, fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
+	}
+	return conflicting, nil
+}
@@ -0,0 +1,198 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	cli "github.com/urfave/cli/v2"
+)
+
+// CCModeChangeRecord is one line of the audit log, written for every CC mode
+// change attempted by the daemon.
+type CCModeChangeRecord struct {
+	Time         time.Time     `json:"time"`
+	Node         string        `json:"node"`
+	Mode         string        `json:"mode"`
+	PreviousMode string        `json:"previousMode"`
+	Duration     time.Duration `json:"duration"`
+	Error        string        `json:"error,omitempty"`
+	Digest       string        `json:"digest,omitempty"`
+}
+
+// AuditChain links audit records in a hash chain. The digest of each record
+// is SHA256(previous digest + JSON of the record without its digest), so that
+// changing or removing a record breaks the digests of all following ones.
+type AuditChain struct {
+	mutex sync.Mutex
+	last  string
+}
+
+func NewAuditChain(last string) *AuditChain {
+	return &AuditChain{last: last}
+}
+
+// Append returns the digest of record chained to the previous record and
+// makes it the new head of the chain.
+func (c *AuditChain) Append(record CCModeChangeRecord) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.last = chainDigest(c.last, record)
+	return c.last
+}
+
+func chainDigest(previous string, record CCModeChangeRecord) string {
+	record.Digest = ""
+	data, _ := json.Marshal(record)
+	sum := sha256.Sum256(append([]byte(previous), data...))
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditLog appends CCModeChangeRecords as JSON lines to a file, chaining
+// their digests if chain is not nil.
+type AuditLog struct {
+	mutex sync.Mutex
+	file  *os.File
+	chain *AuditChain
+}
+
+// OpenAuditLog opens the audit log at path for appending. With digest set,
+// the hash chain continues from the last record already in the file.
+func OpenAuditLog(path string, digest bool) (*AuditLog, error) {
+	l := &AuditLog{}
+	if digest {
+		last, err := lastAuditDigest(path)
+		if err != nil {
+			return nil, err
+		}
+		l.chain = NewAuditChain(last)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log: %s", err)
+	}
+	l.file = file
+	return l, nil
+}
+
+// Write appends record to the log. It is a no-op on a nil log.
+func (l *AuditLog) Write(record CCModeChangeRecord) error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	record.Time = record.Time.UTC()
+	if l.chain != nil {
+		record.Digest = l.chain.Append(record)
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error encoding audit record: %s", err)
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("error writing audit log: %s", err)
+	}
+	return nil
+}
+
+// Close closes the log file.
+func (l *AuditLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// lastAuditDigest returns the digest of the last record of the audit log at
+// path, or an empty string if there is none.
+func lastAuditDigest(path string) (string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error opening audit log: %s", err)
+	}
+	defer file.Close()
+
+	last := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record CCModeChangeRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return "", fmt.Errorf("error parsing audit log %s: %s", path, err)
+		}
+		last = record.Digest
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("error reading audit log %s: %s", path, err)
+	}
+	return last, nil
+}
+
+// VerifyAuditLog recomputes the hash chain of the audit log at path and
+// returns an error naming the first record whose digest does not match.
+func VerifyAuditLog(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("error opening audit log: %s", err)
+	}
+	defer file.Close()
+
+	previous := ""
+	line := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line++
+		var record CCModeChangeRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return line - 1, fmt.Errorf("line %d: invalid record: %s", line, err)
+		}
+		expected := chainDigest(previous, record)
+		if record.Digest != expected {
+			return line - 1, fmt.Errorf("line %d: digest mismatch, expected %s, got '%s'", line, expected, record.Digest)
+		}
+		previous = record.Digest
+	}
+	if err := scanner.Err(); err != nil {
+		return line, fmt.Errorf("error reading audit log: %s", err)
+	}
+	return line, nil
+}
+
+// verifyAuditCommand implements the verify-audit subcommand.
+func verifyAuditCommand(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("usage: %s verify-audit <audit-log-file>", c.App.Name)
+	}
+	count, err := VerifyAuditLog(c.Args().First())
+	if err != nil {
+		return fmt.Errorf("audit log verification failed after %d valid records: %s", count, err)
+	}
+	fmt.Printf("Audit log verified: %d records\n", count)
+	return nil
+}
@@ -0,0 +1,52 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// BackoffManager coalesces bursts of Trigger calls into a single call of fn.
+// Every Trigger restarts the window, and fn runs once the window has passed
+// without another Trigger.
+type BackoffManager struct {
+	window time.Duration
+	fn     func()
+
+	mutex sync.Mutex
+	timer *time.Timer
+}
+
+func NewBackoffManager(window time.Duration, fn func()) *BackoffManager {
+	return &BackoffManager{
+		window: window,
+		fn:     fn,
+	}
+}
+
+// Trigger schedules fn to run after the window, dropping any call that is
+// still pending.
+func (b *BackoffManager) Trigger() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.window, b.fn)
+		return
+	}
+	b.timer.Reset(b.window)
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BackpressureAction is what SyncableCCModeConfig.Set does when more than
+// --max-pending-changes values were set without being read.
+type BackpressureAction int
+
+const (
+	// ProcessLatest drops the oldest pending values and keeps the latest.
+	ProcessLatest BackpressureAction = iota
+	// DropAll drops every pending value, the latest included.
+	DropAll
+	// PauseWatch blocks the informer until the pending values were read.
+	PauseWatch
+)
+
+// WatcherBackpressureStrategy decides how to handle CC mode changes arriving
+// faster than they are applied.
+type WatcherBackpressureStrategy interface {
+	OnOverflow(pendingCount int, latestMode string) BackpressureAction
+}
+
+// DropOldestStrategy always processes the latest value. It is the default.
+type DropOldestStrategy struct{}
+
+func (DropOldestStrategy) OnOverflow(pendingCount int, latestMode string) BackpressureAction {
+	return ProcessLatest
+}
+
+// DropAllStrategy discards every pending value, the latest included, and
+// keeps the CC mode last read, on the assumption that a burst of changes is
+// a misbehaving controller rather than an intent to apply.
+type DropAllStrategy struct{}
+
+func (DropAllStrategy) OnOverflow(pendingCount int, latestMode string) BackpressureAction {
+	return DropAll
+}
+
+// PauseWatchStrategy stops delivering node events until the pending values
+// were read, so that no change is dropped.
+type PauseWatchStrategy struct{}
+
+func (PauseWatchStrategy) OnOverflow(pendingCount int, latestMode string) BackpressureAction {
+	return PauseWatch
+}
+
+// ParseBackpressureStrategy parses the value of --backpressure-strategy.
+func ParseBackpressureStrategy(s string) (WatcherBackpressureStrategy, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "drop-oldest":
+		return DropOldestStrategy{}, nil
+	case "drop-all":
+		return DropAllStrategy{}, nil
+	case "pause-watch":
+		return PauseWatchStrategy{}, nil
+	}
+	return nil, fmt.Errorf("unknown backpressure strategy '%s', must be one of drop-oldest, drop-all, pause-watch", s)
+}
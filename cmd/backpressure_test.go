@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBackpressureStrategy(t *testing.T) {
+	tests := []struct {
+		value   string
+		expect  WatcherBackpressureStrategy
+		wantErr bool
+	}{
+		{"drop-oldest", DropOldestStrategy{}, false},
+		{" Drop-All ", DropAllStrategy{}, false},
+		{"pause-watch", PauseWatchStrategy{}, false},
+		{"", nil, true},
+		{"drop-newest", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			strategy, err := ParseBackpressureStrategy(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error parsing '%s'", tt.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if strategy != tt.expect {
+				t.Errorf("expected %T, got %T", tt.expect, strategy)
+			}
+		})
+	}
+}
+
+// TestBackpressureOverflow sets more values than --max-pending-changes
+// allows without reading them and checks what Get returns afterwards.
+func TestBackpressureOverflow(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy WatcherBackpressureStrategy
+		values   []string
+		expect   string
+	}{
+		{"drop-oldest within the limit", DropOldestStrategy{}, []string{CCModeOn, CCModeOff}, CCModeOff},
+		{"drop-oldest keeps the latest", DropOldestStrategy{}, []string{CCModeOn, CCModeOff, CCModeDevtools}, CCModeDevtools},
+		{"drop-all within the limit", DropAllStrategy{}, []string{CCModeOn, CCModeOff}, CCModeOff},
+		{"drop-all keeps the last read", DropAllStrategy{}, []string{CCModeOn, CCModeOff, CCModeDevtools}, CCModeOn},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewSyncableCCModeConfig()
+			m.SetMaxPending(2)
+			m.SetBackpressureStrategy(tt.strategy)
+			m.Set(CCModeOn)
+			if got := m.GetNow(); got != CCModeOn {
+				t.Fatalf("expected %s, got %s", CCModeOn, got)
+			}
+			for _, value := range tt.values {
+				m.Set(value)
+			}
+			if got := m.GetNow(); got != tt.expect {
+				t.Errorf("expected %s, got %s", tt.expect, got)
+			}
+		})
+	}
+}
+
+// TestPauseWatchOverflow checks that an overflowing Set blocks until the
+// pending values are read.
+func TestPauseWatchOverflow(t *testing.T) {
+	m := NewSyncableCCModeConfig()
+	m.SetMaxPending(1)
+	m.SetBackpressureStrategy(PauseWatchStrategy{})
+	m.Set(CCModeOn)
+
+	done := make(chan struct{})
+	go func() {
+		m.Set(CCModeOff)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Set returned while the pending value was not read")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if got := m.GetNow(); got != CCModeOn {
+		t.Fatalf("expected %s, got %s", CCModeOn, got)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Set still blocked after the pending value was read")
+	}
+	if got := m.GetNow(); got != CCModeOff {
+		t.Errorf("expected %s, got %s", CCModeOff, got)
+	}
+}
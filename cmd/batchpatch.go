@@ -0,0 +1,163 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// JSONPatchOp is an RFC 6902 patch operation. Value is always encoded since
+// an empty string, 0 or false are valid values of an add.
+type JSONPatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value"`
+}
+
+// BatchNodePatcher accumulates JSON patch operations on the current node and
+// sends them together, at most size operations per API call, when flushed.
+type BatchNodePatcher struct {
+	patcher *NodeLabelPatcher
+	size    int
+
+	mutex sync.Mutex
+	ops   []json.RawMessage
+}
+
+func NewBatchNodePatcher(patcher *NodeLabelPatcher, size int) *BatchNodePatcher {
+	return &BatchNodePatcher{
+		patcher: patcher,
+		size:    size,
+	}
+}
+
+// Queue adds op to the next Flush.
+func (b *BatchNodePatcher) Queue(op JSONPatchOp) error {
+	raw, err := json.Marshal(op)
+	if err != nil {
+		return fmt.Errorf("error encoding patch operation: %s", err)
+	}
+	b.QueueRaw(raw)
+	return nil
+}
+
+// QueueRaw adds an encoded operation, such as one of --json-patch-ops, to the
+// next Flush as is.
+func (b *BatchNodePatcher) QueueRaw(op json.RawMessage) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.ops = append(b.ops, op)
+}
+
+// QueueValues queues an add operation for every key of values in
+// metadata.<field>, labels or annotations, in key order.
+func (b *BatchNodePatcher) QueueValues(field string, values map[string]string) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		err := b.Queue(JSONPatchOp{
+			Op:    "add",
+			Path:  fmt.Sprintf("/metadata/%s/%s", field, escapeJSONPointer(key)),
+			Value: values[key],
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush sends the queued operations in JSON patches of at most size
+// operations each. Operations of a failed patch and the following ones are
+// dropped.
+func (b *BatchNodePatcher) Flush(ctx context.Context) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ops := b.ops
+	b.ops = nil
+
+	for len(ops) != 0 {
+		n := len(ops)
+		if b.size > 0 && n > b.size {
+			n = b.size
+		}
+		patch, err := json.Marshal(ops[:n])
+		if err != nil {
+			return fmt.Errorf("error encoding patch: %s", err)
+		}
+		_, err = b.patcher.clientset.CoreV1().Nodes().Patch(ctx, b.patcher.nodeName, types.JSONPatchType, patch, metav1.PatchOptions{})
+		if err != nil {
+			return fmt.Errorf("error patching node: %s", err)
+		}
+		ops = ops[n:]
+	}
+	return nil
+}
+
+// writeCCModeChangeBatch writes the applied annotations, the operations of
+// --json-patch-ops and the --node-annotations-to-labels labels with as few API
+// calls as --node-update-batch-size allows.
+func writeCCModeChangeBatch(ctx context.Context, patcher *NodeLabelPatcher, annotations *NodeAnnotationCache, mode string) error {
+	applied := appliedAnnotations(mode)
+
+	batch := NewBatchNodePatcher(patcher, nodeUpdateBatchSizeFlag)
+	if err := batch.QueueValues("annotations", applied); err != nil {
+		return err
+	}
+	for _, raw := range jsonPatchOps {
+		batch.QueueRaw(raw)
+	}
+	if len(annotationLabelMappings) != 0 {
+		current, err := annotations.Get(ctx)
+		if err != nil {
+			return err
+		}
+		merged := make(map[string]string, len(current)+len(applied))
+		for key, value := range current {
+			merged[key] = value
+		}
+		for key, value := range applied {
+			merged[key] = value
+		}
+		if err := batch.QueueValues("labels", annotationLabels(merged, annotationLabelMappings)); err != nil {
+			return err
+		}
+	}
+
+	started := time.Now()
+	err := batch.Flush(ctx)
+	annotations.Invalidate()
+	if err != nil {
+		return err
+	}
+	log.Debugf("Wrote node annotations and labels in %s", time.Since(started))
+	return nil
+}
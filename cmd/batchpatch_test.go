@@ -0,0 +1,187 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// recordedPatch is a patch request received by the API server of
+// newStrategyPatchRecorder.
+type recordedPatch struct {
+	contentType string
+	query       url.Values
+	body        string
+}
+
+// newPatchRecorder returns a NodeLabelPatcher for node "test" whose API
+// server answers every JSON patch with an empty node, and the patches it
+// received so far. Patches are failed from failAt on if it is positive.
+func newPatchRecorder(t *testing.T, failAt int) (*NodeLabelPatcher, func() []string) {
+	patcher, requests := newStrategyPatchRecorder(t, LabelPatchJSON, failAt)
+	return patcher, func() []string {
+		var patches []string
+		for _, request := range requests() {
+			if request.contentType != string(types.JSONPatchType) {
+				t.Errorf("unexpected patch type %s", request.contentType)
+			}
+			patches = append(patches, request.body)
+		}
+		return patches
+	}
+}
+
+// newStrategyPatchRecorder is newPatchRecorder for any patch strategy,
+// recording the content type and query of every patch along with its body.
+func newStrategyPatchRecorder(t *testing.T, strategy LabelPatchStrategy, failAt int) (*NodeLabelPatcher, func() []recordedPatch) {
+	var mutex sync.Mutex
+	var patches []recordedPatch
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch || r.URL.Path != "/api/v1/nodes/test" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mutex.Lock()
+		patches = append(patches, recordedPatch{
+			contentType: r.Header.Get("Content-Type"),
+			query:       r.URL.Query(),
+			body:        string(body),
+		})
+		failed := failAt > 0 && len(patches) >= failAt
+		mutex.Unlock()
+		if failed {
+			http.Error(w, "patch failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"kind":"Node","apiVersion":"v1","metadata":{"name":"test"}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewNodeLabelPatcher(clientset, "test", strategy), func() []recordedPatch {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]recordedPatch(nil), patches...)
+	}
+}
+
+func TestBatchNodePatcherFlush(t *testing.T) {
+	add := func(path, value string) string {
+		return `{"op":"add","path":"` + path + `","value":"` + value + `"}`
+	}
+	tests := []struct {
+		name    string
+		size    int
+		values  map[string]string
+		raw     []string
+		failAt  int
+		expect  []string
+		wantErr bool
+	}{
+		{"nothing queued", 2, nil, nil, 0, nil, false},
+		{
+			"single patch",
+			0,
+			map[string]string{"b": "2", "a/x~y": "1"},
+			nil,
+			0,
+			[]string{"[" + add("/metadata/annotations/a~1x~0y", "1") + "," + add("/metadata/annotations/b", "2") + "]"},
+			false,
+		},
+		{
+			"empty value is encoded",
+			2,
+			map[string]string{"a": ""},
+			nil,
+			0,
+			[]string{"[" + add("/metadata/annotations/a", "") + "]"},
+			false,
+		},
+		{
+			"split by size",
+			2,
+			map[string]string{"a": "1", "b": "2"},
+			[]string{`{"op":"remove","path":"/metadata/labels/c"}`},
+			0,
+			[]string{
+				"[" + add("/metadata/annotations/a", "1") + "," + add("/metadata/annotations/b", "2") + "]",
+				`[{"op":"remove","path":"/metadata/labels/c"}]`,
+			},
+			false,
+		},
+		{
+			"stops at the failed patch",
+			1,
+			map[string]string{"a": "1", "b": "2", "c": "3"},
+			nil,
+			2,
+			[]string{
+				"[" + add("/metadata/annotations/a", "1") + "]",
+				"[" + add("/metadata/annotations/b", "2") + "]",
+			},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patcher, patches := newPatchRecorder(t, tt.failAt)
+			batch := NewBatchNodePatcher(patcher, tt.size)
+			if err := batch.QueueValues("annotations", tt.values); err != nil {
+				t.Fatal(err)
+			}
+			for _, raw := range tt.raw {
+				batch.QueueRaw(json.RawMessage(raw))
+			}
+
+			err := batch.Flush(context.Background())
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("unexpected error result: %v", err)
+			}
+			if !reflect.DeepEqual(patches(), tt.expect) {
+				t.Errorf("expected patches %v, got %v", tt.expect, patches())
+			}
+
+			// queued operations are sent once only, even after a failure
+			if err := batch.Flush(context.Background()); err != nil {
+				t.Fatalf("unexpected error flushing again: %s", err)
+			}
+			if len(patches()) != len(tt.expect) {
+				t.Errorf("expected no patch on the second Flush, got %v", patches()[len(tt.expect):])
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CCModeCapabilityMatrix records the CC modes each GPU of the node supports,
+// as reported by cc-manager.sh list-modes.
+type CCModeCapabilityMatrix struct {
+	modes map[string][]string
+}
+
+// LoadCCModeCapabilityMatrix queries the supported CC modes of every CC
+// capable GPU of the node.
+func LoadCCModeCapabilityMatrix() (*CCModeCapabilityMatrix, error) {
+	state, err := QueryResourceState()
+	if err != nil {
+		return nil, err
+	}
+	m := &CCModeCapabilityMatrix{modes: make(map[string][]string)}
+	for _, gpu := range state.GPUs {
+		output, err := execScriptOutput([]string{"list-modes", "-d", gpu.ID})
+		if err != nil {
+			return nil, NewDeviceError(gpu.ID, fmt.Errorf("error listing supported cc modes: %w", err))
+		}
+		modes := strings.Fields(string(output))
+		log.Infof("GPU %s supports CC modes %s", gpu.ID, strings.Join(modes, ", "))
+		m.modes[gpu.ID] = modes
+	}
+	return m, nil
+}
+
+// Check returns a DeviceError for every GPU that does not support mode. It is
+// a no-op on a nil matrix.
+func (m *CCModeCapabilityMatrix) Check(mode string) error {
+	if m == nil {
+		return nil
+	}
+	var ids []string
+	for id := range m.modes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var errs []error
+	for _, id := range ids {
+		if !containsString(m.modes[id], mode) {
+			errs = append(errs, NewDeviceError(id, fmt.Errorf("cc mode '%s' is not supported, supported modes are %s", mode, strings.Join(m.modes[id], ", "))))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CheckPolicy checks the default and every process mode of policy.
+func (m *CCModeCapabilityMatrix) CheckPolicy(policy CCModePolicy) error {
+	errs := []error{m.Check(policy.Default)}
+	for _, p := range policy.Processes {
+		if err := m.Check(p.Mode); err != nil {
+			errs = append(errs, fmt.Errorf("process '%s': %w", p.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	CCModeOn       = "on"
+	CCModeOff      = "off"
+	CCModeDevtools = "devtools"
+)
+
+// ValidCCModes lists the CC modes understood by cc-manager.sh.
+var ValidCCModes = []string{CCModeOn, CCModeOff, CCModeDevtools}
+
+// CCModePolicy is the content of the nvidia.com/cc.mode.policy annotation.
+type CCModePolicy struct {
+	Default   string                `json:"default"`
+	Processes []CCModeProcessPolicy `json:"processes,omitempty"`
+}
+
+// CCModeProcessPolicy overrides the default CC mode for a named process.
+type CCModeProcessPolicy struct {
+	Name string `json:"name"`
+	Mode string `json:"mode"`
+}
+
+// ValidateCCMode returns an error if mode is not a CC mode supported by
+// cc-manager.sh. Whether the GPUs of the node support it is checked by
+// CCModeCapabilityMatrix.Check when the mode is applied.
+func ValidateCCMode(mode string) error {
+	for _, m := range ValidCCModes {
+		if mode == m {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid cc mode '%s', must be one of %s", mode, strings.Join(ValidCCModes, ", "))
+}
+
+// ParseCCModePolicy parses and validates the JSON content of the
+// nvidia.com/cc.mode.policy annotation.
+func ParseCCModePolicy(annotation string) (CCModePolicy, error) {
+	var policy CCModePolicy
+
+	decoder := json.NewDecoder(bytes.NewBufferString(annotation))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&policy); err != nil {
+		return CCModePolicy{}, fmt.Errorf("error parsing cc mode policy: %s", err)
+	}
+
+	if err := ValidateCCMode(policy.Default); err != nil {
+		return CCModePolicy{}, fmt.Errorf("invalid default in cc mode policy: %s", err)
+	}
+	seen := make(map[string]bool)
+	for _, p := range policy.Processes {
+		if p.Name == "" {
+			return CCModePolicy{}, fmt.Errorf("process entry without a name in cc mode policy")
+		}
+		if seen[p.Name] {
+			return CCModePolicy{}, fmt.Errorf("duplicate process '%s' in cc mode policy", p.Name)
+		}
+		seen[p.Name] = true
+		if err := ValidateCCMode(p.Mode); err != nil {
+			return CCModePolicy{}, fmt.Errorf("invalid mode for process '%s' in cc mode policy: %s", p.Name, err)
+		}
+	}
+
+	return policy, nil
+}
+
+// isCCModePolicy reports whether a value read from SyncableCCModeConfig is the
+// content of a policy annotation rather than a plain CC mode label.
+func isCCModePolicy(value string) bool {
+	return strings.HasPrefix(strings.TrimSpace(value), "{")
+}
+
+// ParseCCModeTemplate parses a --cc-mode-template and evaluates it once
+// against an empty label set so that errors surface at startup. Missing
+// labels evaluate to the empty string.
+func ParseCCModeTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("cc-mode").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := EvaluateCCModeTemplate(tmpl, map[string]string{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// EvaluateCCModeTemplate executes tmpl against the node labels. The result,
+// with surrounding whitespace removed, must be a valid CC mode or empty, in
+// which case the default CC mode applies.
+func EvaluateCCModeTemplate(tmpl *template.Template, labels map[string]string) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, labels); err != nil {
+		return "", fmt.Errorf("error evaluating cc mode template: %s", err)
+	}
+	mode := strings.TrimSpace(buf.String())
+	if mode == "" {
+		return "", nil
+	}
+	if err := ValidateCCMode(mode); err != nil {
+		return mode, fmt.Errorf("cc mode template: %s", err)
+	}
+	return mode, nil
+}
+
+var ccModeTemplateMutex sync.Mutex
+
+func getCCModeTemplate() *template.Template {
+	ccModeTemplateMutex.Lock()
+	defer ccModeTemplateMutex.Unlock()
+	return ccModeTemplate
+}
+
+// setCCModeTemplate replaces --cc-mode-template at runtime, nil disables it.
+func setCCModeTemplate(tmpl *template.Template) {
+	ccModeTemplateMutex.Lock()
+	defer ccModeTemplateMutex.Unlock()
+	ccModeTemplate = tmpl
+}
+
+// getCCModeConfig returns the CC mode configuration requested for the node.
+// With the CCModePolicy feature enabled, a non-empty policy annotation takes
+// precedence over the CC mode label. With --cc-mode-template set, the CC mode
+// is computed from the node labels instead of read from the CC mode label.
+func getCCModeConfig(node *v1.Node) string {
+	if featureGates.Enabled(FeatureCCModePolicy) {
+		if policy := node.Annotations[CCModePolicyAnnotation]; policy != "" {
+			return policy
+		}
+	}
+	if tmpl := getCCModeTemplate(); tmpl != nil {
+		mode, err := EvaluateCCModeTemplate(tmpl, node.Labels)
+		if err != nil {
+			// an invalid result is still returned so that applying it fails
+			// visibly instead of silently falling back to the default
+			log.Errorf("Error: %s", err)
+		}
+		return mode
+	}
+	return node.Labels[CCModeConfigLabel]
+}
@@ -0,0 +1,246 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	// embed the timezone database so that --change-window-timezone works in
+	// images without /usr/share/zoneinfo
+	_ "time/tzdata"
+)
+
+const (
+	// DefaultChangeWindowDuration is how long a change window stays open when
+	// its specification does not set a duration.
+	DefaultChangeWindowDuration = time.Hour
+
+	maxChangeWindowDuration = 7 * 24 * time.Hour
+	// changeWindowSearchLimit bounds the search for the next opening of a
+	// change window.
+	changeWindowSearchLimit = 366 * 24 * time.Hour
+)
+
+// ChangeWindow is a recurring period during which CC mode changes may be
+// applied. It opens at every minute matching the cron schedule, in Location,
+// and stays open for Duration.
+type ChangeWindow struct {
+	Spec     string
+	Duration time.Duration
+	Location *time.Location
+
+	minutes  map[int]bool
+	hours    map[int]bool
+	days     map[int]bool
+	months   map[int]bool
+	weekdays map[int]bool
+	// anyDay and anyWeekday record unrestricted fields, which changes how
+	// days and weekdays combine as in cron(8)
+	anyDay     bool
+	anyWeekday bool
+}
+
+// ParseChangeWindows parses a semicolon-separated list of change windows.
+// Each window is a standard 5-field cron schedule, optionally followed by how
+// long the window stays open, e.g. "0 2 * * 6,0 4h". Schedules are evaluated
+// in loc.
+func ParseChangeWindows(value string, loc *time.Location) ([]ChangeWindow, error) {
+	var windows []ChangeWindow
+	for _, spec := range strings.Split(value, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		window, err := parseChangeWindow(spec, loc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid change window '%s': %s", spec, err)
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+func parseChangeWindow(spec string, loc *time.Location) (ChangeWindow, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 && len(fields) != 6 {
+		return ChangeWindow{}, fmt.Errorf("expected 5 cron fields and an optional duration, got %d fields", len(fields))
+	}
+
+	window := ChangeWindow{
+		Spec:     spec,
+		Duration: DefaultChangeWindowDuration,
+		Location: loc,
+	}
+	if len(fields) == 6 {
+		duration, err := time.ParseDuration(fields[5])
+		if err != nil {
+			return ChangeWindow{}, fmt.Errorf("invalid duration: %s", err)
+		}
+		if duration < time.Minute || duration > maxChangeWindowDuration {
+			return ChangeWindow{}, fmt.Errorf("duration must be between %s and %s", time.Minute, maxChangeWindowDuration)
+		}
+		window.Duration = duration
+	}
+
+	var err error
+	if window.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return ChangeWindow{}, fmt.Errorf("invalid minute field: %s", err)
+	}
+	if window.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return ChangeWindow{}, fmt.Errorf("invalid hour field: %s", err)
+	}
+	if window.days, err = parseCronField(fields[2], 1, 31); err != nil {
+		return ChangeWindow{}, fmt.Errorf("invalid day of month field: %s", err)
+	}
+	if window.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return ChangeWindow{}, fmt.Errorf("invalid month field: %s", err)
+	}
+	if window.weekdays, err = parseCronField(fields[4], 0, 7); err != nil {
+		return ChangeWindow{}, fmt.Errorf("invalid day of week field: %s", err)
+	}
+	// both 0 and 7 are Sunday
+	if window.weekdays[7] {
+		window.weekdays[0] = true
+	}
+	window.anyDay = strings.HasPrefix(fields[2], "*")
+	window.anyWeekday = strings.HasPrefix(fields[4], "*")
+
+	if NextChangeWindow(time.Now(), []ChangeWindow{window}).IsZero() {
+		return ChangeWindow{}, fmt.Errorf("schedule never matches")
+	}
+	return window, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges (a-b), and
+// steps (*/n, a-b/n) between min and max.
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step '%s'", stepPart)
+			}
+		}
+
+		low, high := min, max
+		if rangePart != "*" {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = strconv.Atoi(lowPart); err != nil {
+				return nil, fmt.Errorf("invalid value '%s'", lowPart)
+			}
+			high = low
+			if isRange {
+				if high, err = strconv.Atoi(highPart); err != nil {
+					return nil, fmt.Errorf("invalid value '%s'", highPart)
+				}
+			} else if hasStep {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return nil, fmt.Errorf("'%s' out of range %d-%d", part, min, max)
+		}
+		for v := low; v <= high; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+// opensAt reports whether the window opens at the minute of t.
+func (w *ChangeWindow) opensAt(t time.Time) bool {
+	t = t.In(w.Location)
+	if !w.minutes[t.Minute()] || !w.hours[t.Hour()] || !w.months[int(t.Month())] {
+		return false
+	}
+	day := w.days[t.Day()]
+	weekday := w.weekdays[int(t.Weekday())]
+	switch {
+	case w.anyDay && w.anyWeekday:
+		return true
+	case w.anyDay:
+		return weekday
+	case w.anyWeekday:
+		return day
+	default:
+		// as in cron(8), a restricted day of month and day of week match
+		// if either does
+		return day || weekday
+	}
+}
+
+// IsInChangeWindow reports whether t falls within one of the windows.
+func IsInChangeWindow(t time.Time, windows []ChangeWindow) bool {
+	for i := range windows {
+		w := &windows[i]
+		start := t.Truncate(time.Minute)
+		for opening := start; t.Sub(opening) < w.Duration; opening = opening.Add(-time.Minute) {
+			if w.opensAt(opening) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NextChangeWindow returns the earliest time after t at which one of the
+// windows opens, or the zero time if none opens within a year.
+func NextChangeWindow(t time.Time, windows []ChangeWindow) time.Time {
+	end := t.Add(changeWindowSearchLimit)
+	for opening := t.Truncate(time.Minute).Add(time.Minute); opening.Before(end); opening = opening.Add(time.Minute) {
+		for i := range windows {
+			if windows[i].opensAt(opening) {
+				return opening
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// WaitForChangeWindow returns once t falls within one of the windows, which
+// is checked again every time a window was due to open. It reports whether it
+// had to wait, and fails if no window opens within a year or ctx is done.
+func WaitForChangeWindow(ctx context.Context, windows []ChangeWindow, mode string) (bool, error) {
+	waited := false
+	for !IsInChangeWindow(time.Now(), windows) {
+		opens := NextChangeWindow(time.Now(), windows)
+		if opens.IsZero() {
+			return waited, fmt.Errorf("no change window opens within %s", changeWindowSearchLimit)
+		}
+		log.Infof("Outside of the change window, deferring change to '%s' until %s", mode, opens)
+		waited = true
+		timer := time.NewTimer(time.Until(opens))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return waited, ctx.Err()
+		}
+	}
+	return waited, nil
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseChangeWindows(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		durations []time.Duration
+		wantErr   bool
+	}{
+		{"empty", "", nil, false},
+		{"default duration", "0 2 * * *", []time.Duration{DefaultChangeWindowDuration}, false},
+		{"explicit duration", "0 2 * * 6,0 4h", []time.Duration{4 * time.Hour}, false},
+		{"several windows", "0 2 * * 6 ; 30 22 * * 1-5 30m;", []time.Duration{time.Hour, 30 * time.Minute}, false},
+		{"steps and ranges", "*/15 0-6/2 1,15 * *", []time.Duration{time.Hour}, false},
+		{"sunday as 7", "0 0 * * 7", []time.Duration{time.Hour}, false},
+		{"too few fields", "0 2 * *", nil, true},
+		{"too many fields", "0 2 * * * 1h 1h", nil, true},
+		{"invalid duration", "0 2 * * * forever", nil, true},
+		{"duration too short", "0 2 * * * 30s", nil, true},
+		{"duration too long", "0 2 * * * 169h", nil, true},
+		{"minute out of range", "60 2 * * *", nil, true},
+		{"hour out of range", "0 24 * * *", nil, true},
+		{"day out of range", "0 2 0 * *", nil, true},
+		{"inverted range", "0 6-2 * * *", nil, true},
+		{"invalid step", "*/0 2 * * *", nil, true},
+		{"not a number", "a 2 * * *", nil, true},
+		{"never matches", "0 0 30 2 *", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			windows, err := ParseChangeWindows(tt.value, time.UTC)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error parsing '%s'", tt.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(windows) != len(tt.durations) {
+				t.Fatalf("expected %d windows, got %d", len(tt.durations), len(windows))
+			}
+			for i, w := range windows {
+				if w.Duration != tt.durations[i] {
+					t.Errorf("window %d: expected duration %s, got %s", i, tt.durations[i], w.Duration)
+				}
+			}
+		})
+	}
+}
+
+func TestIsInChangeWindow(t *testing.T) {
+	// 2023-06-03 is a Saturday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2023, time.June, day, hour, minute, 30, 0, time.UTC)
+	}
+	tests := []struct {
+		name   string
+		value  string
+		loc    *time.Location
+		t      time.Time
+		expect bool
+	}{
+		{"at the opening", "0 2 * * *", time.UTC, at(3, 2, 0), true},
+		{"last minute of the window", "0 2 * * *", time.UTC, at(3, 2, 59), true},
+		{"after the window", "0 2 * * *", time.UTC, at(3, 3, 0), false},
+		{"before the window", "0 2 * * *", time.UTC, at(3, 1, 59), false},
+		{"window across midnight", "0 23 * * * 2h", time.UTC, at(4, 0, 30), true},
+		{"matching weekday", "0 2 * * 6", time.UTC, at(3, 2, 10), true},
+		{"other weekday", "0 2 * * 6", time.UTC, at(5, 2, 10), false},
+		{"sunday as 7", "0 2 * * 7", time.UTC, at(4, 2, 10), true},
+		// as in cron(8), day of month and day of week match if either does
+		{"day of month or weekday", "0 2 1 * 1", time.UTC, at(5, 2, 10), true},
+		{"neither day of month nor weekday", "0 2 1 * 1", time.UTC, at(6, 2, 10), false},
+		{"other location", "0 2 * * *", time.FixedZone("UTC+2", 2*60*60), at(3, 0, 10), true},
+		{"second window", "0 2 * * 1; 0 12 * * *", time.UTC, at(3, 12, 5), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			windows, err := ParseChangeWindows(tt.value, tt.loc)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got := IsInChangeWindow(tt.t, windows); got != tt.expect {
+				t.Errorf("IsInChangeWindow(%s) = %t, expected %t", tt.t, got, tt.expect)
+			}
+		})
+	}
+}
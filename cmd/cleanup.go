@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// cleanupAnnotations and cleanupConditions are removed from the node by
+// --cleanup-on-exit.
+var (
+	cleanupAnnotations = []string{CCModeAppliedAnnotation, CCModeAppliedAtAnnotation}
+	cleanupConditions  = []v1.NodeConditionType{
+		CCModeReadyCondition,
+		CCModeScriptHealthyCondition,
+		CCModeDevicesAvailableCondition,
+		CCModeAlertCondition,
+	}
+)
+
+// CleanupOnExit removes the node annotations and conditions written by the
+// daemon once it receives SIGTERM or SIGINT, then exits.
+func CleanupOnExit(clientset *kubernetes.Clientset, nodeName string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		log.Infof("Received %s, removing node annotations and conditions before exiting", sig)
+		if err := cleanupNode(context.Background(), clientset, nodeName); err != nil {
+			log.Errorf("Error cleaning up node %s: %s", nodeName, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}()
+}
+
+// cleanupNode removes the daemon's annotations and conditions from the node,
+// retrying until the patches succeed. A node that no longer exists is
+// skipped.
+func cleanupNode(ctx context.Context, clientset *kubernetes.Clientset, nodeName string) error {
+	annotations := make(map[string]interface{})
+	for _, key := range cleanupAnnotations {
+		log.Infof("Removing node annotation %s", key)
+		annotations[key] = nil
+	}
+	annotationPatch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding node annotations patch: %s", err)
+	}
+
+	var conditions []map[string]interface{}
+	for _, conditionType := range cleanupConditions {
+		log.Infof("Removing node condition %s", conditionType)
+		conditions = append(conditions, map[string]interface{}{
+			"type":   conditionType,
+			"$patch": "delete",
+		})
+	}
+	conditionPatch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": conditions,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding node conditions patch: %s", err)
+	}
+
+	retriable := func(err error) bool { return !apierrors.IsNotFound(err) }
+	err = retry.OnError(retry.DefaultBackoff, retriable, func() error {
+		_, err := clientset.CoreV1().Nodes().Patch(ctx, nodeName, types.MergePatchType, annotationPatch, metav1.PatchOptions{})
+		return err
+	})
+	if err == nil {
+		err = retry.OnError(retry.DefaultBackoff, retriable, func() error {
+			_, err := clientset.CoreV1().Nodes().Patch(ctx, nodeName, types.StrategicMergePatchType, conditionPatch, metav1.PatchOptions{}, "status")
+			return err
+		})
+	}
+	if apierrors.IsNotFound(err) {
+		log.Infof("Node %s no longer exists, nothing to clean up", nodeName)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error patching node: %s", err)
+	}
+	return nil
+}
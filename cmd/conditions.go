@@ -0,0 +1,166 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	CCModeReadyCondition            v1.NodeConditionType = "NVIDIACCModeReady"
+	CCModeScriptHealthyCondition    v1.NodeConditionType = "NVIDIACCModeScriptHealthy"
+	CCModeDevicesAvailableCondition v1.NodeConditionType = "NVIDIACCModeDevicesAvailable"
+)
+
+// StatusConditionController reconciles the node conditions owned by the
+// daemon. Conditions are recorded with SetCondition and written together in
+// a single strategic merge patch of node.status.conditions by Sync, which
+// only patches the node when a condition differs from the last one written.
+type StatusConditionController struct {
+	clientset *kubernetes.Clientset
+	nodeName  string
+
+	mutex   sync.Mutex
+	desired map[v1.NodeConditionType]v1.NodeCondition
+	written map[v1.NodeConditionType]v1.NodeCondition
+}
+
+func NewStatusConditionController(clientset *kubernetes.Clientset, nodeName string) *StatusConditionController {
+	return &StatusConditionController{
+		clientset: clientset,
+		nodeName:  nodeName,
+		desired:   make(map[v1.NodeConditionType]v1.NodeCondition),
+		written:   make(map[v1.NodeConditionType]v1.NodeCondition),
+	}
+}
+
+// SetCondition records the desired state of a condition without writing it.
+func (c *StatusConditionController) SetCondition(conditionType v1.NodeConditionType, status v1.ConditionStatus, reason, message string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.desired[conditionType] = v1.NodeCondition{
+		Type:    conditionType,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	}
+}
+
+// Sync writes all conditions that changed since the last successful Sync in a
+// single patch. It is a no-op when nothing changed.
+func (c *StatusConditionController) Sync(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := metav1.Now()
+	var changed []v1.NodeCondition
+	for conditionType, condition := range c.desired {
+		last, ok := c.written[conditionType]
+		if ok && conditionEqual(last, condition) {
+			continue
+		}
+		condition.LastHeartbeatTime = now
+		condition.LastTransitionTime = now
+		if ok && last.Status == condition.Status {
+			condition.LastTransitionTime = last.LastTransitionTime
+		}
+		changed = append(changed, condition)
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i].Type < changed[j].Type })
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": changed,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding node conditions patch: %s", err)
+	}
+
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		_, err := c.clientset.CoreV1().Nodes().Patch(ctx, c.nodeName, types.StrategicMergePatchType, patch, metav1.PatchOptions{}, "status")
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error patching node conditions: %s", err)
+	}
+
+	for _, condition := range changed {
+		c.written[condition.Type] = condition
+	}
+	return nil
+}
+
+// setScriptHealthyCondition records whether cc-manager.sh could be run and
+// succeeded the last time a CC mode change ran it. Errors raised before the
+// script runs, such as an invalid mode, leave the condition as it was.
+func setScriptHealthyCondition(c *StatusConditionController, err error) {
+	switch {
+	case err == nil:
+		c.SetCondition(CCModeScriptHealthyCondition, v1.ConditionTrue, "ScriptSucceeded", "cc-manager.sh succeeded")
+	case isScriptError(err):
+		c.SetCondition(CCModeScriptHealthyCondition, v1.ConditionFalse, "ScriptFailed", err.Error())
+	}
+}
+
+// isScriptError reports whether err comes from starting or running
+// cc-manager.sh rather than from a check made before.
+func isScriptError(err error) bool {
+	var exitErr *exec.ExitError
+	var execErr *exec.Error
+	var pathErr *fs.PathError
+	return errors.As(err, &exitErr) || errors.As(err, &execErr) || errors.As(err, &pathErr)
+}
+
+// setDevicesAvailableCondition records whether sysfs lists GPUs for
+// cc-manager.sh to change the CC mode of and none of them is excluded from CC
+// mode changes as unhealthy.
+func setDevicesAvailableCondition(c *StatusConditionController, health *DeviceHealthMap) {
+	gpus, err := listNVIDIAGPUs()
+	unhealthy := health.Unhealthy()
+	switch {
+	case err != nil:
+		c.SetCondition(CCModeDevicesAvailableCondition, v1.ConditionUnknown, "DeviceListFailed", err.Error())
+	case len(gpus) == 0:
+		c.SetCondition(CCModeDevicesAvailableCondition, v1.ConditionFalse, "NoDevices", fmt.Sprintf("No NVIDIA GPU found in %s", sysfsPCIDevicesDir))
+	case len(unhealthy) != 0:
+		c.SetCondition(CCModeDevicesAvailableCondition, v1.ConditionFalse, "DevicesUnhealthy", fmt.Sprintf("GPUs excluded from cc mode changes: %s", strings.Join(unhealthy, ", ")))
+	default:
+		c.SetCondition(CCModeDevicesAvailableCondition, v1.ConditionTrue, "DevicesFound", fmt.Sprintf("GPUs %s are available", strings.Join(gpus, ", ")))
+	}
+}
+
+func conditionEqual(a, b v1.NodeCondition) bool {
+	return a.Status == b.Status && a.Reason == b.Reason && a.Message == b.Message
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const coordinationRetryInterval = 5 * time.Second
+
+var invalidLeaseNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// ChangeCoordinator lets only one node of a coordination group, the nodes
+// sharing the value of --coordination-group-label such as a rack, change its
+// CC mode at a time. The token is a Lease named after the group, held for the
+// duration of the change. A Lease not released before it expires, e.g.
+// because its holder crashed, can be taken over.
+type ChangeCoordinator struct {
+	clientset *kubernetes.Clientset
+	namespace string
+	name      string
+	group     string
+	holder    string
+	duration  time.Duration
+}
+
+func NewChangeCoordinator(clientset *kubernetes.Clientset, namespace, group, holder string, duration time.Duration) *ChangeCoordinator {
+	return &ChangeCoordinator{
+		clientset: clientset,
+		namespace: namespace,
+		name:      coordinationLeaseName(group),
+		group:     group,
+		holder:    holder,
+		duration:  duration,
+	}
+}
+
+// coordinationLeaseName returns a valid Lease name for the group.
+func coordinationLeaseName(group string) string {
+	name := invalidLeaseNameChars.ReplaceAllString(strings.ToLower(group), "-")
+	return strings.Trim("cc-manager-coordination-"+name, "-.")
+}
+
+// Acquire waits until no other node of the group holds the Lease and takes it.
+func (c *ChangeCoordinator) Acquire(ctx context.Context) error {
+	for {
+		acquired, err := c.tryAcquire(ctx)
+		if err != nil && !apierrors.IsConflict(err) && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("error acquiring coordination Lease %s/%s: %s", c.namespace, c.name, err)
+		}
+		if acquired {
+			log.Infof("Acquired coordination Lease %s/%s of group '%s'", c.namespace, c.name, c.group)
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(coordinationRetryInterval):
+		}
+	}
+}
+
+func (c *ChangeCoordinator) tryAcquire(ctx context.Context) (bool, error) {
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(c.duration / time.Second)
+	leases := c.clientset.CoordinationV1().Leases(c.namespace)
+
+	lease, err := leases.Get(ctx, c.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err := leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: c.name, Namespace: c.namespace},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &c.holder,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}, metav1.CreateOptions{})
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if holder := lease.Spec.HolderIdentity; holder != nil && *holder != "" && *holder != c.holder {
+		expires := leaseExpiry(lease)
+		if time.Now().Before(expires) {
+			log.Infof("Coordination group '%s' is changing CC mode on %s until at most %s, waiting", c.group, *holder, expires.Format(time.RFC3339))
+			return false, nil
+		}
+		log.Warnf("Taking over coordination Lease %s/%s of %s which expired at %s", c.namespace, c.name, *holder, expires.Format(time.RFC3339))
+	}
+
+	lease.Spec.HolderIdentity = &c.holder
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.AcquireTime = &now
+	lease.Spec.RenewTime = &now
+	// Update sends the resourceVersion read above and fails with a conflict
+	// if another node took the Lease in between
+	if _, err := leases.Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func leaseExpiry(lease *coordinationv1.Lease) time.Time {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return time.Time{}
+	}
+	return lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+}
+
+// Release hands the Lease back to the group if it is still held by this node.
+func (c *ChangeCoordinator) Release(ctx context.Context) error {
+	leases := c.clientset.CoordinationV1().Leases(c.namespace)
+	for {
+		lease, err := leases.Get(ctx, c.name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("error releasing coordination Lease %s/%s: %s", c.namespace, c.name, err)
+		}
+		if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != c.holder {
+			log.Warnf("Coordination Lease %s/%s is no longer held by %s, not releasing it", c.namespace, c.name, c.holder)
+			return nil
+		}
+		lease.Spec.HolderIdentity = nil
+		lease.Spec.AcquireTime = nil
+		lease.Spec.RenewTime = nil
+		_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+		if apierrors.IsConflict(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error releasing coordination Lease %s/%s: %s", c.namespace, c.name, err)
+		}
+		return nil
+	}
+}
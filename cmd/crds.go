@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+const customResourceDefinitionsPath = "/apis/apiextensions.k8s.io/v1/customresourcedefinitions"
+
+// waitForCRDs returns once all the named CustomResourceDefinitions, such as
+// ccmodepolicies.nvidia.com, are registered, checking every interval. It
+// returns an error if some are still missing after timeout.
+func waitForCRDs(clientset *kubernetes.Clientset, names []string, interval, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		missing, err := missingCRDs(clientset, names)
+		if err != nil {
+			log.Warnf("Unable to check CustomResourceDefinitions: %s", err)
+		} else if len(missing) == 0 {
+			log.Infof("CustomResourceDefinitions registered: %s", strings.Join(names, ", "))
+			return nil
+		} else {
+			log.Infof("Waiting for CustomResourceDefinitions to be registered: %s", strings.Join(missing, ", "))
+		}
+		if time.Now().Add(interval).After(deadline) {
+			if err != nil {
+				return fmt.Errorf("timed out after %s checking CustomResourceDefinitions: %s", timeout, err)
+			}
+			return fmt.Errorf("timed out after %s waiting for CustomResourceDefinitions: %s", timeout, strings.Join(missing, ", "))
+		}
+		time.Sleep(interval)
+	}
+}
+
+// missingCRDs returns the names of the CustomResourceDefinitions that are not
+// registered. They are read with the discovery REST client, which does not
+// require the apiextensions clientset.
+func missingCRDs(clientset *kubernetes.Clientset, names []string) ([]string, error) {
+	var missing []string
+	for _, name := range names {
+		err := clientset.Discovery().RESTClient().Get().
+			AbsPath(customResourceDefinitionsPath, name).
+			Do(context.TODO()).
+			Error()
+		if apierrors.IsNotFound(err) {
+			missing = append(missing, name)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error getting CustomResourceDefinition %s: %s", name, err)
+		}
+	}
+	return missing, nil
+}
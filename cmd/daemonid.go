@@ -0,0 +1,35 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// daemonIDHook adds the --daemon-id to every log entry.
+type daemonIDHook struct {
+	id string
+}
+
+func (h *daemonIDHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *daemonIDHook) Fire(entry *log.Entry) error {
+	entry.Data["daemon_id"] = h.id
+	return nil
+}
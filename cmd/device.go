@@ -0,0 +1,208 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DeviceIDFormat is the device ID format expected by cc-manager.sh -d.
+type DeviceIDFormat string
+
+const (
+	// DeviceIDFormatRaw passes device IDs through unchanged.
+	DeviceIDFormatRaw DeviceIDFormat = ""
+	// DeviceIDFormatPCI formats PCI addresses as in sysfs, 0000:00:1e.0.
+	DeviceIDFormatPCI DeviceIDFormat = "pci"
+	// DeviceIDFormatUUID formats GPU UUIDs as GPU-xxxxxxxx-xxxx-....
+	DeviceIDFormatUUID DeviceIDFormat = "uuid"
+	// DeviceIDFormatBusID formats PCI addresses as nvidia-smi bus IDs,
+	// 00000000:00:1E.0.
+	DeviceIDFormatBusID DeviceIDFormat = "bus-id"
+)
+
+// ParseDeviceIDFormat parses the value of --device-id-format.
+func ParseDeviceIDFormat(s string) (DeviceIDFormat, error) {
+	switch format := DeviceIDFormat(strings.ToLower(strings.TrimSpace(s))); format {
+	case DeviceIDFormatRaw, DeviceIDFormatPCI, DeviceIDFormatUUID, DeviceIDFormatBusID:
+		return format, nil
+	}
+	return DeviceIDFormatRaw, fmt.Errorf("unknown device id format '%s', must be one of %s, %s, %s", s, DeviceIDFormatPCI, DeviceIDFormatUUID, DeviceIDFormatBusID)
+}
+
+// FormatDeviceID converts id to format. IDs that cannot be converted, such as
+// a PCI address requested as a UUID, are returned unchanged.
+func FormatDeviceID(id string, format DeviceIDFormat) string {
+	id = strings.TrimSpace(id)
+	switch format {
+	case DeviceIDFormatPCI, DeviceIDFormatBusID:
+		var domain, bus, device, function uint64
+		if _, err := fmt.Sscanf(strings.ToLower(id), "%x:%x:%x.%x", &domain, &bus, &device, &function); err != nil {
+			// addresses without a domain are in domain 0
+			domain = 0
+			if _, err := fmt.Sscanf(strings.ToLower(id), "%x:%x.%x", &bus, &device, &function); err != nil {
+				return id
+			}
+		}
+		if format == DeviceIDFormatBusID {
+			return fmt.Sprintf("%08X:%02X:%02X.%X", domain, bus, device, function)
+		}
+		return fmt.Sprintf("%04x:%02x:%02x.%x", domain, bus, device, function)
+	case DeviceIDFormatUUID:
+		if len(id) > 4 && strings.EqualFold(id[:4], "GPU-") {
+			return "GPU-" + strings.ToLower(id[4:])
+		}
+		return id
+	}
+	return id
+}
+
+// DeviceError is returned for failures scoped to a single device so that the
+// PCI address of the device is part of every error message built from it.
+type DeviceError struct {
+	DeviceID string
+	Err      error
+}
+
+// NewDeviceError wraps err with the PCI address of the device it applies to.
+func NewDeviceError(deviceID string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &DeviceError{DeviceID: deviceID, Err: err}
+}
+
+func (e *DeviceError) Error() string {
+	return fmt.Sprintf("device %s: %v", e.DeviceID, e.Err)
+}
+
+func (e *DeviceError) Unwrap() error {
+	return e.Err
+}
+
+// sysfsPCIDevicesDir holds one directory per PCI device, named after its
+// address.
+const sysfsPCIDevicesDir = "/sys/bus/pci/devices"
+
+// listNVIDIAGPUs returns the PCI addresses of the NVIDIA display and 3D
+// controllers in sysfs, the devices cc-manager.sh changes the CC mode of.
+func listNVIDIAGPUs() ([]string, error) {
+	entries, err := os.ReadDir(sysfsPCIDevicesDir)
+	if err != nil {
+		return nil, fmt.Errorf("error listing PCI devices: %s", err)
+	}
+	var gpus []string
+	for _, entry := range entries {
+		vendor, err := os.ReadFile(filepath.Join(sysfsPCIDevicesDir, entry.Name(), "vendor"))
+		if err != nil || strings.TrimSpace(string(vendor)) != "0x10de" {
+			continue
+		}
+		class, err := os.ReadFile(filepath.Join(sysfsPCIDevicesDir, entry.Name(), "class"))
+		if err != nil {
+			continue
+		}
+		if class := strings.TrimSpace(string(class)); class != "0x030000" && class != "0x030200" {
+			continue
+		}
+		gpus = append(gpus, entry.Name())
+	}
+	return gpus, nil
+}
+
+// DeviceFilterAttributes lists the device attributes --device-filter accepts.
+var DeviceFilterAttributes = []string{"numa_node", "pci_domain", "pci_bus", "pci_device", "pci_function"}
+
+// DeviceFilter selects devices by attribute, all of which must match. Values
+// are integers in any base understood by strconv.ParseInt, such as 0 or 0x1e.
+type DeviceFilter map[string]int64
+
+// ParseDeviceFilter parses a --device-filter expression of comma separated
+// attribute=value pairs, such as numa_node=0,pci_bus=0x00.
+func ParseDeviceFilter(s string) (DeviceFilter, error) {
+	filter := make(DeviceFilter)
+	for _, term := range strings.Split(s, ",") {
+		attribute, value, found := strings.Cut(strings.TrimSpace(term), "=")
+		if !found {
+			return nil, fmt.Errorf("invalid term '%s', expected attribute=value", term)
+		}
+		attribute = strings.TrimSpace(attribute)
+		if !containsString(DeviceFilterAttributes, attribute) {
+			return nil, fmt.Errorf("unknown attribute '%s', must be one of %s", attribute, strings.Join(DeviceFilterAttributes, ", "))
+		}
+		if _, ok := filter[attribute]; ok {
+			return nil, fmt.Errorf("duplicate attribute '%s'", attribute)
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for '%s': %s", attribute, err)
+		}
+		filter[attribute] = n
+	}
+	return filter, nil
+}
+
+// Match reports whether the device with PCI address id matches the filter.
+// The NUMA node is read from sysfs.
+func (f DeviceFilter) Match(id string) (bool, error) {
+	address := FormatDeviceID(id, DeviceIDFormatPCI)
+	var domain, bus, device, function int64
+	if _, err := fmt.Sscanf(address, "%x:%x:%x.%x", &domain, &bus, &device, &function); err != nil {
+		return false, fmt.Errorf("device %s: not a PCI address", id)
+	}
+	attributes := map[string]int64{
+		"pci_domain":   domain,
+		"pci_bus":      bus,
+		"pci_device":   device,
+		"pci_function": function,
+	}
+	if _, ok := f["numa_node"]; ok {
+		data, err := os.ReadFile(filepath.Join(sysfsPCIDevicesDir, address, "numa_node"))
+		if err != nil {
+			return false, NewDeviceError(id, fmt.Errorf("error reading NUMA node: %s", err))
+		}
+		node, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return false, NewDeviceError(id, fmt.Errorf("invalid NUMA node: %s", err))
+		}
+		attributes["numa_node"] = node
+	}
+	for attribute, value := range f {
+		if attributes[attribute] != value {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// FilterDeviceIDs returns the ids matching the filter.
+func FilterDeviceIDs(ids []string, filter DeviceFilter) ([]string, error) {
+	var matched []string
+	for _, id := range ids {
+		ok, err := filter.Match(id)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, id)
+		}
+	}
+	return matched, nil
+}
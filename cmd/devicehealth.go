@@ -0,0 +1,169 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// deviceHealthCommands are the cc-manager.sh commands that still see the GPUs
+// excluded by the DeviceHealthMonitor, so that they can be checked again.
+var deviceHealthCommands = map[string]bool{
+	"query":         true,
+	"device-health": true,
+}
+
+// DeviceHealthMap records the GPUs found unhealthy by the last health check.
+// It is safe for concurrent use and a nil map reports every GPU as healthy.
+type DeviceHealthMap struct {
+	mutex     sync.Mutex
+	unhealthy map[string]string
+}
+
+func NewDeviceHealthMap() *DeviceHealthMap {
+	return &DeviceHealthMap{unhealthy: make(map[string]string)}
+}
+
+// SetUnhealthy marks device unhealthy and reports whether it was healthy.
+func (m *DeviceHealthMap) SetUnhealthy(device, reason string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	_, ok := m.unhealthy[device]
+	m.unhealthy[device] = reason
+	return !ok
+}
+
+// SetHealthy marks device healthy and reports whether it was unhealthy.
+func (m *DeviceHealthMap) SetHealthy(device string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	_, ok := m.unhealthy[device]
+	delete(m.unhealthy, device)
+	return ok
+}
+
+// Healthy reports whether device was not found unhealthy.
+func (m *DeviceHealthMap) Healthy(device string) bool {
+	if m == nil {
+		return true
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	_, ok := m.unhealthy[device]
+	return !ok
+}
+
+// Unhealthy returns the sorted IDs of the unhealthy GPUs.
+func (m *DeviceHealthMap) Unhealthy() []string {
+	if m == nil {
+		return nil
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	devices := make([]string, 0, len(m.unhealthy))
+	for device := range m.unhealthy {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+	return devices
+}
+
+// DeviceHealthMonitor runs 'cc-manager.sh device-health' for every GPU of the
+// node at a fixed interval. GPUs that fail the check are recorded in a
+// DeviceHealthMap and excluded from CC mode changes until they pass again.
+type DeviceHealthMonitor struct {
+	health     *DeviceHealthMap
+	events     *NodeEventRecorder
+	conditions *StatusConditionController
+	interval   time.Duration
+}
+
+func NewDeviceHealthMonitor(health *DeviceHealthMap, interval time.Duration, events *NodeEventRecorder, conditions *StatusConditionController) *DeviceHealthMonitor {
+	return &DeviceHealthMonitor{
+		health:     health,
+		events:     events,
+		conditions: conditions,
+		interval:   interval,
+	}
+}
+
+// Run checks the GPUs right away and then every interval until the returned
+// channel is closed.
+func (m *DeviceHealthMonitor) Run() chan struct{} {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			m.Check()
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return stop
+}
+
+// Check runs the health check of every GPU once. A Warning event is emitted
+// when a GPU turns unhealthy, and the NVIDIACCModeDevicesAvailable condition
+// is updated whenever the health of a GPU changes.
+func (m *DeviceHealthMonitor) Check() {
+	state, err := QueryResourceState()
+	if err != nil {
+		log.Warnf("Unable to check device health: %s", err)
+		return
+	}
+	changed := false
+	defer func() {
+		if !changed || legacyModeFlag {
+			return
+		}
+		setDevicesAvailableCondition(m.conditions, m.health)
+		if err := m.conditions.Sync(context.Background()); err != nil {
+			log.Warnf("Unable to update node conditions: %s", err)
+		}
+	}()
+	for _, gpu := range state.GPUs {
+		output, err := execScriptOutput([]string{"device-health", "-d", gpu.ID})
+		if err == nil {
+			if m.health.SetHealthy(gpu.ID) {
+				log.Infof("GPU %s is healthy again, including it in CC mode changes", gpu.ID)
+				changed = true
+			}
+			continue
+		}
+		reason := strings.TrimSpace(string(output))
+		if reason == "" {
+			reason = err.Error()
+		}
+		if m.health.SetUnhealthy(gpu.ID, reason) {
+			changed = true
+			log.Warnf("GPU %s is unhealthy, excluding it from CC mode changes: %s", gpu.ID, reason)
+			m.events.Eventf(v1.EventTypeWarning, "CCModeDeviceUnhealthy", "GPU %s is unhealthy and excluded from CC mode changes: %s", gpu.ID, reason)
+		}
+	}
+}
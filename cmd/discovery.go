@@ -0,0 +1,289 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DeviceDiscoveryPlugin discovers the CC capable devices of the node, which
+// are passed to cc-manager.sh in CC_CAPABLE_DEVICE_IDS.
+type DeviceDiscoveryPlugin interface {
+	// Discover returns the PCI addresses of the CC capable devices.
+	Discover(ctx context.Context) ([]string, error)
+	// Watch sends the devices every time they change, until ctx is done.
+	Watch(ctx context.Context) (<-chan []string, error)
+}
+
+// ParseDeviceDiscoveryPlugin parses the value of --device-discovery-plugin:
+// env, file:<path> or command:<command>.
+func ParseDeviceDiscoveryPlugin(value string, interval time.Duration) (DeviceDiscoveryPlugin, error) {
+	kind, arg, _ := strings.Cut(value, ":")
+	switch kind {
+	case "env":
+		if arg != "" {
+			return nil, fmt.Errorf("the env plugin takes no argument")
+		}
+		return &EnvVarPlugin{}, nil
+	case "file":
+		if !filepath.IsAbs(arg) {
+			return nil, fmt.Errorf("the file plugin requires an absolute path, e.g. file:/etc/cc-manager/devices")
+		}
+		return &FilePlugin{path: arg}, nil
+	case "command":
+		if strings.TrimSpace(arg) == "" {
+			return nil, fmt.Errorf("the command plugin requires a command, e.g. command:/usr/bin/list-cc-devices")
+		}
+		return &CommandPlugin{command: arg, interval: interval}, nil
+	}
+	return nil, fmt.Errorf("unknown plugin '%s', must be one of env, file:<path>, command:<command>", kind)
+}
+
+// parseDeviceIDs splits a comma or whitespace separated list of device IDs.
+func parseDeviceIDs(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+}
+
+// EnvVarPlugin reads the devices from CC_CAPABLE_DEVICE_IDS, which is set when
+// the pod starts and never changes.
+type EnvVarPlugin struct{}
+
+func (p *EnvVarPlugin) Discover(ctx context.Context) ([]string, error) {
+	return parseDeviceIDs(os.Getenv("CC_CAPABLE_DEVICE_IDS")), nil
+}
+
+func (p *EnvVarPlugin) Watch(ctx context.Context) (<-chan []string, error) {
+	ch := make(chan []string)
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// FilePlugin reads the devices from a file, such as a key of a mounted
+// ConfigMap, and watches its directory for updates.
+type FilePlugin struct {
+	path string
+}
+
+func (p *FilePlugin) Discover(ctx context.Context) ([]string, error) {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading device list: %s", err)
+	}
+	return parseDeviceIDs(string(data)), nil
+}
+
+func (p *FilePlugin) Watch(ctx context.Context) (<-chan []string, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("error creating device list watcher: %s", err)
+	}
+	// the directory is watched so that the symlink rotation of a ConfigMap
+	// volume update is seen
+	if err := watcher.Add(filepath.Dir(p.path)); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("error watching device list %s: %s", p.path, err)
+	}
+	ch := make(chan []string)
+	go func() {
+		defer close(ch)
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+					continue
+				}
+				ids, err := p.Discover(ctx)
+				if err != nil {
+					log.Errorf("Error reading device list %s: %s", p.path, err)
+					continue
+				}
+				select {
+				case ch <- ids:
+				case <-ctx.Done():
+					return
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Errorf("Error watching device list %s: %s", p.path, err)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// CommandPlugin runs a command printing the devices, and runs it again every
+// interval to detect changes.
+type CommandPlugin struct {
+	command  string
+	interval time.Duration
+}
+
+func (p *CommandPlugin) Discover(ctx context.Context) ([]string, error) {
+	output, err := exec.CommandContext(ctx, "sh", "-c", p.command).Output()
+	if err != nil {
+		return nil, fmt.Errorf("error running device discovery command: %s", err)
+	}
+	return parseDeviceIDs(string(output)), nil
+}
+
+func (p *CommandPlugin) Watch(ctx context.Context) (<-chan []string, error) {
+	ch := make(chan []string)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ids, err := p.Discover(ctx)
+				if err != nil {
+					log.Errorf("Error discovering devices: %s", err)
+					continue
+				}
+				select {
+				case ch <- ids:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// discoverCCCapableDevices sets CC_CAPABLE_DEVICE_IDS to the devices found by
+// plugin. The env plugin reads the env as is, so there is nothing to do.
+func discoverCCCapableDevices(ctx context.Context, plugin DeviceDiscoveryPlugin) error {
+	if _, envPlugin := plugin.(*EnvVarPlugin); envPlugin {
+		return nil
+	}
+	ids, err := plugin.Discover(ctx)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("device discovery found no CC capable devices")
+	}
+	if err := setCCCapableDeviceIDs(ids); err != nil {
+		return err
+	}
+	log.Infof("Discovered CC capable devices: %s", os.Getenv("CC_CAPABLE_DEVICE_IDS"))
+	return nil
+}
+
+// fetchNodeAndDiscoverDevices gets the node and runs device discovery
+// concurrently, as set with --parallel-node-discovery. Both must succeed,
+// the first error cancels the other.
+func fetchNodeAndDiscoverDevices(ctx context.Context, clientset *kubernetes.Clientset, nodeName string, plugin DeviceDiscoveryPlugin) (*v1.Node, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var node *v1.Node
+	var nodeErr, discoveryErr error
+	var nodeTime, discoveryTime time.Duration
+	started := time.Now()
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		node, nodeErr = clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		nodeTime = time.Since(started)
+		if nodeErr != nil {
+			cancel()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		discoveryErr = discoverCCCapableDevices(ctx, plugin)
+		discoveryTime = time.Since(started)
+		if discoveryErr != nil {
+			cancel()
+		}
+	}()
+	wg.Wait()
+
+	if nodeErr != nil {
+		return nil, fmt.Errorf("error obtaining node labels from config: %s", nodeErr)
+	}
+	if discoveryErr != nil {
+		return nil, discoveryErr
+	}
+	log.Debugf("Fetched node in %s and discovered devices in %s concurrently, saving %s", nodeTime, discoveryTime, nodeTime+discoveryTime-time.Since(started))
+	return node, nil
+}
+
+// WatchDeviceDiscovery updates CC_CAPABLE_DEVICE_IDS whenever the devices
+// discovered by plugin change, and reapplies the current CC mode so that
+// added devices get it too. An empty device list is ignored.
+func WatchDeviceDiscovery(ctx context.Context, plugin DeviceDiscoveryPlugin, ccModeConfig *SyncableCCModeConfig) error {
+	updates, err := plugin.Watch(ctx)
+	if err != nil {
+		return err
+	}
+	go func() {
+		current := parseDeviceIDs(os.Getenv("CC_CAPABLE_DEVICE_IDS"))
+		for ids := range updates {
+			if len(ids) == 0 {
+				log.Warnf("Device discovery found no CC capable devices, keeping %s", strings.Join(current, ","))
+				continue
+			}
+			if err := setCCCapableDeviceIDs(ids); err != nil {
+				log.Errorf("Error updating CC capable devices: %s", err)
+				continue
+			}
+			updated := parseDeviceIDs(os.Getenv("CC_CAPABLE_DEVICE_IDS"))
+			if reflect.DeepEqual(updated, current) {
+				continue
+			}
+			log.Infof("CC capable devices changed from %s to %s, reapplying the CC mode", strings.Join(current, ","), strings.Join(updated, ","))
+			current = updated
+			ccModeConfig.Reapply()
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// LogNodeEvents watches the Kubernetes events involving the node and logs
+// those related to CC mode, whatever component emitted them.
+func LogNodeEvents(clientset *kubernetes.Clientset, nodeName string) chan struct{} {
+	// node events are not namespaced consistently across components, so
+	// events are watched in all namespaces
+	listWatch := cache.NewListWatchFromClient(
+		clientset.CoreV1().RESTClient(),
+		"events",
+		metav1.NamespaceAll,
+		fields.AndSelectors(
+			fields.OneTermEqualSelector("involvedObject.kind", "Node"),
+			fields.OneTermEqualSelector("involvedObject.name", nodeName),
+		),
+	)
+
+	_, controller := cache.NewInformer(
+		listWatch, &v1.Event{}, 0,
+		PanicRecoveryMiddleware("node event", cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				logNodeEvent(obj.(*v1.Event))
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				// repeated events are aggregated by bumping their count
+				if oldObj.(*v1.Event).Count != newObj.(*v1.Event).Count {
+					logNodeEvent(newObj.(*v1.Event))
+				}
+			},
+		}),
+	)
+
+	stop := make(chan struct{})
+	go controller.Run(stop)
+	return stop
+}
+
+func logNodeEvent(event *v1.Event) {
+	if !isCCModeEvent(event) {
+		return
+	}
+	log.WithFields(log.Fields{
+		"type":      event.Type,
+		"reason":    event.Reason,
+		"component": event.Source.Component,
+		"count":     event.Count,
+	}).Infof("Node event: %s", event.Message)
+}
+
+func isCCModeEvent(event *v1.Event) bool {
+	return strings.Contains(event.Reason, "CC") || strings.Contains(event.Reason, "cc")
+}
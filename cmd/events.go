@@ -0,0 +1,113 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// EventSourceComponent is the default --cc-mode-event-source-component.
+	EventSourceComponent = "k8s-cc-manager"
+
+	// eventFlushTimeout bounds how long Shutdown waits for recorded events
+	// to be written to the API server.
+	eventFlushTimeout = 5 * time.Second
+)
+
+// NodeEventRecorder records Kubernetes events about the current node.
+type NodeEventRecorder struct {
+	broadcaster record.EventBroadcaster
+	recorder    record.EventRecorder
+	sink        *countingEventSink
+	node        *v1.ObjectReference
+	recorded    atomic.Int64
+}
+
+func NewNodeEventRecorder(clientset *kubernetes.Clientset, nodeName string) *NodeEventRecorder {
+	sink := &countingEventSink{
+		EventSink: &typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")},
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(sink)
+	host := daemonIDFlag
+	if legacyModeFlag {
+		host = nodeName
+	}
+
+	return &NodeEventRecorder{
+		broadcaster: broadcaster,
+		recorder:    broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: eventSourceComponentFlag, Host: host}),
+		sink:        sink,
+		node: &v1.ObjectReference{
+			Kind: "Node",
+			Name: nodeName,
+			UID:  types.UID(nodeName),
+		},
+	}
+}
+
+// Eventf records an event of eventType about the current node. Nothing is
+// recorded with --legacy-mode, the original implementation emitted no
+// events.
+func (r *NodeEventRecorder) Eventf(eventType, reason, messageFmt string, args ...interface{}) {
+	if legacyModeFlag {
+		return
+	}
+	r.recorded.Add(1)
+	r.recorder.Eventf(r.node, eventType, reason, messageFmt, args...)
+}
+
+// Shutdown gives recorded events a chance to be written before stopping the
+// broadcaster, so that events emitted right before the daemon exits are not
+// lost.
+func (r *NodeEventRecorder) Shutdown() {
+	deadline := time.Now().Add(eventFlushTimeout)
+	for r.sink.written.Load() < r.recorded.Load() && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	r.broadcaster.Shutdown()
+}
+
+// countingEventSink counts the events handed to the API server.
+type countingEventSink struct {
+	record.EventSink
+	written atomic.Int64
+}
+
+func (s *countingEventSink) Create(event *v1.Event) (*v1.Event, error) {
+	defer s.written.Add(1)
+	return s.EventSink.Create(event)
+}
+
+func (s *countingEventSink) Update(event *v1.Event) (*v1.Event, error) {
+	defer s.written.Add(1)
+	return s.EventSink.Update(event)
+}
+
+func (s *countingEventSink) Patch(event *v1.Event, data []byte) (*v1.Event, error) {
+	defer s.written.Add(1)
+	return s.EventSink.Patch(event, data)
+}
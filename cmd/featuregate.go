@@ -0,0 +1,176 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	cli "github.com/urfave/cli/v2"
+)
+
+type FeatureStage string
+
+const (
+	Alpha FeatureStage = "Alpha"
+	Beta  FeatureStage = "Beta"
+	GA    FeatureStage = "GA"
+)
+
+// Feature gate names. Every experimental feature must check its gate with
+// FeatureGate.Enabled before activating, or be listed in gatedFlags if a flag
+// turns it on.
+const (
+	// FeatureCCModePolicy enables the JSON nvidia.com/cc.mode.policy annotation.
+	// Alpha, disabled by default.
+	FeatureCCModePolicy = "CCModePolicy"
+	// FeatureScheduledModeChange allows --change-window.
+	// Alpha, disabled by default.
+	FeatureScheduledModeChange = "ScheduledModeChange"
+	// FeatureCoordinatedModeChange allows --coordination-group-label.
+	// Alpha, disabled by default.
+	FeatureCoordinatedModeChange = "CoordinatedModeChange"
+	// FeatureCCModeInventory allows --cc-mode-inventory.
+	// Alpha, disabled by default.
+	FeatureCCModeInventory = "CCModeInventory"
+	// FeatureModeChangeTracing allows --enable-mode-change-tracing.
+	// Alpha, disabled by default.
+	FeatureModeChangeTracing = "ModeChangeTracing"
+	// FeatureForcedPeriodicApply allows --forced-apply-interval.
+	// Alpha, disabled by default.
+	FeatureForcedPeriodicApply = "ForcedPeriodicApply"
+	// FeatureNodeLeaseHeartbeat allows --enable-node-lease-heartbeat.
+	// Alpha, disabled by default.
+	FeatureNodeLeaseHeartbeat = "NodeLeaseHeartbeat"
+	// FeatureBatchedNodeUpdates allows --node-update-batch-size.
+	// Alpha, disabled by default.
+	FeatureBatchedNodeUpdates = "BatchedNodeUpdates"
+	// FeatureAdmissionController allows --enable-admission-controller.
+	// Alpha, disabled by default.
+	FeatureAdmissionController = "AdmissionController"
+)
+
+type featureSpec struct {
+	Default bool
+	Stage   FeatureStage
+}
+
+var knownFeatures = map[string]featureSpec{
+	FeatureCCModePolicy:          {Default: false, Stage: Alpha},
+	FeatureScheduledModeChange:   {Default: false, Stage: Alpha},
+	FeatureCoordinatedModeChange: {Default: false, Stage: Alpha},
+	FeatureCCModeInventory:       {Default: false, Stage: Alpha},
+	FeatureModeChangeTracing:     {Default: false, Stage: Alpha},
+	FeatureForcedPeriodicApply:   {Default: false, Stage: Alpha},
+	FeatureNodeLeaseHeartbeat:    {Default: false, Stage: Alpha},
+	FeatureBatchedNodeUpdates:    {Default: false, Stage: Alpha},
+	FeatureAdmissionController:   {Default: false, Stage: Alpha},
+}
+
+// gatedFlags maps the flags turning on an experimental feature to its gate.
+var gatedFlags = map[string]string{
+	"change-window":               FeatureScheduledModeChange,
+	"coordination-group-label":    FeatureCoordinatedModeChange,
+	"cc-mode-inventory":           FeatureCCModeInventory,
+	"enable-mode-change-tracing":  FeatureModeChangeTracing,
+	"forced-apply-interval":       FeatureForcedPeriodicApply,
+	"enable-node-lease-heartbeat": FeatureNodeLeaseHeartbeat,
+	"node-update-batch-size":      FeatureBatchedNodeUpdates,
+	"enable-admission-controller": FeatureAdmissionController,
+}
+
+// FeatureGate records which features are enabled.
+type FeatureGate struct {
+	enabled map[string]bool
+}
+
+// NewFeatureGate returns a FeatureGate with every feature at its default.
+func NewFeatureGate() *FeatureGate {
+	g := &FeatureGate{enabled: make(map[string]bool)}
+	for name, spec := range knownFeatures {
+		g.enabled[name] = spec.Default
+	}
+	return g
+}
+
+// ParseFeatureGates parses a comma-separated list of Feature=true/false pairs
+// on top of the feature defaults.
+func ParseFeatureGates(value string) (*FeatureGate, error) {
+	g := NewFeatureGate()
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, setting, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("missing value for feature gate '%s', expected %s=true|false", pair, pair)
+		}
+		name = strings.TrimSpace(name)
+		if _, ok := knownFeatures[name]; !ok {
+			return nil, fmt.Errorf("unknown feature gate '%s', known gates are %s", name, strings.Join(knownFeatureNames(), ", "))
+		}
+		enabled, err := strconv.ParseBool(strings.TrimSpace(setting))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for feature gate '%s': %s", name, err)
+		}
+		g.enabled[name] = enabled
+	}
+	return g, nil
+}
+
+// Enabled reports whether the named feature is enabled.
+func (g *FeatureGate) Enabled(name string) bool {
+	return g.enabled[name]
+}
+
+// CheckFlags returns an error if a flag of gatedFlags is set while the gate
+// of its feature is disabled.
+func (g *FeatureGate) CheckFlags(c *cli.Context) error {
+	var names []string
+	for name := range gatedFlags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		feature := gatedFlags[name]
+		if c.IsSet(name) && !g.Enabled(feature) {
+			return fmt.Errorf("--%s requires --feature-gates=%s=true", name, feature)
+		}
+	}
+	return nil
+}
+
+// String returns the gate settings in the format accepted by --feature-gates.
+func (g *FeatureGate) String() string {
+	var pairs []string
+	for _, name := range knownFeatureNames() {
+		pairs = append(pairs, fmt.Sprintf("%s=%t", name, g.enabled[name]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func knownFeatureNames() []string {
+	var names []string
+	for name := range knownFeatures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
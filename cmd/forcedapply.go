@@ -0,0 +1,45 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RunForcedApply makes the main loop apply the requested CC mode again every
+// interval, even if neither the label nor the node changed, until the
+// returned channel is closed. The change goes through the usual checks, so
+// it is never run concurrently with another CC mode change.
+func RunForcedApply(interval time.Duration, ccModeConfig *SyncableCCModeConfig) chan struct{} {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				log.Infof("Forcing CC mode to be applied again (see --forced-apply-interval)")
+				ccModeConfig.Reapply()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return stop
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// GracefulTimeoutPolicy selects what happens when a GPU is still busy after
+// --graceful-timeout.
+type GracefulTimeoutPolicy string
+
+const (
+	GracefulTimeoutProceed GracefulTimeoutPolicy = "proceed"
+	GracefulTimeoutAbort   GracefulTimeoutPolicy = "abort"
+)
+
+// waitIdleTimeoutExitCode is the exit status of cc-manager.sh wait-idle for
+// a GPU that is still busy after the timeout.
+const waitIdleTimeoutExitCode = 2
+
+// ParseGracefulTimeoutPolicy parses the value of --graceful-timeout-policy.
+func ParseGracefulTimeoutPolicy(s string) (GracefulTimeoutPolicy, error) {
+	switch policy := GracefulTimeoutPolicy(strings.ToLower(strings.TrimSpace(s))); policy {
+	case GracefulTimeoutProceed, GracefulTimeoutAbort:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown graceful timeout policy '%s', must be one of %s, %s", s, GracefulTimeoutProceed, GracefulTimeoutAbort)
+}
+
+// waitForIdleDevices waits for every CC capable GPU to stop running compute
+// processes, for at most timeout per GPU, before the CC mode is changed to
+// mode. GPUs still busy after the timeout fail the change with the abort
+// policy and are logged with the proceed policy.
+func waitForIdleDevices(mode string, timeout time.Duration, policy GracefulTimeoutPolicy) error {
+	state, err := QueryResourceState()
+	if err != nil {
+		return err
+	}
+	seconds := int(timeout.Round(time.Second) / time.Second)
+	var busy []string
+	for _, gpu := range state.GPUs {
+		log.Infof("Waiting up to %s for GPU %s to become idle before changing to CC mode %s", timeout, gpu.ID, mode)
+		_, err := execScriptOutput([]string{"wait-idle", "-d", gpu.ID, "-t", fmt.Sprint(seconds)})
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == waitIdleTimeoutExitCode {
+			busy = append(busy, gpu.ID)
+			continue
+		}
+		if err != nil {
+			return NewDeviceError(gpu.ID, fmt.Errorf("error waiting for GPU to become idle: %w", err))
+		}
+	}
+	if len(busy) == 0 {
+		return nil
+	}
+	if policy == GracefulTimeoutAbort {
+		return fmt.Errorf("GPUs still busy after %s, not changing to CC mode %s: %s", timeout, mode, strings.Join(busy, ", "))
+	}
+	log.Warnf("GPUs still busy after %s, changing to CC mode %s anyway: %s", timeout, mode, strings.Join(busy, ", "))
+	return nil
+}
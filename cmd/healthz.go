@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// healthzTimeout bounds all checks of a single /healthz request.
+const healthzTimeout = 5 * time.Second
+
+// HealthzChecker is a single check of the /healthz endpoint.
+type HealthzChecker interface {
+	// Check returns an error if the daemon is not healthy.
+	Check(ctx context.Context) error
+}
+
+// InformerSyncedChecker fails until the node informer completed its initial
+// list. The informer is set once it is started.
+type InformerSyncedChecker struct {
+	mutex  sync.Mutex
+	synced cache.InformerSynced
+}
+
+// SetInformer sets the function reporting whether the informer synced. It is
+// a no-op on a nil checker.
+func (c *InformerSyncedChecker) SetInformer(synced cache.InformerSynced) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.synced = synced
+}
+
+func (c *InformerSyncedChecker) Check(ctx context.Context) error {
+	c.mutex.Lock()
+	synced := c.synced
+	c.mutex.Unlock()
+	if synced == nil {
+		return fmt.Errorf("node informer not started")
+	}
+	if !synced() {
+		return fmt.Errorf("node informer not synced")
+	}
+	return nil
+}
+
+// LastScriptSuccessChecker fails while the last CC mode change failed.
+type LastScriptSuccessChecker struct {
+	mutex sync.Mutex
+	err   error
+}
+
+// Record records the outcome of a CC mode change. It is a no-op on a nil
+// checker.
+func (c *LastScriptSuccessChecker) Record(err error) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.err = err
+}
+
+func (c *LastScriptSuccessChecker) Check(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err != nil {
+		return fmt.Errorf("last cc mode change failed: %s", c.err)
+	}
+	return nil
+}
+
+// KubernetesConnectivityChecker fails if the /healthz endpoint of the API
+// server cannot be reached.
+type KubernetesConnectivityChecker struct {
+	clientset *kubernetes.Clientset
+}
+
+func NewKubernetesConnectivityChecker(clientset *kubernetes.Clientset) *KubernetesConnectivityChecker {
+	return &KubernetesConnectivityChecker{clientset: clientset}
+}
+
+func (c *KubernetesConnectivityChecker) Check(ctx context.Context) error {
+	if _, err := c.clientset.Discovery().RESTClient().Get().AbsPath("/healthz").DoRaw(ctx); err != nil {
+		return fmt.Errorf("error reaching the Kubernetes API server: %s", err)
+	}
+	return nil
+}
+
+// DeviceAccessibilityChecker fails if a CC capable device given by PCI
+// address is missing from sysfs or, with --device-health-check-interval set,
+// was found unhealthy.
+type DeviceAccessibilityChecker struct{}
+
+func (c *DeviceAccessibilityChecker) Check(ctx context.Context) error {
+	var missing []string
+	for _, id := range parseDeviceIDs(os.Getenv("CC_CAPABLE_DEVICE_IDS")) {
+		address := FormatDeviceID(id, DeviceIDFormatPCI)
+		if address == id && !strings.Contains(id, ":") {
+			// not a PCI address, such as a GPU UUID
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(sysfsPCIDevicesDir, address)); err != nil {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) != 0 {
+		return fmt.Errorf("devices not found: %s", strings.Join(missing, ", "))
+	}
+	if unhealthy := deviceHealth.Unhealthy(); len(unhealthy) != 0 {
+		return fmt.Errorf("unhealthy devices: %s", strings.Join(unhealthy, ", "))
+	}
+	return nil
+}
+
+// AggregateHealthzChecker runs named checks, all of which must pass. It serves
+// /healthz, answering 200 if all checks passed and 503 listing the failed
+// checks otherwise.
+type AggregateHealthzChecker struct {
+	checks map[string]HealthzChecker
+}
+
+func NewAggregateHealthzChecker() *AggregateHealthzChecker {
+	return &AggregateHealthzChecker{checks: make(map[string]HealthzChecker)}
+}
+
+// Add adds a check reported as name.
+func (a *AggregateHealthzChecker) Add(name string, check HealthzChecker) {
+	a.checks[name] = check
+}
+
+// Check runs all checks and returns an error listing the failed ones.
+func (a *AggregateHealthzChecker) Check(ctx context.Context) error {
+	if failed := a.failed(ctx); len(failed) != 0 {
+		return fmt.Errorf("%s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func (a *AggregateHealthzChecker) failed(ctx context.Context) []string {
+	names := make([]string, 0, len(a.checks))
+	for name := range a.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var failed []string
+	for _, name := range names {
+		if err := a.checks[name].Check(ctx); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+	return failed
+}
+
+func (a *AggregateHealthzChecker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthzTimeout)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	failed := a.failed(ctx)
+	if len(failed) == 0 {
+		fmt.Fprintln(w, "ok")
+		return
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	for _, f := range failed {
+		fmt.Fprintln(w, f)
+	}
+}
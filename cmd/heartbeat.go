@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// NodeLeaseHeartbeat renews a Lease named cc-manager-<node> as a liveness
+// signal of the daemon, separate from the node Lease of the kubelet. The
+// daemon exits once renewing it failed --lease-failure-threshold times in a
+// row.
+type NodeLeaseHeartbeat struct {
+	clientset *kubernetes.Clientset
+	namespace string
+	name      string
+	holder    string
+	interval  time.Duration
+	threshold int
+}
+
+func NewNodeLeaseHeartbeat(clientset *kubernetes.Clientset, namespace, nodeName, holder string, interval time.Duration, threshold int) *NodeLeaseHeartbeat {
+	return &NodeLeaseHeartbeat{
+		clientset: clientset,
+		namespace: namespace,
+		name:      "cc-manager-" + nodeName,
+		holder:    holder,
+		interval:  interval,
+		threshold: threshold,
+	}
+}
+
+// Run renews the Lease right away and then every interval until the returned
+// channel is closed.
+func (h *NodeLeaseHeartbeat) Run() chan struct{} {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+		failures := 0
+		for {
+			if err := h.renew(context.Background()); err != nil {
+				failures++
+				log.Warnf("Unable to renew heartbeat Lease %s/%s (%d/%d): %s", h.namespace, h.name, failures, h.threshold, err)
+				if failures >= h.threshold {
+					log.Fatalf("Failed to renew heartbeat Lease %s/%s %d times in a row", h.namespace, h.name, failures)
+				}
+			} else {
+				failures = 0
+			}
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return stop
+}
+
+func (h *NodeLeaseHeartbeat) renew(ctx context.Context) error {
+	now := metav1.NewMicroTime(time.Now())
+	// the Lease expires once three renewals were missed
+	seconds := int32(3 * h.interval / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	leases := h.clientset.CoordinationV1().Leases(h.namespace)
+
+	lease, err := leases.Get(ctx, h.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err := leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: h.name, Namespace: h.namespace},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &h.holder,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("error creating Lease: %s", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error getting Lease: %s", err)
+	}
+
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != h.holder {
+		lease.Spec.HolderIdentity = &h.holder
+		lease.Spec.AcquireTime = &now
+	}
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+	if _, err := leases.Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("error updating Lease: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LabelHistoryEntry is a CC mode configuration value seen by the node watch.
+type LabelHistoryEntry struct {
+	Time            time.Time `json:"time"`
+	Value           string    `json:"value"`
+	ResourceVersion string    `json:"resourceVersion"`
+}
+
+// NodeLabelHistory keeps the last values of the CC mode configuration in a
+// ring buffer to help debugging labels set by competing controllers.
+type NodeLabelHistory struct {
+	mutex     sync.Mutex
+	entries   []LabelHistoryEntry
+	next      int
+	full      bool
+	threshold int
+	window    time.Duration
+	flapping  bool
+}
+
+func NewNodeLabelHistory(size, threshold int, window time.Duration) *NodeLabelHistory {
+	return &NodeLabelHistory{
+		entries:   make([]LabelHistoryEntry, size),
+		threshold: threshold,
+		window:    window,
+	}
+}
+
+// Record adds a value to the history and logs a warning when the label starts
+// flapping. It is a no-op on a nil history.
+func (h *NodeLabelHistory) Record(value, resourceVersion string) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.entries[h.next] = LabelHistoryEntry{
+		Time:            time.Now(),
+		Value:           value,
+		ResourceVersion: resourceVersion,
+	}
+	h.next = (h.next + 1) % len(h.entries)
+	if h.next == 0 {
+		h.full = true
+	}
+
+	flapping := h.flappingLocked()
+	if flapping && !h.flapping {
+		log.Warnf("CC mode label changed more than %d times within %s, it may be set by multiple controllers (see /debug/label-history)", h.threshold, h.window)
+	}
+	h.flapping = flapping
+}
+
+// Entries returns the history, oldest first.
+func (h *NodeLabelHistory) Entries() []LabelHistoryEntry {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.entriesLocked()
+}
+
+func (h *NodeLabelHistory) entriesLocked() []LabelHistoryEntry {
+	if !h.full {
+		return append([]LabelHistoryEntry{}, h.entries[:h.next]...)
+	}
+	return append(append([]LabelHistoryEntry{}, h.entries[h.next:]...), h.entries[:h.next]...)
+}
+
+// FlappingDetected reports whether more than --flapping-threshold changes
+// were recorded within the last --flapping-window.
+func (h *NodeLabelHistory) FlappingDetected() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.flappingLocked()
+}
+
+func (h *NodeLabelHistory) flappingLocked() bool {
+	since := time.Now().Add(-h.window)
+	changes := 0
+	for _, entry := range h.entriesLocked() {
+		if entry.Time.After(since) {
+			changes++
+		}
+	}
+	return changes > h.threshold
+}
+
+// ServeHTTP implements GET /debug/label-history.
+func (h *NodeLabelHistory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response := struct {
+		Flapping bool                `json:"flapping"`
+		Entries  []LabelHistoryEntry `json:"entries"`
+	}{
+		Flapping: h.FlappingDetected(),
+		Entries:  h.Entries(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Warnf("Unable to write label history response: %s", err)
+	}
+}
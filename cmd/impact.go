@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	ImpactWeightAnnotation = "nvidia.com/cc-mode-change-impact-weight"
+	DefaultImpactWeight    = 1
+)
+
+// ImpactScoreCalculator delays CC mode changes while the pods running on the
+// node are weighted above --max-impact-score. Each pod weighs the value of
+// its nvidia.com/cc-mode-change-impact-weight annotation, or 1 without it.
+type ImpactScoreCalculator struct {
+	clientset  *kubernetes.Clientset
+	nodeName   string
+	maxScore   int
+	retryDelay time.Duration
+	events     *NodeEventRecorder
+}
+
+func NewImpactScoreCalculator(clientset *kubernetes.Clientset, nodeName string, maxScore int, retryDelay time.Duration, events *NodeEventRecorder) *ImpactScoreCalculator {
+	return &ImpactScoreCalculator{
+		clientset:  clientset,
+		nodeName:   nodeName,
+		maxScore:   maxScore,
+		retryDelay: retryDelay,
+		events:     events,
+	}
+}
+
+// Score returns the sum of the impact weights of the running pods on the
+// node, not counting the daemon itself.
+func (c *ImpactScoreCalculator) Score(ctx context.Context) (int, error) {
+	pods, err := c.clientset.CoreV1().Pods(v1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", c.nodeName).String(),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error listing pods on node %s: %s", c.nodeName, err)
+	}
+
+	self := currentPodName()
+	score := 0
+	for _, pod := range pods.Items {
+		if pod.Name == self || pod.Status.Phase != v1.PodRunning {
+			continue
+		}
+		score += podImpactWeight(&pod)
+	}
+	return score, nil
+}
+
+func podImpactWeight(pod *v1.Pod) int {
+	value, ok := pod.Annotations[ImpactWeightAnnotation]
+	if !ok {
+		return DefaultImpactWeight
+	}
+	weight, err := strconv.Atoi(value)
+	if err != nil || weight < 0 {
+		log.Warnf("Invalid %s annotation '%s' on pod %s/%s, using %d", ImpactWeightAnnotation, value, pod.Namespace, pod.Name, DefaultImpactWeight)
+		return DefaultImpactWeight
+	}
+	return weight
+}
+
+// Wait returns once the impact score of the node is at most the maximum,
+// checking again every retry delay. It is a no-op on a nil calculator.
+func (c *ImpactScoreCalculator) Wait(mode string) error {
+	if c == nil {
+		return nil
+	}
+	for {
+		score, err := c.Score(context.Background())
+		if err != nil {
+			return err
+		}
+		if score <= c.maxScore {
+			return nil
+		}
+		log.Warnf("Impact score %d of running pods exceeds %d, deferring change to CC mode %s by %s", score, c.maxScore, mode, c.retryDelay)
+		c.events.Eventf(v1.EventTypeWarning, "CCModeChangeDeferredImpact", "Deferring change to CC mode %s, impact score %d of running pods exceeds %d", mode, score, c.maxScore)
+		time.Sleep(c.retryDelay)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	cli "github.com/urfave/cli/v2"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	CCModeInventoryName = "cluster-inventory"
+
+	ccModeInventoryAPIVersion = "nvidia.com/v1alpha1"
+	ccModeInventoryKind       = "CCModeInventory"
+	ccModeInventoryPath       = "/apis/nvidia.com/v1alpha1/ccmodeinventories"
+)
+
+// CCModeInventory is the cluster-scoped CCModeInventory resource, see
+// deployments/crds/nvidia.com_ccmodeinventories.yaml. Every daemon owns the
+// entry of its node in spec.nodes.
+type CCModeInventory struct {
+	APIVersion string              `json:"apiVersion"`
+	Kind       string              `json:"kind"`
+	Metadata   metav1.ObjectMeta   `json:"metadata"`
+	Spec       CCModeInventorySpec `json:"spec"`
+}
+
+type CCModeInventorySpec struct {
+	Nodes map[string]CCModeInventoryEntry `json:"nodes,omitempty"`
+}
+
+// CCModeInventoryEntry is the last known CC mode state of a node.
+type CCModeInventoryEntry struct {
+	DeviceIDs     []string `json:"deviceIDs"`
+	CurrentMode   string   `json:"currentMode"`
+	LastChanged   string   `json:"lastChanged"`
+	DaemonPodName string   `json:"daemonPodName"`
+}
+
+// CCModeInventoryWriter records the CC mode of the node in the
+// cluster-inventory CCModeInventory with a server-side apply per node, so
+// that the daemons of different nodes never conflict.
+type CCModeInventoryWriter struct {
+	clientset *kubernetes.Clientset
+	nodeName  string
+}
+
+func NewCCModeInventoryWriter(clientset *kubernetes.Clientset, nodeName string) *CCModeInventoryWriter {
+	return &CCModeInventoryWriter{
+		clientset: clientset,
+		nodeName:  nodeName,
+	}
+}
+
+// Record writes the entry of the node with mode as its current CC mode. It
+// is a no-op on a nil writer.
+func (w *CCModeInventoryWriter) Record(ctx context.Context, mode string) error {
+	if w == nil {
+		return nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"apiVersion": ccModeInventoryAPIVersion,
+		"kind":       ccModeInventoryKind,
+		"metadata": map[string]interface{}{
+			"name": CCModeInventoryName,
+		},
+		"spec": map[string]interface{}{
+			"nodes": map[string]CCModeInventoryEntry{
+				w.nodeName: {
+					DeviceIDs:     parseDeviceIDs(os.Getenv("CC_CAPABLE_DEVICE_IDS")),
+					CurrentMode:   mode,
+					LastChanged:   time.Now().UTC().Format(time.RFC3339),
+					DaemonPodName: currentPodName(),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding inventory patch: %s", err)
+	}
+	force := true
+	err = w.clientset.CoreV1().RESTClient().Patch(types.ApplyPatchType).
+		AbsPath(ccModeInventoryPath, CCModeInventoryName).
+		VersionedParams(&metav1.PatchOptions{
+			FieldManager: fmt.Sprintf("%s-%s", nodePatchFieldManager, w.nodeName),
+			Force:        &force,
+		}, metav1.ParameterCodec).
+		Body(patch).
+		Do(ctx).
+		Error()
+	if err != nil {
+		return fmt.Errorf("error updating CCModeInventory %s: %s", CCModeInventoryName, err)
+	}
+	return nil
+}
+
+// listInventoryCommand prints the entries of the cluster-inventory
+// CCModeInventory as a table, one node per line.
+func listInventoryCommand(c *cli.Context) error {
+	config, err := buildKubernetesConfig()
+	if err != nil {
+		return fmt.Errorf("error building kubernetes clientcmd config: %s", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("error building kubernetes clientset from config: %s", err)
+	}
+
+	data, err := clientset.CoreV1().RESTClient().Get().
+		AbsPath(ccModeInventoryPath, CCModeInventoryName).
+		Do(context.Background()).
+		Raw()
+	if err != nil {
+		return fmt.Errorf("error getting CCModeInventory %s: %s", CCModeInventoryName, err)
+	}
+	var inventory CCModeInventory
+	if err := json.Unmarshal(data, &inventory); err != nil {
+		return fmt.Errorf("error decoding CCModeInventory %s: %s", CCModeInventoryName, err)
+	}
+
+	nodes := make([]string, 0, len(inventory.Spec.Nodes))
+	for node := range inventory.Spec.Nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "NODE\tMODE\tLAST CHANGED\tDEVICES\tPOD")
+	for _, node := range nodes {
+		entry := inventory.Spec.Nodes[node]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", node, entry.CurrentMode, entry.LastChanged, strings.Join(entry.DeviceIDs, ","), entry.DaemonPodName)
+	}
+	return w.Flush()
+}
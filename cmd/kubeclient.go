@@ -0,0 +1,248 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	BurstHandlingQueue = "queue"
+	BurstHandlingDrop  = "drop"
+)
+
+const (
+	WarningHandlerLogrus = "logrus"
+	WarningHandlerKlog   = "klog"
+	WarningHandlerNone   = "none"
+)
+
+// deprecationWarningPattern matches the deprecation warnings of the API
+// server, such as "batch/v1beta1 CronJob is deprecated in v1.21+,
+// unavailable in v1.25+; use batch/v1 CronJob".
+var deprecationWarningPattern = regexp.MustCompile(`^(\S+) (\S+) is deprecated[^;]*(?:; use (.+))?$`)
+
+var uuidV4Pattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-4[0-9a-fA-F]{3}-[89abAB][0-9a-fA-F]{3}-[0-9a-fA-F]{12}$`)
+
+func buildKubernetesConfig() (*rest.Config, error) {
+	switch kubernetesWarningHandlerFlag {
+	case WarningHandlerKlog:
+		// the client-go default
+	case WarningHandlerNone:
+		rest.SetDefaultWarningHandler(rest.NoWarnings{})
+	default:
+		rest.SetDefaultWarningHandler(logrusWarningHandler{})
+	}
+
+	config, err := loadKubernetesConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if kubernetesImpersonateUserFlag != "" {
+		config.Impersonate.UserName = kubernetesImpersonateUserFlag
+		config.Impersonate.UID = kubernetesImpersonateUIDFlag
+	}
+
+	if kubernetesProxyURL != nil {
+		config.Proxy = http.ProxyURL(kubernetesProxyURL)
+		warnIfNoProxyMatches(config.Host)
+	}
+
+	if len(kubernetesRetryStatusCodes) != 0 {
+		config.Wrap(newRetryRoundTripper(kubernetesRetryStatusCodes))
+	}
+
+	if kubernetesBurstHandlingFlag == BurstHandlingDrop {
+		qps, burst := config.QPS, config.Burst
+		if qps == 0 {
+			qps = rest.DefaultQPS
+		}
+		if burst == 0 {
+			burst = rest.DefaultBurst
+		}
+		config.RateLimiter = newDropRateLimiter(qps, burst)
+	}
+
+	return config, nil
+}
+
+// ValidateWarningHandler returns an error if handler is not a supported
+// --kubernetes-warning-handler value.
+func ValidateWarningHandler(handler string) error {
+	switch handler {
+	case WarningHandlerLogrus, WarningHandlerKlog, WarningHandlerNone:
+		return nil
+	}
+	return fmt.Errorf("unsupported warning handler '%s', must be one of %s, %s, %s", handler, WarningHandlerLogrus, WarningHandlerKlog, WarningHandlerNone)
+}
+
+// logrusWarningHandler logs the warnings returned by the API server, such
+// as API deprecations, at warning level. Deprecation warnings are logged
+// with the api_version, deprecated_field and replacement fields.
+type logrusWarningHandler struct{}
+
+func (logrusWarningHandler) HandleWarningHeader(code int, agent string, text string) {
+	// like rest.WarningLogger, only code 299 is a warning meant for clients
+	if code != 299 || text == "" {
+		return
+	}
+	fields := log.Fields{}
+	if match := deprecationWarningPattern.FindStringSubmatch(text); match != nil {
+		fields["api_version"] = match[1]
+		fields["deprecated_field"] = match[2]
+		if match[3] != "" {
+			fields["replacement"] = match[3]
+		}
+	}
+	log.WithFields(fields).Warnf("Kubernetes API warning: %s", text)
+}
+
+// ValidateImpersonateUID returns an error if uid is not a UUIDv4, as
+// expected for --kubernetes-impersonate-uid.
+func ValidateImpersonateUID(uid string) error {
+	if !uuidV4Pattern.MatchString(uid) {
+		return fmt.Errorf("'%s' is not a UUIDv4", uid)
+	}
+	return nil
+}
+
+// ValidateBurstHandling returns an error if strategy is not a supported
+// --kubernetes-burst-handling value.
+func ValidateBurstHandling(strategy string) error {
+	switch strategy {
+	case BurstHandlingQueue, BurstHandlingDrop:
+		return nil
+	}
+	return fmt.Errorf("unsupported burst handling '%s', must be one of %s, %s", strategy, BurstHandlingQueue, BurstHandlingDrop)
+}
+
+// dropRateLimiter is a token bucket rate limiter for the Kubernetes client
+// that fails requests exceeding the rate instead of queuing them.
+type dropRateLimiter struct {
+	limiter *rate.Limiter
+	qps     float32
+}
+
+func newDropRateLimiter(qps float32, burst int) *dropRateLimiter {
+	return &dropRateLimiter{
+		limiter: rate.NewLimiter(rate.Limit(qps), burst),
+		qps:     qps,
+	}
+}
+
+func (l *dropRateLimiter) TryAccept() bool {
+	return l.limiter.Allow()
+}
+
+// Accept never blocks, a request over the rate is let through.
+func (l *dropRateLimiter) Accept() {
+	l.limiter.Allow()
+}
+
+// Wait returns an error right away if no token is available.
+func (l *dropRateLimiter) Wait(ctx context.Context) error {
+	if !l.limiter.Allow() {
+		return fmt.Errorf("client rate limit of %g requests per second exceeded, dropping request (see --kubernetes-burst-handling)", l.qps)
+	}
+	return nil
+}
+
+func (l *dropRateLimiter) Stop() {}
+
+func (l *dropRateLimiter) QPS() float32 {
+	return l.qps
+}
+
+func loadKubernetesConfig() (*rest.Config, error) {
+	if kubeconfigFlag != "" && kubeconfigInClusterFallbackFlag {
+		if _, err := os.Stat(kubeconfigFlag); errors.Is(err, os.ErrNotExist) {
+			log.Warnf("Kubeconfig file '%s' does not exist, falling back to in-cluster config", kubeconfigFlag)
+			return rest.InClusterConfig()
+		}
+	}
+	return clientcmd.BuildConfigFromFlags("", kubeconfigFlag)
+}
+
+// ParseKubernetesProxyURL validates the proxy URL and adds the proxy
+// credentials to it, if any.
+func ParseKubernetesProxyURL(rawURL, username, password string) (*url.URL, error) {
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme '%s', must be one of http, https, socks5", proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("missing host in proxy URL '%s'", rawURL)
+	}
+
+	if username != "" {
+		proxyURL.User = url.UserPassword(username, password)
+	} else if password != "" {
+		return nil, fmt.Errorf("proxy password set without a proxy username")
+	}
+	return proxyURL, nil
+}
+
+// warnIfNoProxyMatches warns when NO_PROXY lists the API server, since the
+// proxy set with --kubernetes-proxy-url is used regardless of NO_PROXY.
+func warnIfNoProxyMatches(apiServer string) {
+	noProxy := os.Getenv("NO_PROXY")
+	if noProxy == "" {
+		noProxy = os.Getenv("no_proxy")
+	}
+	if noProxy == "" {
+		return
+	}
+
+	host := apiServer
+	if u, err := url.Parse(apiServer); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.TrimPrefix(strings.TrimSpace(entry), ".")
+		if entry == "" {
+			continue
+		}
+		if entry == "*" || host == entry || strings.HasSuffix(host, "."+entry) {
+			log.Warnf("NO_PROXY contains the API server address %s, but --kubernetes-proxy-url is used for all Kubernetes API calls", host)
+			return
+		}
+	}
+}
@@ -0,0 +1,56 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// prefixedLabelKeyRegexp matches the conventional <dns-subdomain>/<name>
+// format of label keys owned by a vendor, such as nvidia.com/cc.mode.
+var prefixedLabelKeyRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)+/[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+
+// CheckLabelKeyFormat returns an error if key does not have the
+// <dns-subdomain>/<name> format.
+func CheckLabelKeyFormat(key string) error {
+	if !prefixedLabelKeyRegexp.MatchString(key) {
+		return fmt.Errorf("label key '%s' does not have the format <dns-subdomain>/<name>", key)
+	}
+	return nil
+}
+
+// normalizeLabelKey drops the separators users commonly mix up, so that
+// nvidia.com/cc-mode and nvidia.com/cc.mode compare equal.
+func normalizeLabelKey(key string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", "_", "", ".", "").Replace(key))
+}
+
+// warnMisspelledCCModeLabel logs a warning for every node label that only
+// differs from the CC mode label in its separators or case, since such a
+// label is never matched.
+func warnMisspelledCCModeLabel(labels map[string]string) {
+	expected := normalizeLabelKey(CCModeConfigLabel)
+	for key := range labels {
+		if key != CCModeConfigLabel && normalizeLabelKey(key) == expected {
+			log.Warnf("Node label '%s' is ignored, the cc mode is read from the '%s' label", key, CCModeConfigLabel)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// AnnotationLabelMapping copies the value of a node annotation to a label.
+type AnnotationLabelMapping struct {
+	Annotation string
+	Label      string
+}
+
+// ParseAnnotationLabelMappings parses a comma-separated list of
+// annotation-key=label-key pairs.
+func ParseAnnotationLabelMappings(value string) ([]AnnotationLabelMapping, error) {
+	var mappings []AnnotationLabelMapping
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		annotation, label, found := strings.Cut(pair, "=")
+		if !found || annotation == "" || label == "" {
+			return nil, fmt.Errorf("invalid mapping '%s', expected annotation-key=label-key", pair)
+		}
+		if errs := validation.IsQualifiedName(annotation); len(errs) != 0 {
+			return nil, fmt.Errorf("invalid annotation key '%s': %s", annotation, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsQualifiedName(label); len(errs) != 0 {
+			return nil, fmt.Errorf("invalid label key '%s': %s", label, strings.Join(errs, "; "))
+		}
+		mappings = append(mappings, AnnotationLabelMapping{Annotation: annotation, Label: label})
+	}
+	return mappings, nil
+}
+
+// copyAnnotationsToLabels copies the mapped annotations of the node to labels
+// in a single patch. Annotations that are not set on the node are skipped,
+// and values longer than a label allows are truncated.
+func copyAnnotationsToLabels(ctx context.Context, patcher *NodeLabelPatcher, annotations *NodeAnnotationCache, mappings []AnnotationLabelMapping) error {
+	if len(mappings) == 0 {
+		return nil
+	}
+
+	current, err := annotations.Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	labels := annotationLabels(current, mappings)
+	if len(labels) == 0 {
+		return nil
+	}
+
+	_, err = patcher.PatchLabels(ctx, labels)
+	return err
+}
+
+// annotationLabels returns the labels mapped from the annotations by
+// mappings. Values too long for a label are truncated, values that are still
+// not valid label values are skipped.
+func annotationLabels(current map[string]string, mappings []AnnotationLabelMapping) map[string]string {
+	labels := make(map[string]string)
+	for _, m := range mappings {
+		value, ok := current[m.Annotation]
+		if !ok {
+			continue
+		}
+		if len(value) > validation.LabelValueMaxLength {
+			log.Warnf("Value of annotation '%s' is longer than %d characters, truncating it for label '%s'", m.Annotation, validation.LabelValueMaxLength, m.Label)
+			value = strings.TrimRight(value[:validation.LabelValueMaxLength], "-_.")
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) != 0 {
+			log.Warnf("Value of annotation '%s' is not a valid label value, not copying it to label '%s': %s", m.Annotation, m.Label, strings.Join(errs, "; "))
+			continue
+		}
+		labels[m.Label] = value
+	}
+	return labels
+}
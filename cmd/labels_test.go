@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseAnnotationLabelMappings(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		expect  []AnnotationLabelMapping
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{
+			"single mapping",
+			"nvidia.com/cc.mode.applied=nvidia.com/cc.mode",
+			[]AnnotationLabelMapping{{Annotation: "nvidia.com/cc.mode.applied", Label: "nvidia.com/cc.mode"}},
+			false,
+		},
+		{
+			"several mappings with spaces",
+			" a=b , example.com/c=d ,",
+			[]AnnotationLabelMapping{{Annotation: "a", Label: "b"}, {Annotation: "example.com/c", Label: "d"}},
+			false,
+		},
+		{"missing separator", "a", nil, true},
+		{"missing annotation", "=b", nil, true},
+		{"missing label", "a=", nil, true},
+		{"invalid annotation key", "a b=c", nil, true},
+		{"invalid label key", "a=-c", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mappings, err := ParseAnnotationLabelMappings(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error parsing '%s'", tt.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(mappings, tt.expect) {
+				t.Errorf("expected %v, got %v", tt.expect, mappings)
+			}
+		})
+	}
+}
+
+func TestAnnotationLabels(t *testing.T) {
+	mappings := []AnnotationLabelMapping{
+		{Annotation: "example.com/mode", Label: "example.com/mode-label"},
+		{Annotation: "example.com/other", Label: "example.com/other-label"},
+	}
+	tests := []struct {
+		name    string
+		current map[string]string
+		expect  map[string]string
+	}{
+		{"no annotations", nil, map[string]string{}},
+		{
+			"copied",
+			map[string]string{"example.com/mode": "on", "unmapped": "x"},
+			map[string]string{"example.com/mode-label": "on"},
+		},
+		{
+			"empty value",
+			map[string]string{"example.com/mode": ""},
+			map[string]string{"example.com/mode-label": ""},
+		},
+		{
+			"invalid label value skipped",
+			map[string]string{"example.com/mode": "not a label value", "example.com/other": "off"},
+			map[string]string{"example.com/other-label": "off"},
+		},
+		{
+			"truncated",
+			map[string]string{"example.com/mode": strings.Repeat("a", 62) + "-.b"},
+			map[string]string{"example.com/mode-label": strings.Repeat("a", 62)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels := annotationLabels(tt.current, mappings)
+			if !reflect.DeepEqual(labels, tt.expect) {
+				t.Errorf("expected %v, got %v", tt.expect, labels)
+			}
+		})
+	}
+}
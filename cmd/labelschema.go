@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"regexp"
+
+	log "github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	DefaultLabelValueRegex = "^[a-z][a-z0-9-]{0,62}$"
+
+	// labelValueMaxLength is the maximum length of a Kubernetes label value.
+	labelValueMaxLength = 63
+)
+
+// LabelValueSchema is the shape expected of CC mode label values. Values
+// not matching it are reported early, but still handed to the usual CC mode
+// validation, which decides whether they are applied.
+type LabelValueSchema struct {
+	Regexp    *regexp.Regexp
+	MaxLength int
+
+	events *NodeEventRecorder
+}
+
+func NewLabelValueSchema(re *regexp.Regexp, events *NodeEventRecorder) *LabelValueSchema {
+	return &LabelValueSchema{
+		Regexp:    re,
+		MaxLength: labelValueMaxLength,
+		events:    events,
+	}
+}
+
+// Check logs a warning and emits a Warning event if value does not match the
+// schema. Empty values and CC mode policies are not checked. It is a no-op
+// on a nil schema.
+func (s *LabelValueSchema) Check(value string) {
+	if s == nil || value == "" || isCCModePolicy(value) {
+		return
+	}
+	if len(value) > s.MaxLength {
+		log.Warnf("CC mode label value '%s' is longer than %d characters", value, s.MaxLength)
+		s.events.Eventf(v1.EventTypeWarning, "CCModeLabelMalformed", "CC mode label value '%s' is longer than %d characters", value, s.MaxLength)
+		return
+	}
+	if !s.Regexp.MatchString(value) {
+		log.Warnf("CC mode label value '%s' does not match %s", value, s.Regexp)
+		s.events.Eventf(v1.EventTypeWarning, "CCModeLabelMalformed", "CC mode label value '%s' does not match %s", value, s.Regexp)
+	}
+}
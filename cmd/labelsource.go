@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const DefaultModeLabelSourceAnnotation = "nvidia.com/cc.mode.set-by"
+
+// LabelSourceChecker reads the annotation in which the controller that last
+// set the CC mode label records its identity, and refuses label values set by
+// one of --untrusted-label-sources unless --force-label-source-acceptance is
+// set.
+type LabelSourceChecker struct {
+	annotation string
+	untrusted  map[string]bool
+	force      bool
+	events     *NodeEventRecorder
+}
+
+func NewLabelSourceChecker(annotation string, untrusted map[string]bool, force bool, events *NodeEventRecorder) *LabelSourceChecker {
+	return &LabelSourceChecker{
+		annotation: annotation,
+		untrusted:  untrusted,
+		force:      force,
+		events:     events,
+	}
+}
+
+// Accept logs the source of the CC mode configuration of node and reports
+// whether it may be applied. It accepts every node on a nil checker.
+func (c *LabelSourceChecker) Accept(node *v1.Node) bool {
+	if c == nil {
+		return true
+	}
+	source := node.Annotations[c.annotation]
+	if source == "" {
+		log.Infof("CC mode label of node '%s' has no %s annotation, its source is unknown", node.Name, c.annotation)
+		return true
+	}
+	log.Infof("CC mode label of node '%s' was set by '%s'", node.Name, source)
+	if !c.untrusted[source] {
+		return true
+	}
+	if c.force {
+		log.Warnf("Accepting CC mode label set by untrusted source '%s' (see --force-label-source-acceptance)", source)
+		c.events.Eventf(v1.EventTypeWarning, "CCModeLabelUntrustedSource", "Accepting CC mode label set by untrusted source '%s'", source)
+		return true
+	}
+	log.Warnf("Ignoring CC mode label set by untrusted source '%s', set --force-label-source-acceptance to apply it", source)
+	c.events.Eventf(v1.EventTypeWarning, "CCModeLabelUntrustedSource", "Ignoring CC mode label set by untrusted source '%s'", source)
+	return false
+}
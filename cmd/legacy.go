@@ -0,0 +1,65 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	cli "github.com/urfave/cli/v2"
+)
+
+// legacyModeDefaults lists the flags whose default enables behavior the
+// original implementation did not have, with the value turning it off.
+var legacyModeDefaults = [][2]string{
+	{"informer-list-timeout", "0"},
+	{"max-pending-changes", "0"},
+	{"mutex-hold-warning-threshold", "0"},
+	{"kubernetes-watch-timeout-seconds", "0"},
+	{"success-rate-window", "0"},
+	{"config-dir", ""},
+	{"cc-mode-label-prefix-check", "false"},
+	{"skip-version-check", "true"},
+	{"label-history-size", "0"},
+	{"kubernetes-retry-status-codes", ""},
+	{"mode-label-source-annotation", ""},
+	{"device-health-check-interval", "0"},
+	{"label-value-regex", ""},
+	{"startup-timeout", "0"},
+	{"kubernetes-warning-handler", WarningHandlerKlog},
+}
+
+// applyLegacyMode turns off every behavior enabled by default since the
+// original implementation, unless its flag was given explicitly. Node
+// annotation and condition writes and events are skipped where they happen,
+// see onCCModeChanged, updateCCModeCondition and NodeEventRecorder.Eventf.
+func applyLegacyMode(c *cli.Context) error {
+	if c.IsSet("feature-gates") {
+		return fmt.Errorf("--feature-gates cannot be used with --legacy-mode")
+	}
+	for _, setting := range legacyModeDefaults {
+		name, value := setting[0], setting[1]
+		if c.IsSet(name) {
+			continue
+		}
+		if err := c.Set(name, value); err != nil {
+			return fmt.Errorf("error disabling --%s for --legacy-mode: %s", name, err)
+		}
+	}
+	log.Infof("Legacy mode enabled, feature gates, node annotations, node conditions and events are disabled")
+	return nil
+}
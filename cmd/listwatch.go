@@ -0,0 +1,258 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NewNodeListWatch returns a ListWatch restricted to the current node. It
+// mirrors cache.NewListWatchFromClient, but bounds every list call with
+// --informer-list-timeout so that a slow API server cannot block the
+// informer forever. With --watch-heartbeat-interval set, watches are
+// restarted when neither an event nor a bookmark arrived within the
+// interval, and --kubernetes-watch-timeout-seconds bounds every watch on the
+// server. With --use-watch-list set, watches are streaming lists that start
+// with the current state of the node followed by a bookmark. The outcome of
+// every call is reported to failures, which may be nil. The first list
+// resumes from the resource version stored by resourceVersions, which may be
+// nil, and lists from scratch if the API server rejects it.
+func NewNodeListWatch(clientset *kubernetes.Clientset, failures *WatchFailureTracker, resourceVersions *NodeResourceVersionTracker) *cache.ListWatch {
+	restClient := clientset.CoreV1().RESTClient()
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", os.Getenv("NODE_NAME"))
+
+	var listFunc func(options metav1.ListOptions) (runtime.Object, error)
+	listFunc = func(options metav1.ListOptions) (runtime.Object, error) {
+		if stored := resourceVersions.TakeStored(); stored != "" {
+			resumed := options
+			resumed.ResourceVersion = stored
+			obj, err := listFunc(resumed)
+			if err == nil {
+				log.Infof("Resumed node list from resource version %s", stored)
+				return obj, nil
+			}
+			log.Warnf("Unable to resume node list from resource version %s, listing from scratch: %s", stored, err)
+		}
+
+		options.FieldSelector = fieldSelector.String()
+
+		ctx := context.Background()
+		if informerListTimeoutFlag > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, informerListTimeoutFlag)
+			defer cancel()
+		}
+
+		obj, err := restClient.Get().
+			Resource(ResourceNodes).
+			VersionedParams(&options, metav1.ParameterCodec).
+			Do(ctx).
+			Get()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Errorf("Timed out listing node '%s' after %s (see --informer-list-timeout)", os.Getenv("NODE_NAME"), informerListTimeoutFlag)
+		}
+		failures.Observe(err)
+		return obj, err
+	}
+
+	delayer := &watchReconnectDelayer{
+		strategy: watchReconnectStrategyFlag,
+		initial:  watchReconnectInitialDelayFlag,
+		max:      watchReconnectMaxDelayFlag,
+		fixed:    watchReconnectDelayFlag,
+	}
+
+	watchFunc := func(options metav1.ListOptions) (watch.Interface, error) {
+		delayer.Wait()
+		options.Watch = true
+		options.FieldSelector = fieldSelector.String()
+		if watchHeartbeatIntervalFlag > 0 {
+			options.AllowWatchBookmarks = true
+		}
+		if useWatchListFlag {
+			// the API server rejects these options unless its WatchList
+			// feature gate is enabled
+			sendInitialEvents := true
+			options.SendInitialEvents = &sendInitialEvents
+			options.AllowWatchBookmarks = true
+			options.ResourceVersionMatch = metav1.ResourceVersionMatchNotOlderThan
+		}
+		if watchTimeoutSecondsFlag > 0 {
+			timeout := watchTimeoutSecondsFlag
+			options.TimeoutSeconds = &timeout
+		}
+		w, err := restClient.Get().
+			Resource(ResourceNodes).
+			VersionedParams(&options, metav1.ParameterCodec).
+			Watch(context.Background())
+		failures.Observe(err)
+		delayer.Observe(err)
+		if err != nil || watchHeartbeatIntervalFlag <= 0 {
+			return w, err
+		}
+		return newHeartbeatWatch(w, watchHeartbeatIntervalFlag), nil
+	}
+
+	return &cache.ListWatch{ListFunc: listFunc, WatchFunc: watchFunc}
+}
+
+const (
+	WatchReconnectImmediate = "immediate"
+	WatchReconnectBackoff   = "backoff"
+	WatchReconnectFixed     = "fixed"
+)
+
+// ValidateWatchReconnectStrategy returns an error if strategy is not a
+// supported --watch-reconnect-strategy value.
+func ValidateWatchReconnectStrategy(strategy string) error {
+	switch strategy {
+	case WatchReconnectImmediate, WatchReconnectBackoff, WatchReconnectFixed:
+		return nil
+	}
+	return fmt.Errorf("unsupported watch reconnect strategy '%s', must be one of %s, %s, %s", strategy, WatchReconnectImmediate, WatchReconnectBackoff, WatchReconnectFixed)
+}
+
+// watchReconnectDelayer delays establishing a watch after the previous
+// attempt failed, as set with --watch-reconnect-strategy. With backoff, the
+// delay doubles with every consecutive failure up to the maximum.
+type watchReconnectDelayer struct {
+	strategy string
+	initial  time.Duration
+	max      time.Duration
+	fixed    time.Duration
+
+	mutex    sync.Mutex
+	failures int
+}
+
+// Wait sleeps for the delay due before the next watch attempt.
+func (d *watchReconnectDelayer) Wait() {
+	d.mutex.Lock()
+	failures := d.failures
+	d.mutex.Unlock()
+	if failures == 0 {
+		return
+	}
+
+	var delay time.Duration
+	switch d.strategy {
+	case WatchReconnectFixed:
+		delay = d.fixed
+	case WatchReconnectBackoff:
+		delay = d.initial
+		for i := 1; i < failures && delay < d.max; i++ {
+			delay *= 2
+		}
+		if delay > d.max {
+			delay = d.max
+		}
+	}
+	if delay <= 0 {
+		return
+	}
+	log.Debugf("Delaying node watch reconnection by %s after %d failed attempts", delay, failures)
+	time.Sleep(delay)
+}
+
+// Observe records the outcome of a watch attempt.
+func (d *watchReconnectDelayer) Observe(err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if err != nil {
+		d.failures++
+	} else {
+		d.failures = 0
+	}
+}
+
+// heartbeatWatch stops the wrapped watch when no event, bookmarks included,
+// arrived within interval. The informer then re-establishes the watch.
+type heartbeatWatch struct {
+	watch.Interface
+	result   chan watch.Event
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+func newHeartbeatWatch(w watch.Interface, interval time.Duration) watch.Interface {
+	hw := &heartbeatWatch{
+		Interface: w,
+		result:    make(chan watch.Event),
+		stopCh:    make(chan struct{}),
+	}
+	go hw.run(interval)
+	return hw
+}
+
+func (w *heartbeatWatch) run(interval time.Duration) {
+	defer close(w.result)
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case event, ok := <-w.Interface.ResultChan():
+			if !ok {
+				return
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(interval)
+			select {
+			case w.result <- event:
+			case <-w.stopCh:
+				return
+			}
+		case <-timer.C:
+			log.Warnf("No watch event or bookmark received for %s, restarting node watch", interval)
+			w.Interface.Stop()
+			return
+		case <-w.stopCh:
+			return
+		}
+	}
+}
+
+func (w *heartbeatWatch) ResultChan() <-chan watch.Event {
+	return w.result
+}
+
+func (w *heartbeatWatch) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopCh)
+		w.Interface.Stop()
+	})
+}
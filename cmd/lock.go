@@ -0,0 +1,142 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	DefaultModeChangeLockAnnotation = "nvidia.com/cc-mode-lock"
+
+	modeChangeLockRetryInterval = 5 * time.Second
+)
+
+// modeChangeLockRecord is the value of the lock annotation.
+type modeChangeLockRecord struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// NodeAnnotationLock is a lock on the CC mode of the node shared by all
+// daemons targeting it, held in a node annotation. The annotation is written
+// with the resourceVersion of the node it was read from, so that only one of
+// concurrent writers succeeds. A lock not released before it expires, e.g.
+// because its holder crashed, can be taken over.
+type NodeAnnotationLock struct {
+	clientset  *kubernetes.Clientset
+	nodeName   string
+	annotation string
+	holder     string
+	ttl        time.Duration
+}
+
+func NewNodeAnnotationLock(clientset *kubernetes.Clientset, nodeName, annotation, holder string, ttl time.Duration) *NodeAnnotationLock {
+	return &NodeAnnotationLock{
+		clientset:  clientset,
+		nodeName:   nodeName,
+		annotation: annotation,
+		holder:     holder,
+		ttl:        ttl,
+	}
+}
+
+// Acquire waits until the lock is free or expired and takes it.
+func (l *NodeAnnotationLock) Acquire(ctx context.Context) error {
+	for {
+		acquired, err := l.tryAcquire(ctx)
+		if err != nil && !apierrors.IsConflict(err) {
+			return fmt.Errorf("error acquiring lock '%s': %s", l.annotation, err)
+		}
+		if acquired {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(modeChangeLockRetryInterval):
+		}
+	}
+}
+
+func (l *NodeAnnotationLock) tryAcquire(ctx context.Context) (bool, error) {
+	node, err := l.clientset.CoreV1().Nodes().Get(ctx, l.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	if value, ok := node.Annotations[l.annotation]; ok {
+		var record modeChangeLockRecord
+		if err := json.Unmarshal([]byte(value), &record); err != nil {
+			log.Warnf("Taking over lock '%s' with invalid value '%s'", l.annotation, value)
+		} else if record.Holder != l.holder && time.Now().Before(record.Expires) {
+			log.Infof("CC mode change lock is held by %s until %s, waiting", record.Holder, record.Expires.Format(time.RFC3339))
+			return false, nil
+		} else if record.Holder != l.holder {
+			log.Warnf("Taking over lock '%s' of %s which expired at %s", l.annotation, record.Holder, record.Expires.Format(time.RFC3339))
+		}
+	}
+
+	data, err := json.Marshal(modeChangeLockRecord{Holder: l.holder, Expires: time.Now().Add(l.ttl).UTC()})
+	if err != nil {
+		return false, err
+	}
+	if node.Annotations == nil {
+		node.Annotations = make(map[string]string)
+	}
+	node.Annotations[l.annotation] = string(data)
+	// Update sends the resourceVersion read above and fails with a conflict
+	// if another daemon wrote the node in between
+	if _, err := l.clientset.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Release removes the lock annotation if it is still held by this daemon.
+func (l *NodeAnnotationLock) Release(ctx context.Context) error {
+	for {
+		node, err := l.clientset.CoreV1().Nodes().Get(ctx, l.nodeName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("error releasing lock '%s': %s", l.annotation, err)
+		}
+		var record modeChangeLockRecord
+		value, ok := node.Annotations[l.annotation]
+		if !ok || json.Unmarshal([]byte(value), &record) != nil || record.Holder != l.holder {
+			log.Warnf("Lock '%s' is no longer held by %s, not releasing it", l.annotation, l.holder)
+			return nil
+		}
+		delete(node.Annotations, l.annotation)
+		_, err = l.clientset.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+		if apierrors.IsConflict(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error releasing lock '%s': %s", l.annotation, err)
+		}
+		return nil
+	}
+}
@@ -18,67 +18,437 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
+	"regexp"
+	"strings"
 	"sync"
+	"syscall"
+	"text/template"
+	"time"
 
+	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 	cli "github.com/urfave/cli/v2"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/version"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
 const (
-	ResourceNodes        = "nodes"
-	CCModeConfigLabel    = "nvidia.com/cc.mode"
-	DefaultHostNvidiaDir = "/usr/local/nvidia"
+	ResourceNodes          = "nodes"
+	CCModeConfigLabel      = "nvidia.com/cc.mode"
+	CCModePolicyAnnotation = "nvidia.com/cc.mode.policy"
+	DefaultHostNvidiaDir   = "/usr/local/nvidia"
 )
 
 var (
-	kubeconfigFlag    string
-	defaultCCModeFlag string
+	kubeconfigFlag                  string
+	kubeconfigInClusterFallbackFlag bool
+	defaultCCModeFlag               string
+	informerListTimeoutFlag         time.Duration
+	featureGatesFlag                string
+	httpAddrFlag                    string
+	nodeAnnotationsToLabelsFlag     string
+	labelChangeBackoffWindowFlag    time.Duration
+	maxPendingChangesFlag           int
+	backpressureStrategyFlag        string
+	ccModeLabelRequiredFlag         bool
+	modeRequirementsFileFlag        string
+	watchHeartbeatIntervalFlag      time.Duration
+	precheckCmdFlag                 string
+	scriptArgsTemplateFlag          string
+	terminateOnScriptPermErrFlag    bool
+	annotationCacheTTLFlag          time.Duration
+	kubernetesProxyURLFlag          string
+	kubernetesProxyUsernameFlag     string
+	kubernetesProxyPasswordFlag     string
+	scriptGroupFlag                 string
+	scriptUserFlag                  string
+	alertPolicyConfigMapFlag        string
+	transitionTableConfigMapFlag    string
+	selfMonitorFlag                 bool
+	ccModeAnnotationTTLFlag         time.Duration
+	gpuDirectRDMACompatCheckFlag    bool
+	allowGPUDirectRDMAWithCCOnFlag  bool
+	deviceFilterFlag                string
+	waitForCRDsFlag                 string
+	startupRetryIntervalFlag        time.Duration
+	startupTimeoutFlag              time.Duration
+	gracefulTransitionFlag          bool
+	gracefulTimeoutFlag             time.Duration
+	gracefulTimeoutPolicyFlag       string
+	coordinationGroupLabelFlag      string
+	coordinationLeaseNamespaceFlag  string
+	coordinationLeaseDurationFlag   time.Duration
+	scriptNetworkNamespaceFlag      string
+	watchDeletePreventsApplyFlag    bool
+	runtimeClassFilterFlag          string
+	deviceDiscoveryPluginFlag       string
+	deviceDiscoveryIntervalFlag     time.Duration
+	deviceHealthCheckIntervalFlag   time.Duration
+	ccModeInventoryFlag             bool
+	enableModeChangeTracingFlag     bool
+	modeChangeTraceFileFlag         string
+	forcedApplyIntervalFlag         time.Duration
+	resourceVersionFileFlag         string
+	disableInjectionProtectionFlag  bool
+	labelValueRegexFlag             string
+	enableNodeLeaseHeartbeatFlag    bool
+	namespaceFlag                   string
+	leaseRenewalIntervalFlag        time.Duration
+	leaseFailureThresholdFlag       int
+	scriptStdoutLogLevelFlag        string
+	scriptStderrLogLevelFlag        string
+	kubernetesImpersonateUserFlag   string
+	kubernetesImpersonateUIDFlag    string
+	kubernetesWarningHandlerFlag    string
+	parallelNodeDiscoveryFlag       bool
+	watchReconnectStrategyFlag      string
+	watchReconnectInitialDelayFlag  time.Duration
+	watchReconnectMaxDelayFlag      time.Duration
+	watchReconnectDelayFlag         time.Duration
+	nodeUpdateBatchSizeFlag         int
+	enableAdmissionControllerFlag   bool
+	admissionControllerPortFlag     int
+	eventSourceComponentFlag        string
+	daemonIDFlag                    string
+	skipUnchangedModesFlag          bool
+	watchErrorHandlerFlag           string
+	watchFailureThresholdFlag       int
+	nodeEventFilterFlag             bool
+	scriptNiceValueFlag             int
+	alertOnStaleAnnotationFlag      time.Duration
+	stalenessCheckIntervalFlag      time.Duration
+	ccModeTemplateFlag              string
+	deviceIDFormatFlag              string
+	labelPatchStrategyFlag          string
+	nodeGroupConfigMapFlag          string
+	nodeGroupLabelFlag              string
+	mutexHoldWarningThresholdFlag   time.Duration
+	scriptEnvironmentSecretFlag     string
+	statusPageTemplateFlag          string
+	tlsCertFileFlag                 string
+	tlsKeyFileFlag                  string
+	tlsClientCAFileFlag             string
+	changeWindowFlag                string
+	changeWindowTimezoneFlag        string
+	watchTimeoutSecondsFlag         int64
+	deviceTempMaxCelsiusFlag        float64
+	deviceTempRetryDelayFlag        time.Duration
+	nodeOSCheckFlag                 string
+	skipOSCheckFlag                 bool
+	successRateWindowFlag           int
+	successRateAlertThresholdFlag   float64
+	configValidationOnlyFlag        bool
+	blockOnTaintKeyFlag             string
+	taintRecheckIntervalFlag        time.Duration
+	startupSelfTestFlag             bool
+	nodeAntiAffinityLabelsFlag      string
+	modeChangeLockAnnotationFlag    string
+	modeChangeLockTTLFlag           time.Duration
+	auditLogFileFlag                string
+	labelChangeDigestFlag           bool
+	useWatchListFlag                bool
+	configDirFlag                   string
+	ccModeLabelPrefixCheckFlag      bool
+	kubernetesBurstHandlingFlag     string
+	minKubernetesVersionFlag        string
+	skipVersionCheckFlag            bool
+	nodeRestartDetectFlag           bool
+	skipModesOnDrainFlag            string
+	maxImpactScoreFlag              int
+	impactScoreRetryDelayFlag       time.Duration
+	legacyModeFlag                  bool
+	cleanupOnExitFlag               bool
+	scriptCgroupFlag                string
+	labelHistorySizeFlag            int
+	flappingThresholdFlag           int
+	flappingWindowFlag              time.Duration
+	scriptOutputEncodingFlag        string
+	kubernetesRetryStatusCodesFlag  string
+	modeVerificationPollFlag        time.Duration
+	modeVerificationTimeoutFlag     time.Duration
+	enablePprofMutexFlag            bool
+	enablePprofBlockFlag            bool
+	ccModeCapabilityCheckFlag       bool
+	jsonPatchOpsFlag                string
+	modeLabelSourceAnnotationFlag   string
+	untrustedLabelSourcesFlag       string
+	forceLabelSourceAcceptanceFlag  bool
+
+	kubernetesProxyURL *url.URL
+	scriptGID          *uint32
+	scriptUser         *ScriptUser
+
+	alertPolicyNamespace string
+	alertPolicyName      string
+
+	transitionTableNamespace string
+	transitionTableName      string
+
+	nodeGroupNamespace string
+	nodeGroupName      string
+
+	scriptEnvironmentNamespace string
+	scriptEnvironmentName      string
+	scriptEnvironment          = NewScriptEnvironment()
+
+	scriptArgsTemplate *template.Template
+	ccModeTemplate     *template.Template
+	deviceIDFormat     DeviceIDFormat
+	labelPatchStrategy LabelPatchStrategy
+	statusPageTemplate *htmltemplate.Template
+	changeWindows      []ChangeWindow
+	unsupportedOSError error
+
+	antiAffinitySelector labels.Selector
+
+	minKubernetesVersion *version.Version
+	skipModesOnDrain     map[string]bool
+	scriptOutputEncoding encoding.Encoding = unicode.UTF8
+
+	kubernetesRetryStatusCodes map[int]bool
+	untrustedLabelSources      map[string]bool
+	waitForCRDNames            []string
+	gracefulTimeoutPolicy      GracefulTimeoutPolicy
+	backpressureStrategy       WatcherBackpressureStrategy
+	labelValueRegexp           *regexp.Regexp
+	scriptStdoutLogLevel       = log.DebugLevel
+	scriptStderrLogLevel       = log.WarnLevel
+	runtimeClassFilter         map[string]bool
+	deviceFilter               DeviceFilter
+	deviceDiscovery            DeviceDiscoveryPlugin
+
+	// ccModeCapabilities is set in start before any cc mode is applied
+	ccModeCapabilities *CCModeCapabilityMatrix
+
+	// configDirSettings holds the settings read from --config-dir on startup
+	configDirSettings map[string]string
+
+	// temperatureGuard is set in start once the event recorder exists
+	temperatureGuard *DeviceTemperatureGuard
+	// modeChangeLock is set in start once the clientset exists
+	modeChangeLock *NodeAnnotationLock
+	// impactScore is set in start once the event recorder exists
+	impactScore *ImpactScoreCalculator
+	// labelSource is set in start once the event recorder exists
+	labelSource *LabelSourceChecker
+	// gpuDirectRDMACompat is set in start once the event recorder exists
+	gpuDirectRDMACompat *GPUDirectRDMACompatChecker
+	// deviceHealth is set in start once the event recorder exists
+	deviceHealth *DeviceHealthMap
+	// inventory is set in start with --cc-mode-inventory
+	inventory *CCModeInventoryWriter
+	// labelSchema is set in start once the event recorder exists
+	labelSchema *LabelValueSchema
+	// changeCoordinator is set in start once the node was read
+	changeCoordinator *ChangeCoordinator
+	// transitions is set in start before any cc mode is applied
+	transitions *CCModeStateMachine
+	// informerSynced and lastScriptSuccess are set in start with the HTTP
+	// server, they back /healthz
+	informerSynced    *InformerSyncedChecker
+	lastScriptSuccess *LastScriptSuccessChecker
+
+	annotationLabelMappings []AnnotationLabelMapping
+	jsonPatchOps            []json.RawMessage
+	modeRequirements        map[string]ModeRequirements
+
+	featureGates = NewFeatureGate()
 )
 
 type SyncableCCModeConfig struct {
-	cond     *sync.Cond
-	mutex    sync.Mutex
-	current  string
-	lastRead string
+	cond       *sync.Cond
+	mutex      *CriticalSectionTracer
+	current    string
+	lastRead   string
+	backoff    *BackoffManager
+	pending    int
+	maxPending int
+	strategy   WatcherBackpressureStrategy
+	// drained is signalled when Get reads the pending values
+	drained *sync.Cond
+	resync  bool
+	reapply bool
 }
 
 func NewSyncableCCModeConfig() *SyncableCCModeConfig {
 	var m SyncableCCModeConfig
-	m.cond = sync.NewCond(&m.mutex)
+	m.mutex = NewCriticalSectionTracer("SyncableCCModeConfig")
+	m.cond = sync.NewCond(m.mutex)
+	m.drained = sync.NewCond(m.mutex)
+	m.strategy = DropOldestStrategy{}
 	return &m
 }
 
+// SetBackoffWindow makes Set calls made within window of each other wake
+// waiters in Get only once. It must be called before the first Set.
+func (m *SyncableCCModeConfig) SetBackoffWindow(window time.Duration) {
+	if window <= 0 {
+		return
+	}
+	m.backoff = NewBackoffManager(window, func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		m.cond.Broadcast()
+	})
+}
+
+// SetMaxPending sets how many values may be Set without being read by Get
+// before the backpressure strategy is asked how to handle the overflow. Only
+// the most recent value is ever returned by Get.
+func (m *SyncableCCModeConfig) SetMaxPending(max int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.maxPending = max
+}
+
+// SetBackpressureStrategy replaces the default DropOldestStrategy.
+func (m *SyncableCCModeConfig) SetBackpressureStrategy(strategy WatcherBackpressureStrategy) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.strategy = strategy
+}
+
 func (m *SyncableCCModeConfig) Set(value string) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
+	if m.maxPending > 0 && m.pending >= m.maxPending {
+		switch m.strategy.OnOverflow(m.pending+1, value) {
+		case DropAll:
+			log.Warnf("More than %d CC mode changes pending, dropping all of them including '%s'", m.maxPending, value)
+			m.current = m.lastRead
+			m.pending = 0
+			return
+		case PauseWatch:
+			log.Warnf("More than %d CC mode changes pending, pausing the node watch until they are processed", m.maxPending)
+			for m.pending >= m.maxPending {
+				m.drained.Wait()
+			}
+		default:
+			log.Warnf("More than %d CC mode changes pending, dropping the oldest and keeping '%s'", m.maxPending, value)
+			m.pending--
+		}
+	}
 	m.current = value
+	m.pending++
+	if m.backoff != nil {
+		m.backoff.Trigger()
+		return
+	}
 	m.cond.Broadcast()
 }
 
+// ResyncDefault wakes a waiting Get if no CC mode is currently requested,
+// so that a changed default CC mode gets applied.
+func (m *SyncableCCModeConfig) ResyncDefault() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.current != "" {
+		return
+	}
+	m.resync = true
+	m.cond.Broadcast()
+}
+
+// Reapply wakes a waiting Get right away, bypassing the backoff window, so
+// that the current CC mode is applied again. TakeReapply reports it.
+func (m *SyncableCCModeConfig) Reapply() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.resync = true
+	m.reapply = true
+	m.cond.Broadcast()
+}
+
+// TakeReapply reports whether Reapply was called since the last call.
+func (m *SyncableCCModeConfig) TakeReapply() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	reapply := m.reapply
+	m.reapply = false
+	return reapply
+}
+
 func (m *SyncableCCModeConfig) Get() string {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
-	if m.lastRead == m.current {
+	if m.lastRead == m.current && !m.resync {
 		m.cond.Wait()
 	}
 	m.lastRead = m.current
+	m.pending = 0
+	m.resync = false
+	m.drained.Broadcast()
+	return m.lastRead
+}
+
+// GetNow returns the most recent value like Get, without waiting for it to
+// change.
+func (m *SyncableCCModeConfig) GetNow() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.lastRead = m.current
+	m.pending = 0
+	m.resync = false
+	m.drained.Broadcast()
 	return m.lastRead
 }
 
 func main() {
+	err := newApp().Run(os.Args)
+	if err != nil {
+		log.SetOutput(os.Stderr)
+		log.Printf("Error: %v", err)
+		os.Exit(1)
+	}
+}
+
+// newApp returns the daemon with its subcommands and flags.
+func newApp() *cli.App {
 	c := cli.NewApp()
 	c.Before = validateFlagsOrEnv
 	c.Action = start
+	c.Commands = []*cli.Command{
+		{
+			Name:      "verify-audit",
+			Usage:     "verify the digest chain of an audit log written with --label-change-digest",
+			ArgsUsage: "<audit-log-file>",
+			Action:    verifyAuditCommand,
+		},
+		{
+			Name:      "validate-config",
+			Usage:     "validate the flags and env together with the settings of a config directory, print the resolved settings as JSON and exit, without connecting to the cluster",
+			ArgsUsage: "[<config-dir>]",
+			Action:    validateConfigCommand,
+		},
+		{
+			Name:   "migrate-labels",
+			Usage:  "move a legacy node label to a node annotation on every node of the cluster, removing the label",
+			Flags:  migrateLabelsFlags,
+			Action: migrateLabelsCommand,
+		},
+		{
+			Name:   "list-inventory",
+			Usage:  "print the CC mode of every node recorded in the cluster-inventory CCModeInventory",
+			Action: listInventoryCommand,
+		},
+	}
 
 	c.Flags = []cli.Flag{
 		&cli.StringFlag{
@@ -88,6 +458,13 @@ func main() {
 			Destination: &kubeconfigFlag,
 			EnvVars:     []string{"KUBECONFIG"},
 		},
+		&cli.BoolFlag{
+			Name:        "kubeconfig-in-cluster-fallback",
+			Value:       false,
+			Usage:       "use the in-cluster config if the file passed with --kubeconfig does not exist",
+			Destination: &kubeconfigInClusterFallbackFlag,
+			EnvVars:     []string{"KUBECONFIG_IN_CLUSTER_FALLBACK"},
+		},
 		&cli.StringFlag{
 			Name:        "default-cc-mode",
 			Aliases:     []string{"m"},
@@ -96,28 +473,1408 @@ func main() {
 			Destination: &defaultCCModeFlag,
 			EnvVars:     []string{"DEFAULT_CC_MODE"},
 		},
+		&cli.DurationFlag{
+			Name:        "informer-list-timeout",
+			Value:       60 * time.Second,
+			Usage:       "timeout for each list call made by the node informer, 0 disables the timeout",
+			Destination: &informerListTimeoutFlag,
+			EnvVars:     []string{"INFORMER_LIST_TIMEOUT"},
+		},
+		&cli.StringFlag{
+			Name:        "feature-gates",
+			Value:       "",
+			Usage:       "comma-separated list of Feature=true|false pairs enabling experimental features",
+			Destination: &featureGatesFlag,
+			EnvVars:     []string{"FEATURE_GATES"},
+		},
+		&cli.StringFlag{
+			Name:        "http-addr",
+			Value:       "",
+			Usage:       "address to serve the HTTP endpoints (/healthz, /preview, /status) on, empty disables the HTTP server",
+			Destination: &httpAddrFlag,
+			EnvVars:     []string{"HTTP_ADDR"},
+		},
+		&cli.StringFlag{
+			Name:        "node-annotations-to-labels",
+			Value:       "",
+			Usage:       "comma-separated list of annotation-key=label-key pairs copied from node annotations to labels after each successful CC mode change",
+			Destination: &nodeAnnotationsToLabelsFlag,
+			EnvVars:     []string{"NODE_ANNOTATIONS_TO_LABELS"},
+		},
+		&cli.DurationFlag{
+			Name:        "label-change-backoff-window",
+			Value:       0,
+			Usage:       "coalesce label changes arriving within this window into a single wake-up of the main loop, 0 disables coalescing",
+			Destination: &labelChangeBackoffWindowFlag,
+			EnvVars:     []string{"LABEL_CHANGE_BACKOFF_WINDOW"},
+		},
+		&cli.IntFlag{
+			Name:        "max-pending-changes",
+			Value:       10,
+			Usage:       "number of unprocessed CC mode changes after which the oldest are dropped with a warning, 0 disables the limit",
+			Destination: &maxPendingChangesFlag,
+			EnvVars:     []string{"MAX_PENDING_CHANGES"},
+		},
+		&cli.StringFlag{
+			Name:        "backpressure-strategy",
+			Value:       "drop-oldest",
+			Usage:       "what to do with more than --max-pending-changes unprocessed CC mode changes: drop-oldest keeps the latest, drop-all discards all of them including the latest, pause-watch stops the node watch until they are processed",
+			Destination: &backpressureStrategyFlag,
+			EnvVars:     []string{"BACKPRESSURE_STRATEGY"},
+		},
+		&cli.BoolFlag{
+			Name:        "cc-mode-label-required",
+			Value:       false,
+			Usage:       "fail at startup if the node label nvidia.com/cc.mode is not applied instead of using the default cc mode",
+			Destination: &ccModeLabelRequiredFlag,
+			EnvVars:     []string{"CC_MODE_LABEL_REQUIRED"},
+		},
+		&cli.StringFlag{
+			Name:        "mode-requirements-file",
+			Value:       "",
+			Usage:       "path to a YAML file mapping cc modes to the resources they require before being applied",
+			Destination: &modeRequirementsFileFlag,
+			EnvVars:     []string{"MODE_REQUIREMENTS_FILE"},
+		},
+		&cli.DurationFlag{
+			Name:        "watch-heartbeat-interval",
+			Value:       0,
+			Usage:       "restart the node watch when no event or bookmark was received within this interval, 0 disables the watchdog",
+			Destination: &watchHeartbeatIntervalFlag,
+			EnvVars:     []string{"WATCH_HEARTBEAT_INTERVAL"},
+		},
+		&cli.StringFlag{
+			Name:        "cc-mode-change-precheck-cmd",
+			Value:       "",
+			Usage:       "command invoked with the target cc mode before a cc mode change, the change is skipped if it exits 0",
+			Destination: &precheckCmdFlag,
+			EnvVars:     []string{"CC_MODE_CHANGE_PRECHECK_CMD"},
+		},
+		&cli.StringFlag{
+			Name:        "script-args-template",
+			Value:       DefaultScriptArgsTemplate,
+			Usage:       "Go template rendering the cc-manager.sh arguments used to set the cc mode, with .Mode, .DeviceIDs and .AllDevices available",
+			Destination: &scriptArgsTemplateFlag,
+			EnvVars:     []string{"SCRIPT_ARGS_TEMPLATE"},
+		},
+		&cli.BoolFlag{
+			Name:        "terminate-on-script-permission-error",
+			Value:       false,
+			Usage:       "exit immediately if cc-manager.sh cannot be run due to a permission error",
+			Destination: &terminateOnScriptPermErrFlag,
+			EnvVars:     []string{"TERMINATE_ON_SCRIPT_PERMISSION_ERROR"},
+		},
+		&cli.DurationFlag{
+			Name:        "annotation-cache-ttl",
+			Value:       30 * time.Second,
+			Usage:       "duration for which cached node annotations are used before the node is fetched again",
+			Destination: &annotationCacheTTLFlag,
+			EnvVars:     []string{"ANNOTATION_CACHE_TTL"},
+		},
+		&cli.StringFlag{
+			Name:        "kubernetes-proxy-url",
+			Value:       "",
+			Usage:       "URL of an HTTP proxy used for all Kubernetes API calls",
+			Destination: &kubernetesProxyURLFlag,
+			EnvVars:     []string{"KUBERNETES_PROXY_URL"},
+		},
+		&cli.StringFlag{
+			Name:        "kubernetes-proxy-username",
+			Value:       "",
+			Usage:       "username to authenticate with the proxy set by --kubernetes-proxy-url",
+			Destination: &kubernetesProxyUsernameFlag,
+			EnvVars:     []string{"KUBERNETES_PROXY_USERNAME"},
+		},
+		&cli.StringFlag{
+			Name:        "kubernetes-proxy-password",
+			Value:       "",
+			Usage:       "password to authenticate with the proxy set by --kubernetes-proxy-url",
+			Destination: &kubernetesProxyPasswordFlag,
+			EnvVars:     []string{"KUBERNETES_PROXY_PASSWORD"},
+		},
+		&cli.StringFlag{
+			Name:        "script-group",
+			Value:       "",
+			Usage:       "group name or GID cc-manager.sh is run with as its primary and supplementary group",
+			Destination: &scriptGroupFlag,
+			EnvVars:     []string{"SCRIPT_GROUP"},
+		},
+		&cli.StringFlag{
+			Name:        "script-user",
+			Value:       "",
+			Usage:       "user name or UID cc-manager.sh is run as, with the primary and supplementary groups of the user unless --script-group is set",
+			Destination: &scriptUserFlag,
+			EnvVars:     []string{"SCRIPT_USER"},
+		},
+		&cli.StringFlag{
+			Name:        "alert-policy-configmap",
+			Value:       "",
+			Usage:       "namespace/name of a ConfigMap holding the CC mode alert policy under the 'alert-policy' key",
+			Destination: &alertPolicyConfigMapFlag,
+			EnvVars:     []string{"ALERT_POLICY_CONFIGMAP"},
+		},
+		&cli.StringFlag{
+			Name:        "transition-table-configmap",
+			Value:       "",
+			Usage:       "namespace/name of a ConfigMap holding the allowed CC mode transitions under the 'transition-table' key, disallowed changes go through intermediate modes or are rejected",
+			Destination: &transitionTableConfigMapFlag,
+			EnvVars:     []string{"TRANSITION_TABLE_CONFIGMAP"},
+		},
+		&cli.BoolFlag{
+			Name:        "skip-unchanged-modes",
+			Value:       false,
+			Usage:       "do not invoke cc-manager.sh when the requested cc mode equals the last successfully applied one",
+			Destination: &skipUnchangedModesFlag,
+			EnvVars:     []string{"SKIP_UNCHANGED_MODES"},
+		},
+		&cli.StringFlag{
+			Name:        "watch-error-handler",
+			Aliases:     []string{"cc-mode-watch-error-handler"},
+			Value:       "",
+			Usage:       "executable invoked with <node-name> <last-mode> <error-message> when the node watch fails --watch-failure-threshold consecutive times",
+			Destination: &watchErrorHandlerFlag,
+			EnvVars:     []string{"WATCH_ERROR_HANDLER"},
+		},
+		&cli.IntFlag{
+			Name:        "watch-failure-threshold",
+			Value:       3,
+			Usage:       "number of consecutive node list or watch failures after which --watch-error-handler is invoked",
+			Destination: &watchFailureThresholdFlag,
+			EnvVars:     []string{"WATCH_FAILURE_THRESHOLD"},
+		},
+		&cli.BoolFlag{
+			Name:        "node-event-filter",
+			Value:       false,
+			Usage:       "log the Kubernetes events of the node whose reason relates to CC mode",
+			Destination: &nodeEventFilterFlag,
+			EnvVars:     []string{"NODE_EVENT_FILTER"},
+		},
+		&cli.IntFlag{
+			Name:        "script-nice-value",
+			Value:       0,
+			Usage:       "nice value, from -20 to 19, cc-manager.sh is run with",
+			Destination: &scriptNiceValueFlag,
+			EnvVars:     []string{"SCRIPT_NICE_VALUE"},
+		},
+		&cli.DurationFlag{
+			Name:        "alert-on-stale-annotation",
+			Value:       0,
+			Usage:       "emit a Warning event when the nvidia.com/cc.mode.applied-at annotation is older than this duration, 0 disables the check",
+			Destination: &alertOnStaleAnnotationFlag,
+			EnvVars:     []string{"ALERT_ON_STALE_ANNOTATION"},
+		},
+		&cli.DurationFlag{
+			Name:        "annotation-staleness-check-interval",
+			Value:       5 * time.Minute,
+			Usage:       "interval at which the age of the nvidia.com/cc.mode.applied-at annotation is checked",
+			Destination: &stalenessCheckIntervalFlag,
+			EnvVars:     []string{"ANNOTATION_STALENESS_CHECK_INTERVAL"},
+		},
+		&cli.StringFlag{
+			Name:        "cc-mode-template",
+			Value:       "",
+			Usage:       "Go template evaluated against the node labels to compute the cc mode instead of reading the nvidia.com/cc.mode label, an empty result selects the default cc mode",
+			Destination: &ccModeTemplateFlag,
+			EnvVars:     []string{"CC_MODE_TEMPLATE"},
+		},
+		&cli.StringFlag{
+			Name:        "device-id-format",
+			Value:       "",
+			Usage:       "format (pci, uuid or bus-id) the CC_CAPABLE_DEVICE_IDS entries are converted to before being passed to cc-manager.sh, empty passes them unchanged",
+			Destination: &deviceIDFormatFlag,
+			EnvVars:     []string{"DEVICE_ID_FORMAT"},
+		},
+		&cli.StringFlag{
+			Name:        "label-patch-strategy",
+			Value:       string(LabelPatchMerge),
+			Usage:       "how node labels and annotations are patched: merge (strategic merge patch), json (JSON patch) or apply (server-side apply)",
+			Destination: &labelPatchStrategyFlag,
+			EnvVars:     []string{"LABEL_PATCH_STRATEGY"},
+		},
+		&cli.StringFlag{
+			Name:        "node-group-cc-mode-configmap",
+			Value:       "",
+			Usage:       "namespace/name of a ConfigMap mapping values of the --node-group-label node label to default cc modes",
+			Destination: &nodeGroupConfigMapFlag,
+			EnvVars:     []string{"NODE_GROUP_CC_MODE_CONFIGMAP"},
+		},
+		&cli.StringFlag{
+			Name:        "node-group-label",
+			Value:       "",
+			Usage:       "node label whose value selects the node group entry of --node-group-cc-mode-configmap",
+			Destination: &nodeGroupLabelFlag,
+			EnvVars:     []string{"NODE_GROUP_LABEL"},
+		},
+		&cli.DurationFlag{
+			Name:        "mutex-hold-warning-threshold",
+			Value:       100 * time.Millisecond,
+			Usage:       "log a warning when the cc mode config mutex is held for longer than this duration, 0 disables the warning",
+			Destination: &mutexHoldWarningThresholdFlag,
+			EnvVars:     []string{"MUTEX_HOLD_WARNING_THRESHOLD"},
+		},
+		&cli.StringFlag{
+			Name:        "script-environment-secret",
+			Value:       "",
+			Usage:       "namespace/name of a Secret whose data is passed to cc-manager.sh as additional environment variables",
+			Destination: &scriptEnvironmentSecretFlag,
+			EnvVars:     []string{"SCRIPT_ENVIRONMENT_SECRET"},
+		},
+		&cli.StringFlag{
+			Name:        "status-page-template",
+			Value:       "",
+			Usage:       "path to an HTML template rendering the /status endpoint for clients accepting text/html, empty uses the built-in page",
+			Destination: &statusPageTemplateFlag,
+			EnvVars:     []string{"STATUS_PAGE_TEMPLATE"},
+		},
+		&cli.StringFlag{
+			Name:        "tls-cert-file",
+			Value:       "",
+			Usage:       "certificate file to serve the HTTP endpoints over TLS with, reloaded on SIGHUP",
+			Destination: &tlsCertFileFlag,
+			EnvVars:     []string{"TLS_CERT_FILE"},
+		},
+		&cli.StringFlag{
+			Name:        "tls-key-file",
+			Value:       "",
+			Usage:       "private key file of --tls-cert-file, reloaded on SIGHUP",
+			Destination: &tlsKeyFileFlag,
+			EnvVars:     []string{"TLS_KEY_FILE"},
+		},
+		&cli.StringFlag{
+			Name:        "tls-client-ca-file",
+			Value:       "",
+			Usage:       "CA bundle client certificates must be signed by, enabling mutual TLS on the HTTP endpoints, reloaded on SIGHUP",
+			Destination: &tlsClientCAFileFlag,
+			EnvVars:     []string{"TLS_CLIENT_CA_FILE"},
+		},
+		&cli.StringFlag{
+			Name:        "change-window",
+			Value:       "",
+			Usage:       "semicolon-separated list of maintenance windows outside of which cc mode changes are deferred, each a 5-field cron schedule of the window opening optionally followed by its duration (default 1h), e.g. '0 2 * * 6,0 4h' (alpha, requires --feature-gates=ScheduledModeChange=true)",
+			Destination: &changeWindowFlag,
+			EnvVars:     []string{"CHANGE_WINDOW"},
+		},
+		&cli.StringFlag{
+			Name:        "change-window-timezone",
+			Value:       "UTC",
+			Usage:       "IANA timezone the --change-window schedules are evaluated in",
+			Destination: &changeWindowTimezoneFlag,
+			EnvVars:     []string{"CHANGE_WINDOW_TIMEZONE"},
+		},
+		&cli.Int64Flag{
+			Name:        "kubernetes-watch-timeout-seconds",
+			Aliases:     []string{"kubernetes-timeout-seconds"},
+			Value:       300,
+			Usage:       "server-side timeout of each node watch, after which the watch is re-established, 0 keeps the randomized client-go timeout",
+			Destination: &watchTimeoutSecondsFlag,
+			EnvVars:     []string{"KUBERNETES_WATCH_TIMEOUT_SECONDS"},
+		},
+		&cli.Float64Flag{
+			Name:        "device-temp-max-celsius",
+			Value:       0,
+			Usage:       "delay cc mode changes while a GPU is hotter than this temperature in degrees celsius, 0 disables the check",
+			Destination: &deviceTempMaxCelsiusFlag,
+			EnvVars:     []string{"DEVICE_TEMP_MAX_CELSIUS"},
+		},
+		&cli.DurationFlag{
+			Name:        "device-temp-retry-delay",
+			Value:       60 * time.Second,
+			Usage:       "interval at which GPU temperatures are checked again while a cc mode change is delayed by --device-temp-max-celsius",
+			Destination: &deviceTempRetryDelayFlag,
+			EnvVars:     []string{"DEVICE_TEMP_RETRY_DELAY"},
+		},
+		&cli.StringFlag{
+			Name:        "node-os-check",
+			Value:       "",
+			Usage:       "path to the os-release file of the node, cc mode changes are skipped if it names an OS cc-manager.sh does not support",
+			Destination: &nodeOSCheckFlag,
+			EnvVars:     []string{"NODE_OS_CHECK"},
+		},
+		&cli.BoolFlag{
+			Name:        "skip-os-check",
+			Value:       false,
+			Usage:       "disable --node-os-check, for custom distributions",
+			Destination: &skipOSCheckFlag,
+			EnvVars:     []string{"SKIP_OS_CHECK"},
+		},
+		&cli.IntFlag{
+			Name:        "success-rate-window",
+			Value:       100,
+			Usage:       "number of most recent cc mode changes the success rate is computed over, 0 disables success rate tracking",
+			Destination: &successRateWindowFlag,
+			EnvVars:     []string{"SUCCESS_RATE_WINDOW"},
+		},
+		&cli.Float64Flag{
+			Name:        "success-rate-alert-threshold",
+			Value:       0.95,
+			Usage:       "emit a Warning event when the cc mode change success rate drops below this ratio",
+			Destination: &successRateAlertThresholdFlag,
+			EnvVars:     []string{"SUCCESS_RATE_ALERT_THRESHOLD"},
+		},
+		&cli.BoolFlag{
+			Name:        "config-validation-only",
+			Value:       false,
+			Usage:       "validate the configuration, print a summary of it and exit without changing the cc mode",
+			Destination: &configValidationOnlyFlag,
+			EnvVars:     []string{"CONFIG_VALIDATION_ONLY"},
+		},
+		&cli.StringFlag{
+			Name:        "block-on-taint-key",
+			Value:       "",
+			Usage:       "defer cc mode changes while the node has a taint with this key",
+			Destination: &blockOnTaintKeyFlag,
+			EnvVars:     []string{"BLOCK_ON_TAINT_KEY"},
+		},
+		&cli.DurationFlag{
+			Name:        "taint-recheck-interval",
+			Value:       30 * time.Second,
+			Usage:       "interval at which the node taints are checked again while a cc mode change is deferred by --block-on-taint-key or --skip-modes-on-drain",
+			Destination: &taintRecheckIntervalFlag,
+			EnvVars:     []string{"TAINT_RECHECK_INTERVAL"},
+		},
+		&cli.BoolFlag{
+			Name:        "startup-self-test",
+			Value:       false,
+			Usage:       "check API server connectivity, cc-manager.sh and node annotation writes at startup and fail if any of them does not work",
+			Destination: &startupSelfTestFlag,
+			EnvVars:     []string{"STARTUP_SELF_TEST"},
+		},
+		&cli.StringFlag{
+			Name:        "node-anti-affinity-labels",
+			Value:       "",
+			Usage:       "label selector of pods that must not run on the same node, the daemon exits at startup if another matching pod is found",
+			Destination: &nodeAntiAffinityLabelsFlag,
+			EnvVars:     []string{"NODE_ANTI_AFFINITY_LABELS"},
+		},
+		&cli.StringFlag{
+			Name:        "mode-change-lock-annotation",
+			Value:       "",
+			Usage:       "node annotation used as a lock shared by all daemons changing the cc mode of the node, e.g. " + DefaultModeChangeLockAnnotation + ", empty disables locking",
+			Destination: &modeChangeLockAnnotationFlag,
+			EnvVars:     []string{"MODE_CHANGE_LOCK_ANNOTATION"},
+		},
+		&cli.DurationFlag{
+			Name:        "mode-change-lock-ttl",
+			Value:       10 * time.Minute,
+			Usage:       "duration after which a lock taken with --mode-change-lock-annotation and not released can be taken over",
+			Destination: &modeChangeLockTTLFlag,
+			EnvVars:     []string{"MODE_CHANGE_LOCK_TTL"},
+		},
+		&cli.StringFlag{
+			Name:        "audit-log-file",
+			Value:       "",
+			Usage:       "file every cc mode change is recorded in as a JSON line",
+			Destination: &auditLogFileFlag,
+			EnvVars:     []string{"AUDIT_LOG_FILE"},
+		},
+		&cli.BoolFlag{
+			Name:        "label-change-digest",
+			Value:       false,
+			Usage:       "chain the records of --audit-log-file with SHA256 digests, verify them with the verify-audit command",
+			Destination: &labelChangeDigestFlag,
+			EnvVars:     []string{"LABEL_CHANGE_DIGEST"},
+		},
+		&cli.BoolFlag{
+			Name:        "use-watch-list",
+			Aliases:     []string{"watch-list"},
+			Value:       false,
+			Usage:       "request initial events with every node watch, requires the WatchList feature gate on the API server (Kubernetes 1.27+)",
+			Destination: &useWatchListFlag,
+			EnvVars:     []string{"USE_WATCH_LIST"},
+		},
+		&cli.StringFlag{
+			Name:        "config-dir",
+			Value:       DefaultConfigDir,
+			Usage:       "directory with one file per flag, such as a mounted ConfigMap, default-cc-mode and cc-mode-template are reloaded when it changes",
+			Destination: &configDirFlag,
+			EnvVars:     []string{"CONFIG_DIR"},
+		},
+		&cli.BoolFlag{
+			Name:        "cc-mode-label-prefix-check",
+			Value:       true,
+			Usage:       "warn about configured label keys not in <dns-subdomain>/<name> format and node labels that look like a misspelled cc mode label",
+			Destination: &ccModeLabelPrefixCheckFlag,
+			EnvVars:     []string{"CC_MODE_LABEL_PREFIX_CHECK"},
+		},
+		&cli.StringFlag{
+			Name:        "kubernetes-burst-handling",
+			Value:       BurstHandlingQueue,
+			Usage:       "handling of Kubernetes API requests over the client rate limit, queue waits for the token bucket, drop fails them immediately",
+			Destination: &kubernetesBurstHandlingFlag,
+			EnvVars:     []string{"KUBERNETES_BURST_HANDLING"},
+		},
+		&cli.StringFlag{
+			Name:        "min-kubernetes-version",
+			Value:       DefaultMinKubernetesVersion,
+			Usage:       "log a warning on startup if the Kubernetes API server is older than this version",
+			Destination: &minKubernetesVersionFlag,
+			EnvVars:     []string{"MIN_KUBERNETES_VERSION"},
+		},
+		&cli.BoolFlag{
+			Name:        "skip-version-check",
+			Value:       false,
+			Usage:       "do not check the Kubernetes API server version against --min-kubernetes-version on startup",
+			Destination: &skipVersionCheckFlag,
+			EnvVars:     []string{"SKIP_VERSION_CHECK"},
+		},
+		&cli.BoolFlag{
+			Name:        "node-restart-detect",
+			Value:       false,
+			Usage:       "reapply the requested cc mode when the node Ready condition transitions to True, bypassing --label-change-backoff-window and --skip-unchanged-modes",
+			Destination: &nodeRestartDetectFlag,
+			EnvVars:     []string{"NODE_RESTART_DETECT"},
+		},
+		&cli.StringFlag{
+			Name:        "skip-modes-on-drain",
+			Value:       "",
+			Usage:       "comma-separated list of cc modes whose application is deferred while the node is cordoned, checked every --taint-recheck-interval",
+			Destination: &skipModesOnDrainFlag,
+			EnvVars:     []string{"SKIP_MODES_ON_DRAIN"},
+		},
+		&cli.IntFlag{
+			Name:        "max-impact-score",
+			Value:       0,
+			Usage:       "defer cc mode changes while the summed " + ImpactWeightAnnotation + " annotations of running pods, 1 per pod without it, exceed this score, 0 disables the check",
+			Destination: &maxImpactScoreFlag,
+			EnvVars:     []string{"MAX_IMPACT_SCORE"},
+		},
+		&cli.DurationFlag{
+			Name:        "impact-score-retry-delay",
+			Value:       60 * time.Second,
+			Usage:       "interval at which the impact score is computed again while a cc mode change is deferred by --max-impact-score",
+			Destination: &impactScoreRetryDelayFlag,
+			EnvVars:     []string{"IMPACT_SCORE_RETRY_DELAY"},
+		},
+		&cli.BoolFlag{
+			Name:        "legacy-mode",
+			Value:       false,
+			Usage:       "restore the behavior of the original implementation: no feature gates, node annotations, node conditions or other behavior enabled by default since, flags given explicitly still apply",
+			Destination: &legacyModeFlag,
+			EnvVars:     []string{"LEGACY_MODE"},
+		},
+		&cli.BoolFlag{
+			Name:        "cleanup-on-exit",
+			Value:       false,
+			Usage:       "remove the node annotations and conditions written by the daemon when it receives SIGTERM or SIGINT",
+			Destination: &cleanupOnExitFlag,
+			EnvVars:     []string{"CLEANUP_ON_EXIT"},
+		},
+		&cli.StringFlag{
+			Name:        "script-cgroup",
+			Value:       "",
+			Usage:       "cgroup v2 directory, such as /sys/fs/cgroup/gpu-management, cc-manager.sh is moved to once started, failures only log a warning",
+			Destination: &scriptCgroupFlag,
+			EnvVars:     []string{"SCRIPT_CGROUP"},
+		},
+		&cli.IntFlag{
+			Name:        "label-history-size",
+			Value:       50,
+			Usage:       "number of cc mode label values kept for the /debug/label-history endpoint of --http-addr, 0 disables the history",
+			Destination: &labelHistorySizeFlag,
+			EnvVars:     []string{"LABEL_HISTORY_SIZE"},
+		},
+		&cli.IntFlag{
+			Name:        "flapping-threshold",
+			Value:       5,
+			Usage:       "log a warning when the cc mode label changes more than this many times within --flapping-window",
+			Destination: &flappingThresholdFlag,
+			EnvVars:     []string{"FLAPPING_THRESHOLD"},
+		},
+		&cli.DurationFlag{
+			Name:        "flapping-window",
+			Value:       time.Minute,
+			Usage:       "window over which cc mode label changes are counted for --flapping-threshold",
+			Destination: &flappingWindowFlag,
+			EnvVars:     []string{"FLAPPING_WINDOW"},
+		},
+		&cli.StringFlag{
+			Name:        "script-output-encoding",
+			Value:       "utf8",
+			Usage:       "encoding (utf8, latin1 or windows-1252) of the cc-manager.sh output, which is transcoded to UTF-8 before being logged",
+			Destination: &scriptOutputEncodingFlag,
+			EnvVars:     []string{"SCRIPT_OUTPUT_ENCODING"},
+		},
+		&cli.StringFlag{
+			Name:        "kubernetes-retry-status-codes",
+			Value:       "429,500,502,503,504",
+			Usage:       "comma-separated list of HTTP status codes for which Kubernetes API requests are retried, empty disables the retries",
+			Destination: &kubernetesRetryStatusCodesFlag,
+			EnvVars:     []string{"KUBERNETES_RETRY_STATUS_CODES"},
+		},
+		&cli.DurationFlag{
+			Name:        "mode-verification-poll-interval",
+			Value:       0,
+			Usage:       "after setting a cc mode, poll cc-manager.sh get-cc-mode at this interval until the GPUs report it, 0 disables the verification",
+			Destination: &modeVerificationPollFlag,
+			EnvVars:     []string{"MODE_VERIFICATION_POLL_INTERVAL"},
+		},
+		&cli.DurationFlag{
+			Name:        "mode-verification-timeout",
+			Value:       5 * time.Minute,
+			Usage:       "time after which a cc mode change polled by --mode-verification-poll-interval fails",
+			Destination: &modeVerificationTimeoutFlag,
+			EnvVars:     []string{"MODE_VERIFICATION_TIMEOUT"},
+		},
+		&cli.BoolFlag{
+			Name:        "enable-pprof-mutex",
+			Value:       false,
+			Usage:       "enable mutex contention profiling, served under /debug/pprof/ on --http-addr, adds overhead to every contended lock",
+			Destination: &enablePprofMutexFlag,
+			EnvVars:     []string{"ENABLE_PPROF_MUTEX"},
+		},
+		&cli.BoolFlag{
+			Name:        "enable-pprof-block",
+			Value:       false,
+			Usage:       "enable goroutine block profiling, served under /debug/pprof/ on --http-addr, adds overhead to every blocking operation",
+			Destination: &enablePprofBlockFlag,
+			EnvVars:     []string{"ENABLE_PPROF_BLOCK"},
+		},
+		&cli.BoolFlag{
+			Name:        "cc-mode-capability-check",
+			Value:       false,
+			Usage:       "query the cc modes each GPU supports with cc-manager.sh list-modes on startup and reject cc modes a GPU does not support",
+			Destination: &ccModeCapabilityCheckFlag,
+			EnvVars:     []string{"CC_MODE_CAPABILITY_CHECK"},
+		},
+		&cli.StringFlag{
+			Name:        "json-patch-ops",
+			Value:       "",
+			Usage:       "JSON array of RFC 6902 operations applied to the node in the same patch as the applied cc mode annotations after each successful cc mode change",
+			Destination: &jsonPatchOpsFlag,
+			EnvVars:     []string{"JSON_PATCH_OPS"},
+		},
+		&cli.StringFlag{
+			Name:        "mode-label-source-annotation",
+			Value:       DefaultModeLabelSourceAnnotation,
+			Usage:       "node annotation in which controllers record their identity when setting the cc mode label, logged on every label change",
+			Destination: &modeLabelSourceAnnotationFlag,
+			EnvVars:     []string{"MODE_LABEL_SOURCE_ANNOTATION"},
+		},
+		&cli.StringFlag{
+			Name:        "untrusted-label-sources",
+			Value:       "",
+			Usage:       "comma separated list of label sources, as recorded in --mode-label-source-annotation, whose cc mode label values are ignored",
+			Destination: &untrustedLabelSourcesFlag,
+			EnvVars:     []string{"UNTRUSTED_LABEL_SOURCES"},
+		},
+		&cli.BoolFlag{
+			Name:        "force-label-source-acceptance",
+			Value:       false,
+			Usage:       "apply cc mode label values set by --untrusted-label-sources, still emitting a warning event",
+			Destination: &forceLabelSourceAcceptanceFlag,
+			EnvVars:     []string{"FORCE_LABEL_SOURCE_ACCEPTANCE"},
+		},
+		&cli.BoolFlag{
+			Name:        "self-monitor",
+			Value:       false,
+			Usage:       "watch the daemon's own pod, named by the POD_NAME and POD_NAMESPACE env, and record an interrupted cc mode change on the node once the pod is being deleted",
+			Destination: &selfMonitorFlag,
+			EnvVars:     []string{"SELF_MONITOR"},
+		},
+		&cli.DurationFlag{
+			Name:        "cc-mode-annotation-ttl",
+			Value:       0,
+			Usage:       "remove the nvidia.com/cc.mode.applied-at annotation once it is older than this duration, checked every --annotation-staleness-check-interval, 0 disables expiry",
+			Destination: &ccModeAnnotationTTLFlag,
+			EnvVars:     []string{"CC_MODE_ANNOTATION_TTL"},
+		},
+		&cli.BoolFlag{
+			Name:        "gpudirectrdma-compat-check",
+			Value:       false,
+			Usage:       "warn with a log message and event when cc mode on is applied to a node labelled nvidia.com/gpu-direct-rdma=enabled",
+			Destination: &gpuDirectRDMACompatCheckFlag,
+			EnvVars:     []string{"GPUDIRECTRDMA_COMPAT_CHECK"},
+		},
+		&cli.BoolFlag{
+			Name:        "allow-gpudirectrdma-with-cc-on",
+			Value:       false,
+			Usage:       "suppress the --gpudirectrdma-compat-check warning where GPUDirect RDMA with cc mode on was validated as safe",
+			Destination: &allowGPUDirectRDMAWithCCOnFlag,
+			EnvVars:     []string{"ALLOW_GPUDIRECTRDMA_WITH_CC_ON"},
+		},
+		&cli.StringFlag{
+			Name:        "device-filter",
+			Value:       "",
+			Usage:       "comma separated attribute=value pairs (numa_node, pci_domain, pci_bus, pci_device, pci_function) restricting CC_CAPABLE_DEVICE_IDS to the matching devices, e.g. numa_node=0,pci_bus=0x00",
+			Destination: &deviceFilterFlag,
+			EnvVars:     []string{"DEVICE_FILTER"},
+		},
+		&cli.StringFlag{
+			Name:        "wait-for-crds",
+			Value:       "",
+			Usage:       "comma separated list of CustomResourceDefinition names, e.g. ccmodepolicies.nvidia.com, to wait for before starting watches",
+			Destination: &waitForCRDsFlag,
+			EnvVars:     []string{"WAIT_FOR_CRDS"},
+		},
+		&cli.DurationFlag{
+			Name:        "startup-retry-interval",
+			Value:       5 * time.Second,
+			Usage:       "interval at which startup dependencies such as --wait-for-crds are checked again",
+			Destination: &startupRetryIntervalFlag,
+			EnvVars:     []string{"STARTUP_RETRY_INTERVAL"},
+		},
+		&cli.DurationFlag{
+			Name:        "startup-timeout",
+			Value:       5 * time.Minute,
+			Usage:       "time after which startup fails if it did not complete, e.g. because startup dependencies such as --wait-for-crds are still missing, 0 disables the limit unless --wait-for-crds is set",
+			Destination: &startupTimeoutFlag,
+			EnvVars:     []string{"STARTUP_TIMEOUT"},
+		},
+		&cli.BoolFlag{
+			Name:        "graceful-transition",
+			Value:       false,
+			Usage:       "wait for the GPUs to stop running compute processes before changing the cc mode",
+			Destination: &gracefulTransitionFlag,
+			EnvVars:     []string{"GRACEFUL_TRANSITION"},
+		},
+		&cli.DurationFlag{
+			Name:        "graceful-timeout",
+			Value:       5 * time.Minute,
+			Usage:       "time to wait for each GPU to become idle with --graceful-transition",
+			Destination: &gracefulTimeoutFlag,
+			EnvVars:     []string{"GRACEFUL_TIMEOUT"},
+		},
+		&cli.StringFlag{
+			Name:        "graceful-timeout-policy",
+			Value:       string(GracefulTimeoutProceed),
+			Usage:       "what to do when a GPU is still busy after --graceful-timeout: proceed with the cc mode change or abort it",
+			Destination: &gracefulTimeoutPolicyFlag,
+			EnvVars:     []string{"GRACEFUL_TIMEOUT_POLICY"},
+		},
+		&cli.StringFlag{
+			Name:        "coordination-group-label",
+			Value:       "",
+			Usage:       "node label, e.g. rack-id, whose value groups nodes of which only one changes its cc mode at a time, coordinated with a Lease (alpha, requires --feature-gates=CoordinatedModeChange=true)",
+			Destination: &coordinationGroupLabelFlag,
+			EnvVars:     []string{"COORDINATION_GROUP_LABEL"},
+		},
+		&cli.StringFlag{
+			Name:        "coordination-lease-namespace",
+			Value:       "",
+			Usage:       "namespace of the --coordination-group-label Leases, defaults to the POD_NAMESPACE env",
+			Destination: &coordinationLeaseNamespaceFlag,
+			EnvVars:     []string{"COORDINATION_LEASE_NAMESPACE"},
+		},
+		&cli.DurationFlag{
+			Name:        "coordination-lease-duration",
+			Value:       10 * time.Minute,
+			Usage:       "duration after which a --coordination-group-label Lease not released can be taken over by another node of the group",
+			Destination: &coordinationLeaseDurationFlag,
+			EnvVars:     []string{"COORDINATION_LEASE_DURATION"},
+		},
+		&cli.StringFlag{
+			Name:        "script-network-namespace",
+			Value:       "",
+			Usage:       "network namespace file, e.g. /proc/1/ns/net, cc-manager.sh is run in, the daemon's network namespace is used if it cannot be entered",
+			Destination: &scriptNetworkNamespaceFlag,
+			EnvVars:     []string{"SCRIPT_NETWORK_NAMESPACE"},
+		},
+		&cli.BoolFlag{
+			Name:        "watch-delete-prevents-apply",
+			Value:       false,
+			Usage:       "keep the current cc mode when the nvidia.com/cc.mode label is deleted or emptied instead of applying --default-cc-mode, the default still applies on startup",
+			Destination: &watchDeletePreventsApplyFlag,
+			EnvVars:     []string{"WATCH_DELETE_PREVENTS_APPLY"},
+		},
+		&cli.StringFlag{
+			Name:        "runtime-class-filter",
+			Value:       "",
+			Usage:       "comma separated list of RuntimeClass names, the cc mode is only changed while a pod on the node uses one of them",
+			Destination: &runtimeClassFilterFlag,
+			EnvVars:     []string{"RUNTIME_CLASS_FILTER"},
+		},
+		&cli.StringFlag{
+			Name:        "device-discovery-plugin",
+			Aliases:     []string{"cc-capable-ids-discovery-plugin"},
+			Value:       "env",
+			Usage:       "source of the CC capable device IDs: env reads CC_CAPABLE_DEVICE_IDS, file:<path> reads and watches a file, command:<command> runs a command every --device-discovery-interval",
+			Destination: &deviceDiscoveryPluginFlag,
+			EnvVars:     []string{"DEVICE_DISCOVERY_PLUGIN"},
+		},
+		&cli.DurationFlag{
+			Name:        "device-discovery-interval",
+			Value:       time.Minute,
+			Usage:       "interval at which the command of --device-discovery-plugin=command:<command> is run again",
+			Destination: &deviceDiscoveryIntervalFlag,
+			EnvVars:     []string{"DEVICE_DISCOVERY_INTERVAL"},
+		},
+		&cli.DurationFlag{
+			Name:        "device-health-check-interval",
+			Value:       5 * time.Minute,
+			Usage:       "interval at which every GPU is checked with 'cc-manager.sh device-health', unhealthy GPUs are excluded from CC mode changes, 0 disables the check",
+			Destination: &deviceHealthCheckIntervalFlag,
+			EnvVars:     []string{"DEVICE_HEALTH_CHECK_INTERVAL"},
+		},
+		&cli.BoolFlag{
+			Name:        "cc-mode-inventory",
+			Value:       false,
+			Usage:       "record the CC mode of the node in the cluster-inventory CCModeInventory, requires the ccmodeinventories.nvidia.com CRD (alpha, requires --feature-gates=CCModeInventory=true)",
+			Destination: &ccModeInventoryFlag,
+			EnvVars:     []string{"CC_MODE_INVENTORY"},
+		},
+		&cli.BoolFlag{
+			Name:        "enable-mode-change-tracing",
+			Value:       false,
+			Usage:       "record the time of every step of each cc mode change as a JSON line in --mode-change-trace-file (alpha, requires --feature-gates=ModeChangeTracing=true)",
+			Destination: &enableModeChangeTracingFlag,
+			EnvVars:     []string{"ENABLE_MODE_CHANGE_TRACING"},
+		},
+		&cli.StringFlag{
+			Name:        "mode-change-trace-file",
+			Value:       "",
+			Usage:       "file the traces of --enable-mode-change-tracing are appended to",
+			Destination: &modeChangeTraceFileFlag,
+			EnvVars:     []string{"MODE_CHANGE_TRACE_FILE"},
+		},
+		&cli.DurationFlag{
+			Name:        "forced-apply-interval",
+			Aliases:     []string{"cc-mode-forced-periodic-apply"},
+			Value:       0,
+			Usage:       "interval at which the requested CC mode is applied again even if nothing changed, 0 disables it (alpha, requires --feature-gates=ForcedPeriodicApply=true)",
+			Destination: &forcedApplyIntervalFlag,
+			EnvVars:     []string{"FORCED_APPLY_INTERVAL"},
+		},
+		&cli.StringFlag{
+			Name:        "resource-version-file",
+			Value:       "",
+			Usage:       "file the last seen resource version of the node is written to, the first node list after a restart resumes from it",
+			Destination: &resourceVersionFileFlag,
+			EnvVars:     []string{"RESOURCE_VERSION_FILE"},
+		},
+		&cli.BoolFlag{
+			Name:        "disable-script-shell-injection-protection",
+			Value:       false,
+			Usage:       "pass CC modes to cc-manager.sh and --cc-mode-change-precheck-cmd even if they contain characters other than letters, digits and _ -, and --script-args-template arguments and CC mode policy process names to cc-manager.sh even if they contain characters other than letters, digits and _ . , : = / + -",
+			Destination: &disableInjectionProtectionFlag,
+			EnvVars:     []string{"DISABLE_SCRIPT_SHELL_INJECTION_PROTECTION"},
+		},
+		&cli.StringFlag{
+			Name:        "label-value-regex",
+			Value:       DefaultLabelValueRegex,
+			Usage:       "regular expression CC mode label values are expected to match, values that do not are reported with a warning, empty disables the check",
+			Destination: &labelValueRegexFlag,
+			EnvVars:     []string{"LABEL_VALUE_REGEX"},
+		},
+		&cli.BoolFlag{
+			Name:        "enable-node-lease-heartbeat",
+			Value:       false,
+			Usage:       "renew a Lease named cc-manager-<node-name> in --namespace as a liveness signal of the daemon (alpha, requires --feature-gates=NodeLeaseHeartbeat=true)",
+			Destination: &enableNodeLeaseHeartbeatFlag,
+			EnvVars:     []string{"ENABLE_NODE_LEASE_HEARTBEAT"},
+		},
+		&cli.StringFlag{
+			Name:        "namespace",
+			Value:       "",
+			Usage:       "namespace of the --enable-node-lease-heartbeat Lease, defaults to the POD_NAMESPACE env",
+			Destination: &namespaceFlag,
+			EnvVars:     []string{"NAMESPACE"},
+		},
+		&cli.DurationFlag{
+			Name:        "lease-renewal-interval",
+			Value:       10 * time.Second,
+			Usage:       "interval at which the --enable-node-lease-heartbeat Lease is renewed",
+			Destination: &leaseRenewalIntervalFlag,
+			EnvVars:     []string{"LEASE_RENEWAL_INTERVAL"},
+		},
+		&cli.IntFlag{
+			Name:        "lease-failure-threshold",
+			Value:       3,
+			Usage:       "number of consecutive failures to renew the --enable-node-lease-heartbeat Lease after which the daemon exits",
+			Destination: &leaseFailureThresholdFlag,
+			EnvVars:     []string{"LEASE_FAILURE_THRESHOLD"},
+		},
+		&cli.StringFlag{
+			Name:        "script-stdout-log-level",
+			Value:       "debug",
+			Usage:       "log level of the lines cc-manager.sh writes to its standard output: trace, debug, info, warn or error",
+			Destination: &scriptStdoutLogLevelFlag,
+			EnvVars:     []string{"SCRIPT_STDOUT_LOG_LEVEL"},
+		},
+		&cli.StringFlag{
+			Name:        "script-stderr-log-level",
+			Value:       "warn",
+			Usage:       "log level of the lines cc-manager.sh writes to its standard error: trace, debug, info, warn or error",
+			Destination: &scriptStderrLogLevelFlag,
+			EnvVars:     []string{"SCRIPT_STDERR_LOG_LEVEL"},
+		},
+		&cli.StringFlag{
+			Name:        "kubernetes-impersonate-user",
+			Value:       "",
+			Usage:       "user name the daemon impersonates in all Kubernetes API calls",
+			Destination: &kubernetesImpersonateUserFlag,
+			EnvVars:     []string{"KUBERNETES_IMPERSONATE_USER"},
+		},
+		&cli.StringFlag{
+			Name:        "kubernetes-impersonate-uid",
+			Value:       "",
+			Usage:       "UUIDv4 of the user set with --kubernetes-impersonate-user, sent as the Impersonate-Uid header (Kubernetes 1.22+)",
+			Destination: &kubernetesImpersonateUIDFlag,
+			EnvVars:     []string{"KUBERNETES_IMPERSONATE_UID"},
+		},
+		&cli.StringFlag{
+			Name:        "kubernetes-warning-handler",
+			Value:       WarningHandlerLogrus,
+			Usage:       "where warnings of the Kubernetes API server, such as API deprecations, go: logrus logs them at warning level, klog keeps the client-go default, none drops them",
+			Destination: &kubernetesWarningHandlerFlag,
+			EnvVars:     []string{"KUBERNETES_WARNING_HANDLER"},
+		},
+		&cli.BoolFlag{
+			Name:        "parallel-node-discovery",
+			Value:       false,
+			Usage:       "run --device-discovery-plugin while the node is fetched at startup instead of before",
+			Destination: &parallelNodeDiscoveryFlag,
+			EnvVars:     []string{"PARALLEL_NODE_DISCOVERY"},
+		},
+		&cli.StringFlag{
+			Name:        "watch-reconnect-strategy",
+			Value:       WatchReconnectImmediate,
+			Usage:       "delay before re-establishing a node watch that failed: immediate, backoff from --watch-reconnect-initial-delay doubling up to --watch-reconnect-max-delay, or fixed --watch-reconnect-delay",
+			Destination: &watchReconnectStrategyFlag,
+			EnvVars:     []string{"WATCH_RECONNECT_STRATEGY"},
+		},
+		&cli.DurationFlag{
+			Name:        "watch-reconnect-initial-delay",
+			Value:       time.Second,
+			Usage:       "first delay of --watch-reconnect-strategy=backoff",
+			Destination: &watchReconnectInitialDelayFlag,
+			EnvVars:     []string{"WATCH_RECONNECT_INITIAL_DELAY"},
+		},
+		&cli.DurationFlag{
+			Name:        "watch-reconnect-max-delay",
+			Value:       time.Minute,
+			Usage:       "maximum delay of --watch-reconnect-strategy=backoff",
+			Destination: &watchReconnectMaxDelayFlag,
+			EnvVars:     []string{"WATCH_RECONNECT_MAX_DELAY"},
+		},
+		&cli.DurationFlag{
+			Name:        "watch-reconnect-delay",
+			Value:       5 * time.Second,
+			Usage:       "delay of --watch-reconnect-strategy=fixed",
+			Destination: &watchReconnectDelayFlag,
+			EnvVars:     []string{"WATCH_RECONNECT_DELAY"},
+		},
+		&cli.IntFlag{
+			Name:        "node-update-batch-size",
+			Value:       1,
+			Usage:       "maximum number of JSON patch operations sent in one node patch after a CC mode change, above 1 the applied annotations, --json-patch-ops and --node-annotations-to-labels labels are written together (alpha, requires --feature-gates=BatchedNodeUpdates=true)",
+			Destination: &nodeUpdateBatchSizeFlag,
+			EnvVars:     []string{"NODE_UPDATE_BATCH_SIZE"},
+		},
+		&cli.BoolFlag{
+			Name:        "enable-admission-controller",
+			Value:       false,
+			Usage:       "serve a validating admission controller rejecting invalid cc mode labels on the node, registered on startup with a self-signed certificate for the POD_IP env and deregistered on shutdown, meant for development environments (alpha, requires --feature-gates=AdmissionController=true)",
+			Destination: &enableAdmissionControllerFlag,
+			EnvVars:     []string{"ENABLE_ADMISSION_CONTROLLER"},
+		},
+		&cli.IntFlag{
+			Name:        "admission-controller-port",
+			Value:       9443,
+			Usage:       "port the --enable-admission-controller webhook is served on",
+			Destination: &admissionControllerPortFlag,
+			EnvVars:     []string{"ADMISSION_CONTROLLER_PORT"},
+		},
+		&cli.StringFlag{
+			Name:        "cc-mode-event-source-component",
+			Value:       EventSourceComponent,
+			Usage:       "source component of the Kubernetes events recorded by the daemon, e.g. to route the events of different instances differently",
+			Destination: &eventSourceComponentFlag,
+			EnvVars:     []string{"CC_MODE_EVENT_SOURCE_COMPONENT"},
+		},
+		&cli.StringFlag{
+			Name:        "daemon-id",
+			Value:       "",
+			Usage:       "identifier of this daemon instance, added to every log entry as daemon_id and used as the source host of Kubernetes events, defaults to a UUID generated at startup",
+			Destination: &daemonIDFlag,
+			EnvVars:     []string{"DAEMON_ID"},
+		},
+	}
+	return c
+}
+
+func validateFlagsOrEnv(c *cli.Context) error {
+	if c.Args().Present() && c.App.Command(c.Args().First()) != nil {
+		// subcommands validate their own arguments
+		return nil
+	}
+	return validateFlags(c)
+}
+
+// validateFlags validates the flags and env of the daemon and derives the
+// settings parsed from them.
+func validateFlags(c *cli.Context) error {
+	if legacyModeFlag {
+		if err := applyLegacyMode(c); err != nil {
+			return err
+		}
+	}
+	if configDirFlag != "" {
+		_, err := os.Stat(configDirFlag)
+		switch {
+		case os.IsNotExist(err) && !c.IsSet("config-dir"):
+		case err != nil:
+			return fmt.Errorf("invalid --config-dir: %s", err)
+		default:
+			settings, err := applyConfigDir(c, configDirFlag)
+			if err != nil {
+				return err
+			}
+			configDirSettings = settings
+		}
+	}
+	if os.Getenv("NODE_NAME") == "" {
+		return fmt.Errorf("NODE_NAME env must be set for k8s-cc-manager")
+	}
+	if deviceDiscoveryIntervalFlag <= 0 {
+		return fmt.Errorf("--device-discovery-interval must be a positive duration")
+	}
+	plugin, err := ParseDeviceDiscoveryPlugin(deviceDiscoveryPluginFlag, deviceDiscoveryIntervalFlag)
+	if err != nil {
+		return fmt.Errorf("invalid --device-discovery-plugin: %s", err)
+	}
+	deviceDiscovery = plugin
+	_, envPlugin := plugin.(*EnvVarPlugin)
+	if envPlugin && os.Getenv("CC_CAPABLE_DEVICE_IDS") == "" {
+		return fmt.Errorf("CC_CAPABLE_DEVICE_IDS env must be set for k8s-cc-manager")
+	}
+	if defaultCCModeFlag != "" {
+		if err := ValidateCCMode(defaultCCModeFlag); err != nil {
+			return fmt.Errorf("invalid --default-cc-mode: %s", err)
+		}
+	}
+	if deviceFilterFlag != "" {
+		filter, err := ParseDeviceFilter(deviceFilterFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --device-filter: %s", err)
+		}
+		deviceFilter = filter
+		if envPlugin {
+			if err := setCCCapableDeviceIDs(parseDeviceIDs(os.Getenv("CC_CAPABLE_DEVICE_IDS"))); err != nil {
+				return err
+			}
+		}
+	}
+	gates, err := ParseFeatureGates(featureGatesFlag)
+	if err != nil {
+		return fmt.Errorf("invalid --feature-gates: %s", err)
+	}
+	featureGates = gates
+	if err := featureGates.CheckFlags(c); err != nil {
+		return err
+	}
+	mappings, err := ParseAnnotationLabelMappings(nodeAnnotationsToLabelsFlag)
+	if err != nil {
+		return fmt.Errorf("invalid --node-annotations-to-labels: %s", err)
+	}
+	annotationLabelMappings = mappings
+	if modeRequirementsFileFlag != "" {
+		requirements, err := LoadModeRequirements(modeRequirementsFileFlag)
+		if err != nil {
+			return err
+		}
+		modeRequirements = requirements
+	}
+	format, err := ParseDeviceIDFormat(deviceIDFormatFlag)
+	if err != nil {
+		return fmt.Errorf("invalid --device-id-format: %s", err)
+	}
+	deviceIDFormat = format
+	strategy, err := ParseLabelPatchStrategy(labelPatchStrategyFlag)
+	if err != nil {
+		return fmt.Errorf("invalid --label-patch-strategy: %s", err)
+	}
+	labelPatchStrategy = strategy
+	tmpl, err := ParseScriptArgsTemplate(scriptArgsTemplateFlag)
+	if err != nil {
+		return fmt.Errorf("invalid --script-args-template: %s", err)
+	}
+	scriptArgsTemplate = tmpl
+	if ccModeTemplateFlag != "" {
+		tmpl, err := ParseCCModeTemplate(ccModeTemplateFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --cc-mode-template: %s", err)
+		}
+		ccModeTemplate = tmpl
+	}
+	if kubernetesProxyURLFlag != "" {
+		proxyURL, err := ParseKubernetesProxyURL(kubernetesProxyURLFlag, kubernetesProxyUsernameFlag, kubernetesProxyPasswordFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --kubernetes-proxy-url: %s", err)
+		}
+		kubernetesProxyURL = proxyURL
+	}
+	if scriptUserFlag != "" {
+		u, err := LookupScriptUser(scriptUserFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --script-user: %s", err)
+		}
+		scriptUser = u
+	}
+	if scriptGroupFlag != "" {
+		gid, err := LookupScriptGroup(scriptGroupFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --script-group: %s", err)
+		}
+		scriptGID = &gid
+	}
+	if err := ValidateNiceValue(scriptNiceValueFlag); err != nil {
+		return fmt.Errorf("invalid --script-nice-value: %s", err)
+	}
+	if scriptNetworkNamespaceFlag != "" {
+		if err := ValidateNetworkNamespace(scriptNetworkNamespaceFlag); err != nil {
+			return fmt.Errorf("invalid --script-network-namespace: %s", err)
+		}
+	}
+	if scriptCgroupFlag != "" {
+		if err := ValidateCgroup(scriptCgroupFlag); err != nil {
+			return fmt.Errorf("invalid --script-cgroup: %s", err)
+		}
+	}
+	if ccModeAnnotationTTLFlag < 0 {
+		return fmt.Errorf("--cc-mode-annotation-ttl must not be negative")
+	}
+	if (alertOnStaleAnnotationFlag > 0 || ccModeAnnotationTTLFlag > 0) && stalenessCheckIntervalFlag <= 0 {
+		return fmt.Errorf("--annotation-staleness-check-interval must be a positive duration")
+	}
+	if alertPolicyConfigMapFlag != "" {
+		namespace, name, err := ParseNamespacedName(alertPolicyConfigMapFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --alert-policy-configmap: %s", err)
+		}
+		alertPolicyNamespace, alertPolicyName = namespace, name
+	}
+	if transitionTableConfigMapFlag != "" {
+		namespace, name, err := ParseNamespacedName(transitionTableConfigMapFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --transition-table-configmap: %s", err)
+		}
+		transitionTableNamespace, transitionTableName = namespace, name
+	}
+	if nodeGroupConfigMapFlag != "" {
+		namespace, name, err := ParseNamespacedName(nodeGroupConfigMapFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --node-group-cc-mode-configmap: %s", err)
+		}
+		if nodeGroupLabelFlag == "" {
+			return fmt.Errorf("--node-group-label must be set with --node-group-cc-mode-configmap")
+		}
+		nodeGroupNamespace, nodeGroupName = namespace, name
+	}
+	if scriptEnvironmentSecretFlag != "" {
+		namespace, name, err := ParseNamespacedName(scriptEnvironmentSecretFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --script-environment-secret: %s", err)
+		}
+		scriptEnvironmentNamespace, scriptEnvironmentName = namespace, name
+	}
+	page, err := ParseStatusPageTemplate(statusPageTemplateFlag)
+	if err != nil {
+		return fmt.Errorf("invalid --status-page-template: %s", err)
+	}
+	statusPageTemplate = page
+	if (tlsCertFileFlag == "") != (tlsKeyFileFlag == "") {
+		return fmt.Errorf("--tls-cert-file and --tls-key-file must be set together")
+	}
+	if tlsClientCAFileFlag != "" && tlsCertFileFlag == "" {
+		return fmt.Errorf("--tls-client-ca-file requires --tls-cert-file and --tls-key-file")
+	}
+	if changeWindowFlag != "" {
+		loc, err := time.LoadLocation(changeWindowTimezoneFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --change-window-timezone: %s", err)
+		}
+		windows, err := ParseChangeWindows(changeWindowFlag, loc)
+		if err != nil {
+			return fmt.Errorf("invalid --change-window: %s", err)
+		}
+		changeWindows = windows
+	}
+	if watchTimeoutSecondsFlag < 0 {
+		return fmt.Errorf("--kubernetes-watch-timeout-seconds must not be negative")
+	}
+	if deviceTempMaxCelsiusFlag < 0 {
+		return fmt.Errorf("--device-temp-max-celsius must not be negative")
+	}
+	if deviceTempMaxCelsiusFlag > 0 && deviceTempRetryDelayFlag <= 0 {
+		return fmt.Errorf("--device-temp-retry-delay must be a positive duration")
+	}
+	if nodeOSCheckFlag != "" && !skipOSCheckFlag {
+		release, err := ReadOSRelease(nodeOSCheckFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --node-os-check: %s", err)
+		}
+		unsupportedOSError = CheckOSSupported(release)
+	}
+	if successRateWindowFlag < 0 {
+		return fmt.Errorf("--success-rate-window must not be negative")
+	}
+	if successRateAlertThresholdFlag < 0 || successRateAlertThresholdFlag > 1 {
+		return fmt.Errorf("--success-rate-alert-threshold must be between 0 and 1")
+	}
+	if blockOnTaintKeyFlag != "" && taintRecheckIntervalFlag <= 0 {
+		return fmt.Errorf("--taint-recheck-interval must be a positive duration")
+	}
+	if nodeAntiAffinityLabelsFlag != "" {
+		selector, err := labels.Parse(nodeAntiAffinityLabelsFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --node-anti-affinity-labels: %s", err)
+		}
+		antiAffinitySelector = selector
+	}
+	if modeChangeLockAnnotationFlag != "" {
+		if errs := validation.IsQualifiedName(modeChangeLockAnnotationFlag); len(errs) != 0 {
+			return fmt.Errorf("invalid --mode-change-lock-annotation: %s", strings.Join(errs, "; "))
+		}
+		if modeChangeLockTTLFlag <= 0 {
+			return fmt.Errorf("--mode-change-lock-ttl must be a positive duration")
+		}
+	}
+	if labelChangeDigestFlag && auditLogFileFlag == "" {
+		return fmt.Errorf("--label-change-digest requires --audit-log-file")
+	}
+	if enableModeChangeTracingFlag && modeChangeTraceFileFlag == "" {
+		return fmt.Errorf("--enable-mode-change-tracing requires --mode-change-trace-file")
+	}
+	if ccModeLabelPrefixCheckFlag && nodeGroupLabelFlag != "" {
+		if err := CheckLabelKeyFormat(nodeGroupLabelFlag); err != nil {
+			log.Warnf("Non-standard --node-group-label: %s", err)
+		}
+	}
+	if err := ValidateBurstHandling(kubernetesBurstHandlingFlag); err != nil {
+		return fmt.Errorf("invalid --kubernetes-burst-handling: %s", err)
+	}
+	if !skipVersionCheckFlag {
+		minVersion, err := version.ParseGeneric(minKubernetesVersionFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --min-kubernetes-version: %s", err)
+		}
+		minKubernetesVersion = minVersion
+	}
+	if skipModesOnDrainFlag != "" {
+		skipModesOnDrain = make(map[string]bool)
+		for _, mode := range strings.Split(skipModesOnDrainFlag, ",") {
+			mode = strings.TrimSpace(mode)
+			if err := ValidateCCMode(mode); err != nil {
+				return fmt.Errorf("invalid --skip-modes-on-drain: %s", err)
+			}
+			skipModesOnDrain[mode] = true
+		}
+		if taintRecheckIntervalFlag <= 0 {
+			return fmt.Errorf("--taint-recheck-interval must be a positive duration")
+		}
+	}
+	enc, err := ParseScriptOutputEncoding(scriptOutputEncodingFlag)
+	if err != nil {
+		return fmt.Errorf("invalid --script-output-encoding: %s", err)
+	}
+	scriptOutputEncoding = enc
+	codes, err := ParseRetryStatusCodes(kubernetesRetryStatusCodesFlag)
+	if err != nil {
+		return fmt.Errorf("invalid --kubernetes-retry-status-codes: %s", err)
+	}
+	kubernetesRetryStatusCodes = codes
+	if modeVerificationPollFlag < 0 {
+		return fmt.Errorf("--mode-verification-poll-interval must not be negative")
+	}
+	if modeVerificationPollFlag > 0 && modeVerificationTimeoutFlag < modeVerificationPollFlag {
+		return fmt.Errorf("--mode-verification-timeout must be at least --mode-verification-poll-interval")
+	}
+	if jsonPatchOpsFlag != "" {
+		ops, err := ParseJSONPatchOps(jsonPatchOpsFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --json-patch-ops: %s", err)
+		}
+		jsonPatchOps = ops
+	}
+	if untrustedLabelSourcesFlag != "" {
+		if modeLabelSourceAnnotationFlag == "" {
+			return fmt.Errorf("--untrusted-label-sources requires --mode-label-source-annotation")
+		}
+		untrustedLabelSources = make(map[string]bool)
+		for _, source := range strings.Split(untrustedLabelSourcesFlag, ",") {
+			source = strings.TrimSpace(source)
+			if source == "" {
+				return fmt.Errorf("invalid --untrusted-label-sources: empty source")
+			}
+			untrustedLabelSources[source] = true
+		}
+	}
+	if selfMonitorFlag && (os.Getenv("POD_NAME") == "" || os.Getenv("POD_NAMESPACE") == "") {
+		return fmt.Errorf("--self-monitor requires the POD_NAME and POD_NAMESPACE env")
+	}
+	if waitForCRDsFlag != "" {
+		for _, name := range strings.Split(waitForCRDsFlag, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				return fmt.Errorf("invalid --wait-for-crds: empty name")
+			}
+			waitForCRDNames = append(waitForCRDNames, name)
+		}
+		if startupRetryIntervalFlag <= 0 {
+			return fmt.Errorf("--startup-retry-interval must be a positive duration")
+		}
+		if startupTimeoutFlag <= 0 {
+			return fmt.Errorf("--startup-timeout must be a positive duration")
+		}
+	}
+	if startupTimeoutFlag < 0 {
+		return fmt.Errorf("--startup-timeout must not be negative")
+	}
+	if gracefulTransitionFlag {
+		policy, err := ParseGracefulTimeoutPolicy(gracefulTimeoutPolicyFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --graceful-timeout-policy: %s", err)
+		}
+		gracefulTimeoutPolicy = policy
+		if gracefulTimeoutFlag < time.Second {
+			return fmt.Errorf("--graceful-timeout must be at least 1s")
+		}
+	}
+	if coordinationGroupLabelFlag != "" {
+		if coordinationLeaseNamespaceFlag == "" {
+			coordinationLeaseNamespaceFlag = os.Getenv("POD_NAMESPACE")
+		}
+		if coordinationLeaseNamespaceFlag == "" {
+			return fmt.Errorf("--coordination-group-label requires --coordination-lease-namespace or the POD_NAMESPACE env")
+		}
+		if coordinationLeaseDurationFlag < time.Second {
+			return fmt.Errorf("--coordination-lease-duration must be at least 1s")
+		}
+	}
+	if runtimeClassFilterFlag != "" {
+		runtimeClassFilter = make(map[string]bool)
+		for _, name := range strings.Split(runtimeClassFilterFlag, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				return fmt.Errorf("invalid --runtime-class-filter: empty name")
+			}
+			runtimeClassFilter[name] = true
+		}
+	}
+	daemonIDFlag = strings.TrimSpace(daemonIDFlag)
+	if daemonIDFlag == "" {
+		daemonIDFlag = uuid.NewString()
+	}
+	if eventSourceComponentFlag == "" {
+		return fmt.Errorf("--cc-mode-event-source-component must not be empty")
+	}
+	if enableAdmissionControllerFlag {
+		if os.Getenv("POD_IP") == "" {
+			return fmt.Errorf("--enable-admission-controller requires the POD_IP env")
+		}
+		if admissionControllerPortFlag <= 0 || admissionControllerPortFlag > 65535 {
+			return fmt.Errorf("invalid --admission-controller-port %d", admissionControllerPortFlag)
+		}
+	}
+	if nodeUpdateBatchSizeFlag < 1 {
+		return fmt.Errorf("--node-update-batch-size must be at least 1")
+	}
+	if err := ValidateWatchReconnectStrategy(watchReconnectStrategyFlag); err != nil {
+		return fmt.Errorf("invalid --watch-reconnect-strategy: %s", err)
+	}
+	if watchReconnectStrategyFlag == WatchReconnectBackoff && (watchReconnectInitialDelayFlag <= 0 || watchReconnectMaxDelayFlag < watchReconnectInitialDelayFlag) {
+		return fmt.Errorf("--watch-reconnect-strategy=backoff requires a positive --watch-reconnect-initial-delay not greater than --watch-reconnect-max-delay")
+	}
+	if watchReconnectStrategyFlag == WatchReconnectFixed && watchReconnectDelayFlag <= 0 {
+		return fmt.Errorf("--watch-reconnect-strategy=fixed requires a positive --watch-reconnect-delay")
+	}
+	if err := ValidateWarningHandler(kubernetesWarningHandlerFlag); err != nil {
+		return fmt.Errorf("invalid --kubernetes-warning-handler: %s", err)
 	}
-
-	err := c.Run(os.Args)
+	if kubernetesImpersonateUIDFlag != "" {
+		if kubernetesImpersonateUserFlag == "" {
+			return fmt.Errorf("--kubernetes-impersonate-uid requires --kubernetes-impersonate-user")
+		}
+		if err := ValidateImpersonateUID(kubernetesImpersonateUIDFlag); err != nil {
+			return fmt.Errorf("invalid --kubernetes-impersonate-uid: %s", err)
+		}
+	}
+	if enableNodeLeaseHeartbeatFlag {
+		if namespaceFlag == "" {
+			namespaceFlag = os.Getenv("POD_NAMESPACE")
+		}
+		if namespaceFlag == "" {
+			return fmt.Errorf("--enable-node-lease-heartbeat requires --namespace or the POD_NAMESPACE env")
+		}
+		if leaseRenewalIntervalFlag < time.Second {
+			return fmt.Errorf("--lease-renewal-interval must be at least 1s")
+		}
+		if leaseFailureThresholdFlag <= 0 {
+			return fmt.Errorf("--lease-failure-threshold must be positive")
+		}
+	}
+	if forcedApplyIntervalFlag < 0 {
+		return fmt.Errorf("--forced-apply-interval must not be negative")
+	}
+	if deviceHealthCheckIntervalFlag < 0 {
+		return fmt.Errorf("--device-health-check-interval must not be negative")
+	}
+	backpressure, err := ParseBackpressureStrategy(backpressureStrategyFlag)
 	if err != nil {
-		log.SetOutput(os.Stderr)
-		log.Printf("Error: %v", err)
-		os.Exit(1)
+		return fmt.Errorf("invalid --backpressure-strategy: %s", err)
 	}
-}
-
-func validateFlagsOrEnv(c *cli.Context) error {
-	if os.Getenv("NODE_NAME") == "" {
-		return fmt.Errorf("NODE_NAME env must be set for k8s-cc-manager")
+	backpressureStrategy = backpressure
+	if scriptStdoutLogLevel, err = parseScriptLogLevel(scriptStdoutLogLevelFlag); err != nil {
+		return fmt.Errorf("invalid --script-stdout-log-level: %s", err)
 	}
-	if os.Getenv("CC_CAPABLE_DEVICE_IDS") == "" {
-		return fmt.Errorf("CC_CAPABLE_DEVICE_IDS env must be set for k8s-cc-manager")
+	if scriptStderrLogLevel, err = parseScriptLogLevel(scriptStderrLogLevelFlag); err != nil {
+		return fmt.Errorf("invalid --script-stderr-log-level: %s", err)
+	}
+	if labelValueRegexFlag != "" {
+		re, err := regexp.Compile(labelValueRegexFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --label-value-regex: %s", err)
+		}
+		labelValueRegexp = re
+	}
+	if labelHistorySizeFlag < 0 {
+		return fmt.Errorf("--label-history-size must not be negative")
+	}
+	if labelHistorySizeFlag > 0 && (flappingThresholdFlag <= 0 || flappingWindowFlag <= 0) {
+		return fmt.Errorf("--flapping-threshold and --flapping-window must be positive")
+	}
+	if maxImpactScoreFlag < 0 {
+		return fmt.Errorf("--max-impact-score must not be negative")
+	}
+	if maxImpactScoreFlag > 0 && impactScoreRetryDelayFlag <= 0 {
+		return fmt.Errorf("--impact-score-retry-delay must be a positive duration")
+	}
+	if watchErrorHandlerFlag != "" && watchFailureThresholdFlag <= 0 {
+		return fmt.Errorf("--watch-failure-threshold must be a positive value")
 	}
 	return nil
 }
 
 func start(c *cli.Context) error {
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigFlag)
+	if !legacyModeFlag {
+		log.AddHook(&daemonIDHook{id: daemonIDFlag})
+		log.Infof("Daemon ID: %s", daemonIDFlag)
+	}
+
+	// with --parallel-node-discovery, devices are discovered while the node
+	// is fetched
+	parallelDiscovery := parallelNodeDiscoveryFlag && !configValidationOnlyFlag
+	if !parallelDiscovery {
+		if err := discoverCCCapableDevices(context.Background(), deviceDiscovery); err != nil {
+			return err
+		}
+	}
+
+	if configValidationOnlyFlag {
+		return validateConfigOnly(os.Stdout)
+	}
+
+	log.Infof("Feature gates: %s", featureGates)
+	log.WithField("config", SanitizeConfig(c)).Debug("Starting with configuration")
+	enableContentionProfiling()
+
+	if disableInjectionProtectionFlag {
+		log.Warnf("*** --disable-script-shell-injection-protection is set: script arguments are passed without checking them for special characters ***")
+	}
+
+	watchdog := NewStartupWatchdog(startupTimeoutFlag)
+	watchdog.SetPhase("initializing the Kubernetes client")
+	config, err := buildKubernetesConfig()
 	if err != nil {
 		return fmt.Errorf("error building kubernetes clientcmd config: %s", err)
 	}
@@ -127,84 +1884,721 @@ func start(c *cli.Context) error {
 		return fmt.Errorf("error building kubernetes clientset from config: %s", err)
 	}
 
+	watchdog.SetPhase("checking the Kubernetes version")
+	if minKubernetesVersion != nil {
+		if err := checkKubernetesVersion(clientset, minKubernetesVersion); err != nil {
+			log.Warnf("Unable to check the Kubernetes server version: %s", err)
+		}
+	}
+
+	watchdog.SetPhase("waiting for CustomResourceDefinitions")
+	if len(waitForCRDNames) != 0 {
+		if err := waitForCRDs(clientset, waitForCRDNames, startupRetryIntervalFlag, startupTimeoutFlag); err != nil {
+			return err
+		}
+	}
+
+	watchdog.SetPhase("fetching the node")
 	// obtain CC mode label for the current node
-	node, err := clientset.CoreV1().Nodes().Get(context.Background(), os.Getenv("NODE_NAME"), metav1.GetOptions{})
-	if err != nil {
-		return fmt.Errorf("error obtaining node labels from config: %s", err)
+	var node *v1.Node
+	if parallelDiscovery {
+		node, err = fetchNodeAndDiscoverDevices(context.Background(), clientset, os.Getenv("NODE_NAME"), deviceDiscovery)
+		if err != nil {
+			return err
+		}
+	} else {
+		node, err = clientset.CoreV1().Nodes().Get(context.Background(), os.Getenv("NODE_NAME"), metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("error obtaining node labels from config: %s", err)
+		}
 	}
 
-	nodeLabels := node.GetLabels()
-	if value, ok := nodeLabels[CCModeConfigLabel]; !ok || value == "" {
-		// apply default CC mode config when per node nvidia.com/cc.mode label is not present or set to empty
-		if defaultCCModeFlag != "" {
-			log.Infof("Updating CC mode to : %s", defaultCCModeFlag)
-			err := runScript(defaultCCModeFlag)
+	if ccModeLabelPrefixCheckFlag {
+		warnMisspelledCCModeLabel(node.Labels)
+	}
+
+	if coordinationGroupLabelFlag != "" {
+		if group := node.Labels[coordinationGroupLabelFlag]; group != "" {
+			changeCoordinator = NewChangeCoordinator(clientset, coordinationLeaseNamespaceFlag, group, node.Name, coordinationLeaseDurationFlag)
+		} else {
+			log.Warnf("Node has no '%s' label, changing cc mode without coordination", coordinationGroupLabelFlag)
+		}
+	}
+
+	if cleanupOnExitFlag {
+		CleanupOnExit(clientset, os.Getenv("NODE_NAME"))
+	}
+
+	if antiAffinitySelector != nil {
+		conflicting, err := findConflictingPods(context.Background(), clientset, os.Getenv("NODE_NAME"), antiAffinitySelector)
+		if err != nil {
+			return err
+		}
+		if len(conflicting) != 0 {
+			log.Fatalf("Other pods matching '%s' are running on node %s: %s", antiAffinitySelector, os.Getenv("NODE_NAME"), strings.Join(conflicting, ", "))
+		}
+	}
+
+	watchdog.SetPhase("running startup checks")
+	if startupSelfTestFlag {
+		if err := runStartupSelfTest(context.Background(), clientset, os.Getenv("NODE_NAME")); err != nil {
+			return err
+		}
+	}
+
+	if ccModeCapabilityCheckFlag {
+		capabilities, err := LoadCCModeCapabilityMatrix()
+		if err != nil {
+			return fmt.Errorf("error loading cc mode capabilities: %s", err)
+		}
+		ccModeCapabilities = capabilities
+	}
+
+	events := NewNodeEventRecorder(clientset, os.Getenv("NODE_NAME"))
+	conditions := NewStatusConditionController(clientset, os.Getenv("NODE_NAME"))
+	defer events.Shutdown()
+
+	if modeChangeLockAnnotationFlag != "" {
+		modeChangeLock = NewNodeAnnotationLock(clientset, os.Getenv("NODE_NAME"), modeChangeLockAnnotationFlag, currentPodName(), modeChangeLockTTLFlag)
+	}
+
+	if deviceTempMaxCelsiusFlag > 0 {
+		temperatureGuard = NewDeviceTemperatureGuard(deviceTempMaxCelsiusFlag, deviceTempRetryDelayFlag, events)
+	}
+	if maxImpactScoreFlag > 0 {
+		impactScore = NewImpactScoreCalculator(clientset, os.Getenv("NODE_NAME"), maxImpactScoreFlag, impactScoreRetryDelayFlag, events)
+	}
+	if gpuDirectRDMACompatCheckFlag && !allowGPUDirectRDMAWithCCOnFlag {
+		gpuDirectRDMACompat = NewGPUDirectRDMACompatChecker(clientset, os.Getenv("NODE_NAME"), events)
+	}
+	if deviceHealthCheckIntervalFlag > 0 {
+		deviceHealth = NewDeviceHealthMap()
+		stopDeviceHealth := NewDeviceHealthMonitor(deviceHealth, deviceHealthCheckIntervalFlag, events, conditions).Run()
+		defer close(stopDeviceHealth)
+	}
+	if labelValueRegexp != nil {
+		labelSchema = NewLabelValueSchema(labelValueRegexp, events)
+	}
+	if ccModeInventoryFlag {
+		inventory = NewCCModeInventoryWriter(clientset, os.Getenv("NODE_NAME"))
+	}
+	if modeLabelSourceAnnotationFlag != "" {
+		labelSource = NewLabelSourceChecker(modeLabelSourceAnnotationFlag, untrustedLabelSources, forceLabelSourceAcceptanceFlag, events)
+	}
+
+	var successRate *ChangeSuccessRateTracker
+	if successRateWindowFlag > 0 {
+		successRate = NewChangeSuccessRateTracker(successRateWindowFlag, successRateAlertThresholdFlag, events)
+	}
+
+	var auditLog *AuditLog
+	if auditLogFileFlag != "" {
+		auditLog, err = OpenAuditLog(auditLogFileFlag, labelChangeDigestFlag)
+		if err != nil {
+			return err
+		}
+		defer auditLog.Close()
+	}
+
+	var traceLog *ModeChangeTraceLog
+	if enableModeChangeTracingFlag {
+		traceLog, err = OpenModeChangeTraceLog(modeChangeTraceFileFlag)
+		if err != nil {
+			return err
+		}
+		defer traceLog.Close()
+	}
+
+	if nodeEventFilterFlag {
+		stopEventLog := LogNodeEvents(clientset, os.Getenv("NODE_NAME"))
+		defer close(stopEventLog)
+	}
+
+	state := NewSyncableCCModeState()
+	patcher := NewNodeLabelPatcher(clientset, os.Getenv("NODE_NAME"), labelPatchStrategy)
+	annotations := NewNodeAnnotationCache(clientset, patcher, os.Getenv("NODE_NAME"), annotationCacheTTLFlag)
+
+	if enableNodeLeaseHeartbeatFlag {
+		heartbeat := NewNodeLeaseHeartbeat(clientset, namespaceFlag, os.Getenv("NODE_NAME"), currentPodName(), leaseRenewalIntervalFlag, leaseFailureThresholdFlag)
+		stopHeartbeat := heartbeat.Run()
+		defer close(stopHeartbeat)
+	}
+
+	if selfMonitorFlag {
+		monitor := NewDaemonSetSelfMonitor(clientset, os.Getenv("POD_NAMESPACE"), os.Getenv("POD_NAME"), os.Getenv("NODE_NAME"), state, annotations)
+		stopSelfMonitor := monitor.Run()
+		defer close(stopSelfMonitor)
+	}
+
+	watchdog.SetPhase("loading ConfigMaps and Secrets")
+	var alerter *CCModeAlerter
+	if alertPolicyName != "" {
+		policy, err := LoadCCModeAlertPolicy(context.Background(), clientset, alertPolicyNamespace, alertPolicyName)
+		if err != nil {
+			return fmt.Errorf("invalid --alert-policy-configmap: %s", err)
+		}
+		alerter = NewCCModeAlerter(policy)
+		stopAlertPolicy := WatchCCModeAlertPolicy(clientset, alertPolicyNamespace, alertPolicyName, alerter)
+		defer close(stopAlertPolicy)
+	}
+
+	if transitionTableName != "" {
+		table, err := LoadTransitionTable(context.Background(), clientset, transitionTableNamespace, transitionTableName)
+		if err != nil {
+			return fmt.Errorf("invalid --transition-table-configmap: %s", err)
+		}
+		transitions = NewCCModeStateMachine(table)
+		stopTransitionTable := WatchTransitionTable(clientset, transitionTableNamespace, transitionTableName, transitions)
+		defer close(stopTransitionTable)
+	}
+
+	if ccModeAnnotationTTLFlag > 0 {
+		stopExpiry := ExpireAppliedAtAnnotation(annotations, ccModeAnnotationTTLFlag, stalenessCheckIntervalFlag)
+		defer close(stopExpiry)
+	}
+	if alertOnStaleAnnotationFlag > 0 {
+		stopStaleness := WatchAnnotationStaleness(annotations, events, alertOnStaleAnnotationFlag, stalenessCheckIntervalFlag)
+		defer close(stopStaleness)
+	}
+
+	var labelHistory *NodeLabelHistory
+	if labelHistorySizeFlag > 0 {
+		labelHistory = NewNodeLabelHistory(labelHistorySizeFlag, flappingThresholdFlag, flappingWindowFlag)
+	}
+
+	if httpAddrFlag != "" {
+		informerSynced = &InformerSyncedChecker{}
+		lastScriptSuccess = &LastScriptSuccessChecker{}
+		healthz := NewAggregateHealthzChecker()
+		healthz.Add("informer-synced", informerSynced)
+		healthz.Add("last-script-success", lastScriptSuccess)
+		healthz.Add("kubernetes-connectivity", NewKubernetesConnectivityChecker(clientset))
+		healthz.Add("device-accessibility", &DeviceAccessibilityChecker{})
+
+		mux := http.NewServeMux()
+		mux.Handle("/healthz", healthz)
+		mux.Handle("/preview", NewCCModeChangePreviewer(clientset, os.Getenv("NODE_NAME"), state))
+		mux.Handle("/status", NewStatusHandler(state, statusPageTemplate))
+		if labelHistory != nil {
+			mux.Handle("/debug/label-history", labelHistory)
+		}
+		registerProfileHandlers(mux)
+		var tlsConfig *tls.Config
+		if tlsCertFileFlag != "" {
+			reloader, err := NewTLSReloader(tlsCertFileFlag, tlsKeyFileFlag, tlsClientCAFileFlag)
 			if err != nil {
-				log.Printf("Error: %v", err)
-				os.Exit(1)
+				return err
 			}
-			log.Infof("Successfuly updated to CC mode to %s", defaultCCModeFlag)
+			reloader.WatchSIGHUP()
+			defer reloader.Stop()
+			tlsConfig = reloader.TLSConfig()
+		}
+		server, err := startHTTPServer(httpAddrFlag, mux, tlsConfig)
+		if err != nil {
+			return err
+		}
+		defer server.Close()
+	}
+
+	if enableAdmissionControllerFlag {
+		admission, err := NewCCModeAdmissionController(clientset, node, os.Getenv("POD_IP"), admissionControllerPortFlag)
+		if err != nil {
+			return fmt.Errorf("error setting up the admission controller: %s", err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle(AdmissionControllerPath, admission)
+		server, err := startHTTPServer(fmt.Sprintf(":%d", admissionControllerPortFlag), mux, admission.TLSConfig())
+		if err != nil {
+			return err
+		}
+		defer server.Close()
+		if err := admission.Register(context.Background()); err != nil {
+			return err
+		}
+		defer func() {
+			if err := admission.Deregister(context.Background()); err != nil {
+				log.Warnf("Unable to deregister the admission controller: %s", err)
+			}
+		}()
+		log.Infof("Registered the cc mode admission controller on %s:%d", os.Getenv("POD_IP"), admissionControllerPortFlag)
+	}
+
+	if scriptEnvironmentName != "" {
+		err := LoadScriptEnvironment(context.Background(), clientset, scriptEnvironmentNamespace, scriptEnvironmentName, scriptEnvironment)
+		if err != nil {
+			return fmt.Errorf("invalid --script-environment-secret: %s", err)
+		}
+		stopScriptEnvironment := WatchScriptEnvironment(clientset, scriptEnvironmentNamespace, scriptEnvironmentName, scriptEnvironment)
+		defer close(stopScriptEnvironment)
+	}
+
+	nodeGroup := ""
+	if nodeGroupName != "" {
+		nodeGroup = node.Labels[nodeGroupLabelFlag]
+		if nodeGroup == "" {
+			log.Warnf("Node label '%s' is not set, not using the node group defaults of ConfigMap %s/%s", nodeGroupLabelFlag, nodeGroupNamespace, nodeGroupName)
+		} else if err := LoadNodeGroupDefault(context.Background(), clientset, nodeGroupNamespace, nodeGroupName, nodeGroup); err != nil {
+			return fmt.Errorf("invalid --node-group-cc-mode-configmap: %s", err)
 		}
 	}
 
 	ccModeConfig := NewSyncableCCModeConfig()
-	stop := ContinuouslySyncCCModeConfigChanges(clientset, ccModeConfig)
+	ccModeConfig.SetBackoffWindow(labelChangeBackoffWindowFlag)
+	ccModeConfig.SetMaxPending(maxPendingChangesFlag)
+	ccModeConfig.SetBackpressureStrategy(backpressureStrategy)
+
+	watchdog.SetPhase("syncing the pod cache")
+	var runtimeClasses *RuntimeClassFilter
+	if len(runtimeClassFilter) != 0 {
+		runtimeClasses = NewRuntimeClassFilter(clientset, os.Getenv("NODE_NAME"), runtimeClassFilter, ccModeConfig)
+		stopRuntimeClasses, err := runtimeClasses.Run()
+		if err != nil {
+			return err
+		}
+		defer close(stopRuntimeClasses)
+	}
+	// applying the default CC mode may take long, it is not part of startup
+	watchdog.Done()
+
+	if value := getCCModeConfig(node); value == "" {
+		if ccModeLabelRequiredFlag {
+			events.Eventf(v1.EventTypeWarning, "CCModeLabelMissing", "Node label %s is required but not set", CCModeConfigLabel)
+			return fmt.Errorf("node label '%s' is required but not set on node %s", CCModeConfigLabel, node.Name)
+		}
+		// apply default CC mode config when per node nvidia.com/cc.mode label is not present or set to empty
+		if defaultMode := getDefaultCCMode(); defaultMode != "" && runtimeClasses.Active() {
+			started := time.Now()
+			trace := traceLog.Start()
+			state.SetApplying(defaultMode)
+			_, err := applyCCModeConfig(defaultMode, trace)
+			state.SetApplying("")
+			if err != nil {
+				writeModeChangeTrace(traceLog, trace, defaultMode, err)
+				updateCCModeCondition(conditions, defaultMode, err)
+				return fmt.Errorf("error applying default CC mode %s: %w", defaultMode, err)
+			}
+			state.RecordChange(defaultMode, time.Since(started))
+			onCCModeChanged(patcher, annotations, defaultMode, trace)
+			writeModeChangeTrace(traceLog, trace, defaultMode, nil)
+			updateCCModeCondition(conditions, defaultMode, nil)
+		}
+	}
+
+	discoveryCtx, stopDiscovery := context.WithCancel(context.Background())
+	defer stopDiscovery()
+	if err := WatchDeviceDiscovery(discoveryCtx, deviceDiscovery, ccModeConfig); err != nil {
+		return err
+	}
+
+	if forcedApplyIntervalFlag > 0 {
+		stopForcedApply := RunForcedApply(forcedApplyIntervalFlag, ccModeConfig)
+		defer close(stopForcedApply)
+	}
+
+	var resourceVersions *NodeResourceVersionTracker
+	if resourceVersionFileFlag != "" {
+		resourceVersions, err = NewNodeResourceVersionTracker(resourceVersionFileFlag)
+		if err != nil {
+			return err
+		}
+	}
+
+	var watchFailures *WatchFailureTracker
+	if watchErrorHandlerFlag != "" {
+		watchFailures = NewWatchFailureTracker(watchErrorHandlerFlag, watchFailureThresholdFlag, os.Getenv("NODE_NAME"), state)
+	}
+	stop := ContinuouslySyncCCModeConfigChanges(clientset, ccModeConfig, watchFailures, labelHistory, resourceVersions)
 	defer close(stop)
 
+	if configDirSettings != nil {
+		reloader, err := NewSignalledConfigReloader(configDirFlag, configDirSettings, clientset, ccModeConfig)
+		if err != nil {
+			return err
+		}
+		go reloader.Run()
+		defer reloader.Stop()
+	}
+
+	if nodeGroup != "" {
+		stopNodeGroup := WatchNodeGroupDefault(clientset, nodeGroupNamespace, nodeGroupName, nodeGroup, ccModeConfig)
+		defer close(stopNodeGroup)
+	}
+
 	// now watch for node specific label
 	for {
 		log.Infof("Waiting for change to '%s' label", CCModeConfigLabel)
 		value := ccModeConfig.Get()
+		trace := traceLog.Start()
+		if len(changeWindows) != 0 {
+			// the daemon exits on SIGTERM or SIGINT while waiting, running
+			// the deferred cleanups
+			ctx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+			waited, err := WaitForChangeWindow(ctx, changeWindows, value)
+			stopSignals()
+			if errors.Is(err, context.Canceled) {
+				log.Infof("Received signal while waiting for the change window, exiting")
+				return nil
+			}
+			if err != nil {
+				log.Errorf("Not changing to '%s': %s", value, err)
+				continue
+			}
+			if waited {
+				// apply the most recent value requested while waiting
+				value = ccModeConfig.GetNow()
+			}
+		}
+		if blockOnTaintKeyFlag != "" && waitForTaintRemoval(clientset, os.Getenv("NODE_NAME"), blockOnTaintKeyFlag, taintRecheckIntervalFlag) {
+			value = ccModeConfig.GetNow()
+		}
+		if value == "" && watchDeletePreventsApplyFlag {
+			log.Infof("Label deleted, mode frozen at %s", state.Get().CurrentMode)
+			continue
+		}
 		if value == "" {
 			// assume CC mode as default mode provided when the node label is deleted or set to empty
-			value = defaultCCModeFlag
+			if ccModeLabelRequiredFlag {
+				log.Warnf("Required label '%s' was removed, falling back to default CC mode '%s'", CCModeConfigLabel, getDefaultCCMode())
+			}
+			value = getDefaultCCMode()
+		}
+		if !runtimeClasses.Active() {
+			log.Infof("Passive mode, not applying cc mode '%s' (see --runtime-class-filter)", value)
+			continue
+		}
+		if waitForUncordon(clientset, os.Getenv("NODE_NAME"), modeForDrainCheck(value), skipModesOnDrain, taintRecheckIntervalFlag) {
+			value = ccModeConfig.GetNow()
+			if value == "" && watchDeletePreventsApplyFlag {
+				log.Infof("Label deleted, mode frozen at %s", state.Get().CurrentMode)
+				continue
+			}
+			if value == "" {
+				value = getDefaultCCMode()
+			}
+		}
+		// policies are always applied, their process entries may have
+		// changed even if the default mode did not
+		reapply := ccModeConfig.TakeReapply()
+		if skipUnchangedModesFlag && !reapply && !isCCModePolicy(value) && value == state.Get().CurrentMode {
+			log.Infof("Mode unchanged, skipping script invocation")
+			continue
+		}
+		previous := state.Get().CurrentMode
+		started := time.Now()
+		state.SetApplying(value)
+		mode, err := applyCCModeConfig(value, trace)
+		state.SetApplying("")
+		duration := time.Since(started)
+		if err != nil && terminateOnScriptPermErrFlag && errors.Is(err, os.ErrPermission) {
+			// retrying will not fix a permission error, restart the pod instead
+			log.Fatalf("Permission error running cc-manager.sh: %s", err)
 		}
-		log.Infof("Updating CC mode to : %s", value)
-		err := runScript(value)
 		if err != nil {
 			log.Errorf("Error: %s", err)
-			continue
+		} else {
+			state.RecordChange(mode, duration)
+			onCCModeChanged(patcher, annotations, mode, trace)
+		}
+		writeModeChangeTrace(traceLog, trace, mode, err)
+		if alerter != nil {
+			alerter.Observe(conditions, events, mode, duration, err)
+		}
+		successRate.Record(err)
+		lastScriptSuccess.Record(err)
+		writeAuditRecord(auditLog, previous, mode, duration, err)
+		updateCCModeCondition(conditions, mode, err)
+	}
+}
+
+// applyCCModeConfig applies a value read from SyncableCCModeConfig, either a
+// plain CC mode or a CC mode policy, and returns the CC mode it resolved to.
+// The steps of the change are recorded in trace, which may be nil.
+func applyCCModeConfig(value string, trace *ModeChangeTrace) (string, error) {
+	trace.Mark(TraceValidationStart)
+	mode := value
+	var policy *CCModePolicy
+	if isCCModePolicy(value) {
+		p, err := ParseCCModePolicy(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid '%s' annotation: %s", CCModePolicyAnnotation, err)
+		}
+		policy = &p
+		mode = p.Default
+	} else if !legacyModeFlag {
+		// the original implementation passed any mode to cc-manager.sh
+		if err := ValidateCCMode(value); err != nil {
+			return value, err
+		}
+	}
+
+	skip, err := preflightCCModeChange(mode, policy)
+	trace.Mark(TraceValidationEnd)
+	if err != nil {
+		return mode, err
+	}
+	if skip {
+		return mode, nil
+	}
+
+	trace.Mark(TracePreHookStart)
+	// deferred first so that it runs once the lock and Lease are released
+	defer trace.Mark(TracePostHookEnd)
+	if modeChangeLock != nil {
+		if err := modeChangeLock.Acquire(context.Background()); err != nil {
+			return mode, err
+		}
+		defer func() {
+			if err := modeChangeLock.Release(context.Background()); err != nil {
+				log.Warnf("Unable to release CC mode change lock: %s", err)
+			}
+		}()
+	}
+
+	if changeCoordinator != nil {
+		if err := changeCoordinator.Acquire(context.Background()); err != nil {
+			return mode, err
 		}
-		log.Infof("Successfully updated to CC mode to %s", value)
+		defer func() {
+			if err := changeCoordinator.Release(context.Background()); err != nil {
+				log.Warnf("Unable to release coordination Lease: %s", err)
+			}
+		}()
+	}
+
+	trace.Mark(TracePreHookEnd)
+
+	if policy != nil {
+		log.Infof("Updating CC mode policy to : %s", value)
+		trace.Mark(TraceScriptStart)
+		err := runPolicyScript(*policy)
+		trace.Mark(TraceScriptEnd)
+		if err != nil {
+			return mode, err
+		}
+		log.Infof("Successfully updated CC mode policy with default mode %s", mode)
+		return mode, nil
+	}
+
+	log.Infof("Updating CC mode to : %s", value)
+	trace.Mark(TraceScriptStart)
+	err = runCCModeTransition(value)
+	trace.Mark(TraceScriptEnd)
+	if err != nil {
+		return value, err
 	}
+	log.Infof("Successfully updated to CC mode to %s", value)
+	return value, nil
 }
 
-func runScript(ccMode string) error {
-	args := []string{
-		"set-cc-mode",
-		"-a",
-		"-m", ccMode,
+// preflightCCModeChange runs the checks made before cc-manager.sh is invoked
+// to apply mode, or the default mode of policy if not nil. It returns true if
+// the change is not needed.
+func preflightCCModeChange(mode string, policy *CCModePolicy) (bool, error) {
+	if unsupportedOSError != nil {
+		// reported as an error rather than a skip so that the mode is not
+		// recorded as applied
+		log.Warnf("Skipping change to CC mode %s on unsupported OS (see --skip-os-check)", mode)
+		return false, unsupportedOSError
+	}
+	if precheckCmdFlag != "" {
+		correct, err := runPrecheck(context.Background(), mode, precheckCmdFlag)
+		if err != nil {
+			log.Warnf("Unable to run precheck, proceeding with CC mode change: %s", err)
+		} else if correct {
+			log.Infof("precheck says mode already correct, skipping change to CC mode %s", mode)
+			return true, nil
+		}
 	}
-	cmd := exec.Command("/usr/bin/cc-manager.sh", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	// the transition table is checked against the queried CC mode once the
+	// change is made
+	if err := checkCCModeChange("", mode, policy); err != nil {
+		return false, err
+	}
+	if err := temperatureGuard.Wait(mode); err != nil {
+		return false, err
+	}
+	if err := impactScore.Wait(mode); err != nil {
+		return false, err
+	}
+	gpuDirectRDMACompat.Check(mode)
+	return false, nil
 }
 
-func ContinuouslySyncCCModeConfigChanges(clientset *kubernetes.Clientset, ccModeConfig *SyncableCCModeConfig) chan struct{} {
-	listWatch := cache.NewListWatchFromClient(
-		clientset.CoreV1().RESTClient(),
-		ResourceNodes,
-		v1.NamespaceAll,
-		fields.OneTermEqualSelector("metadata.name", os.Getenv("NODE_NAME")),
-	)
+// checkCCModeChange runs the checks of a change from current to mode, or to
+// the default mode of policy if not nil, that neither wait nor modify
+// anything: the capability matrix, the transition table and the mode
+// requirements. It is shared by preflightCCModeChange and the preview so that
+// both reject the same changes. An empty current mode allows every
+// transition.
+func checkCCModeChange(current, mode string, policy *CCModePolicy) error {
+	if policy != nil {
+		if err := ccModeCapabilities.CheckPolicy(*policy); err != nil {
+			return err
+		}
+	} else {
+		if err := ccModeCapabilities.Check(mode); err != nil {
+			return err
+		}
+		// policies are applied by cc-manager.sh without intermediate modes
+		if _, err := transitions.Steps(current, mode); err != nil {
+			return err
+		}
+	}
+	return checkModeRequirements(mode)
+}
+
+// ccModeChangeDeferrals returns why the main loop would currently defer a CC
+// mode change on node: --change-window and --block-on-taint-key.
+func ccModeChangeDeferrals(node *v1.Node, now time.Time) []string {
+	var reasons []string
+	if len(changeWindows) != 0 && !IsInChangeWindow(now, changeWindows) {
+		if opens := NextChangeWindow(now, changeWindows); opens.IsZero() {
+			reasons = append(reasons, fmt.Sprintf("outside of the change window, none opens within %s", changeWindowSearchLimit))
+		} else {
+			reasons = append(reasons, fmt.Sprintf("outside of the change window until %s", opens))
+		}
+	}
+	if blockOnTaintKeyFlag != "" && IsBlockedByTaint(node, blockOnTaintKeyFlag) {
+		reasons = append(reasons, fmt.Sprintf("node has taint '%s'", blockOnTaintKeyFlag))
+	}
+	return reasons
+}
+
+// onCCModeChanged runs the follow-up actions of a successful CC mode change.
+// It is a no-op with --legacy-mode.
+func onCCModeChanged(patcher *NodeLabelPatcher, annotations *NodeAnnotationCache, mode string, trace *ModeChangeTrace) {
+	if legacyModeFlag {
+		return
+	}
+	if nodeUpdateBatchSizeFlag > 1 {
+		if err := writeCCModeChangeBatch(context.Background(), patcher, annotations, mode); err != nil {
+			log.Warnf("Unable to record applied CC mode on the node: %s", err)
+		} else {
+			trace.Mark(TraceAnnotationWritten)
+		}
+	} else {
+		err := writeAppliedAnnotations(context.Background(), annotations, mode)
+		if err != nil {
+			log.Warnf("Unable to record applied CC mode on the node: %s", err)
+		} else {
+			trace.Mark(TraceAnnotationWritten)
+		}
+		err = copyAnnotationsToLabels(context.Background(), patcher, annotations, annotationLabelMappings)
+		if err != nil {
+			log.Warnf("Unable to copy node annotations to labels: %s", err)
+		}
+	}
+	if err := inventory.Record(context.Background(), mode); err != nil {
+		log.Warnf("Unable to record CC mode in the inventory: %s", err)
+	}
+}
+
+// writeModeChangeTrace writes the trace of a CC mode change, if any.
+func writeModeChangeTrace(traceLog *ModeChangeTraceLog, trace *ModeChangeTrace, mode string, err error) {
+	if err := traceLog.Write(trace, mode, err); err != nil {
+		log.Warnf("Unable to write mode change trace: %s", err)
+	}
+}
+
+// writeAuditRecord records a CC mode change in the audit log, if any.
+func writeAuditRecord(auditLog *AuditLog, previous, mode string, duration time.Duration, err error) {
+	record := CCModeChangeRecord{
+		Time:         time.Now(),
+		Node:         os.Getenv("NODE_NAME"),
+		Mode:         mode,
+		PreviousMode: previous,
+		Duration:     duration,
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if err := auditLog.Write(record); err != nil {
+		log.Warnf("Unable to write audit record: %s", err)
+	}
+}
+
+// updateCCModeCondition reflects the outcome of a CC mode change in the
+// NVIDIACCModeReady, NVIDIACCModeScriptHealthy and
+// NVIDIACCModeDevicesAvailable node conditions and writes all pending node
+// conditions. It is a no-op with --legacy-mode.
+func updateCCModeCondition(conditions *StatusConditionController, mode string, err error) {
+	if legacyModeFlag {
+		return
+	}
+	if err != nil {
+		conditions.SetCondition(CCModeReadyCondition, v1.ConditionFalse, "CCModeChangeFailed", err.Error())
+	} else {
+		conditions.SetCondition(CCModeReadyCondition, v1.ConditionTrue, "CCModeApplied", fmt.Sprintf("CC mode set to %s", mode))
+	}
+	setScriptHealthyCondition(conditions, err)
+	setDevicesAvailableCondition(conditions, deviceHealth)
+	if err := conditions.Sync(context.Background()); err != nil {
+		log.Warnf("Unable to update node conditions: %s", err)
+	}
+}
+
+// nodeBecameReady reports whether the Ready condition of the node transitioned
+// to True from False or Unknown, as it does when the node recovers from a
+// reboot.
+func nodeBecameReady(oldNode, newNode *v1.Node) bool {
+	oldReady := nodeReadyCondition(oldNode)
+	newReady := nodeReadyCondition(newNode)
+	if newReady == nil || newReady.Status != v1.ConditionTrue {
+		return false
+	}
+	if oldReady == nil {
+		return false
+	}
+	return oldReady.Status != v1.ConditionTrue || !oldReady.LastTransitionTime.Equal(&newReady.LastTransitionTime)
+}
+
+func nodeReadyCondition(node *v1.Node) *v1.NodeCondition {
+	for i := range node.Status.Conditions {
+		if node.Status.Conditions[i].Type == v1.NodeReady {
+			return &node.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+func ContinuouslySyncCCModeConfigChanges(clientset *kubernetes.Clientset, ccModeConfig *SyncableCCModeConfig, watchFailures *WatchFailureTracker, history *NodeLabelHistory, resourceVersions *NodeResourceVersionTracker) chan struct{} {
+	listWatch := NewNodeListWatch(clientset, watchFailures, resourceVersions)
 
 	_, controller := cache.NewInformer(
 		listWatch, &v1.Node{}, 0,
-		cache.ResourceEventHandlerFuncs{
+		PanicRecoveryMiddleware("node", cache.ResourceEventHandlerFuncs{
 			AddFunc: func(obj interface{}) {
-				ccModeConfig.Set(obj.(*v1.Node).Labels[CCModeConfigLabel])
+				node := obj.(*v1.Node)
+				resourceVersions.Record(node.ResourceVersion)
+				if !labelSource.Accept(node) {
+					return
+				}
+				config := getCCModeConfig(node)
+				labelSchema.Check(config)
+				history.Record(config, node.ResourceVersion)
+				ccModeConfig.Set(config)
 			},
 			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldLabel := oldObj.(*v1.Node).Labels[CCModeConfigLabel]
-				newLabel := newObj.(*v1.Node).Labels[CCModeConfigLabel]
-				if oldLabel != newLabel {
-					ccModeConfig.Set(newLabel)
+				resourceVersions.Record(newObj.(*v1.Node).ResourceVersion)
+				oldConfig := getCCModeConfig(oldObj.(*v1.Node))
+				newConfig := getCCModeConfig(newObj.(*v1.Node))
+				if oldConfig != newConfig {
+					if !labelSource.Accept(newObj.(*v1.Node)) {
+						return
+					}
+					labelSchema.Check(newConfig)
+					history.Record(newConfig, newObj.(*v1.Node).ResourceVersion)
+					ccModeConfig.Set(newConfig)
+				} else if nodeRestartDetectFlag && nodeBecameReady(oldObj.(*v1.Node), newObj.(*v1.Node)) {
+					log.Infof("Node became Ready, the GPU firmware state may have been reset, reapplying cc mode")
+					ccModeConfig.Reapply()
 				}
 			},
-		},
+		}),
 	)
 
+	informerSynced.SetInformer(controller.HasSynced)
+
 	stop := make(chan struct{})
 	go controller.Run(stop)
 	return stop
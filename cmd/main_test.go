@@ -0,0 +1,199 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	cli "github.com/urfave/cli/v2"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// checkGoroutines fails the test unless the number of goroutines drops back to
+// before within a few seconds. goleak is not vendored, so this only catches
+// leaks, not which goroutine leaked.
+func checkGoroutines(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("%d goroutines leaked:\n%s", runtime.NumGoroutine()-before, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestContinuouslySyncCCModeConfigChangesStops(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	watching := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/nodes" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("watch") != "true" {
+			_, _ = w.Write([]byte(`{"kind":"NodeList","apiVersion":"v1","metadata":{"resourceVersion":"1"},"items":[]}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		select {
+		case watching <- struct{}{}:
+		default:
+		}
+		<-r.Context().Done()
+	}))
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := ContinuouslySyncCCModeConfigChanges(clientset, NewSyncableCCModeConfig(), nil, nil, nil)
+	select {
+	case <-watching:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the informer did not start watching the node")
+	}
+	close(stop)
+
+	// the informer cancels its watch once stopped, closing the connections
+	// only ends the keep-alive goroutines of the HTTP client and server
+	server.CloseClientConnections()
+	server.Close()
+	checkGoroutines(t, before)
+}
+
+func TestSyncableCCModeConfigGetReturns(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	config := NewSyncableCCModeConfig()
+	got := make(chan string)
+	go func() {
+		got <- config.Get()
+	}()
+	config.Set(CCModeOn)
+	select {
+	case mode := <-got:
+		if mode != CCModeOn {
+			t.Errorf("expected %s, got %s", CCModeOn, mode)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Get did not return after Set")
+	}
+	checkGoroutines(t, before)
+}
+
+// legacyModeAllowlist lists the flags whose default is not the zero value
+// but is kept with --legacy-mode, with the reason. All but the script log
+// levels keep the behavior of the original implementation.
+var legacyModeAllowlist = map[string]string{
+	"backpressure-strategy":               "only used with --max-pending-changes",
+	"script-args-template":                "renders the original cc-manager.sh arguments",
+	"annotation-cache-ttl":                "node annotations are not written with --legacy-mode",
+	"watch-failure-threshold":             "only used with --watch-error-handler",
+	"annotation-staleness-check-interval": "only used with --alert-on-stale-annotation",
+	"label-patch-strategy":                "node annotations are not written with --legacy-mode",
+	"change-window-timezone":              "only used with --change-window",
+	"device-temp-retry-delay":             "only used with --device-temp-max-celsius",
+	"success-rate-alert-threshold":        "only used with --success-rate-window",
+	"taint-recheck-interval":              "only used with --block-on-taint-key",
+	"mode-change-lock-ttl":                "only used with --mode-change-lock-annotation",
+	"kubernetes-burst-handling":           "queue is how client-go always handled its rate limit",
+	"min-kubernetes-version":              "--legacy-mode sets --skip-version-check",
+	"impact-score-retry-delay":            "only used with --max-impact-score",
+	"flapping-threshold":                  "--legacy-mode sets --label-history-size to 0",
+	"flapping-window":                     "--legacy-mode sets --label-history-size to 0",
+	"script-output-encoding":              "utf8 logs the output unchanged",
+	"mode-verification-timeout":           "only used with --mode-verification-poll-interval",
+	"startup-retry-interval":              "--legacy-mode sets --startup-timeout to 0",
+	"graceful-timeout":                    "only used with --graceful-transition",
+	"graceful-timeout-policy":             "only used with --graceful-transition",
+	"coordination-lease-duration":         "only used with --coordination-group-label",
+	"device-discovery-plugin":             "env reads CC_CAPABLE_DEVICE_IDS as always",
+	"device-discovery-interval":           "only used with --device-discovery-plugin=command:",
+	"lease-renewal-interval":              "only used with --enable-node-lease-heartbeat",
+	"lease-failure-threshold":             "only used with --enable-node-lease-heartbeat",
+	"watch-reconnect-strategy":            "immediate is how the informer always reconnected",
+	"watch-reconnect-initial-delay":       "only used with --watch-reconnect-strategy=backoff",
+	"watch-reconnect-max-delay":           "only used with --watch-reconnect-strategy=backoff",
+	"watch-reconnect-delay":               "only used with --watch-reconnect-strategy=fixed",
+	"node-update-batch-size":              "node annotations are not written with --legacy-mode",
+	"admission-controller-port":           "only used with --enable-admission-controller",
+	"cc-mode-event-source-component":      "events are not recorded with --legacy-mode",
+	"script-stdout-log-level":             "cc-manager.sh output is logged line by line in every mode",
+	"script-stderr-log-level":             "cc-manager.sh output is logged line by line in every mode",
+}
+
+// flagHasDefault reports whether flag defaults to something else than the
+// zero value, which is what the two flags of the original implementation
+// defaulted to.
+func flagHasDefault(t *testing.T, flag cli.Flag) bool {
+	switch f := flag.(type) {
+	case *cli.StringFlag:
+		return f.Value != ""
+	case *cli.BoolFlag:
+		return f.Value
+	case *cli.IntFlag:
+		return f.Value != 0
+	case *cli.Int64Flag:
+		return f.Value != 0
+	case *cli.Float64Flag:
+		return f.Value != 0
+	case *cli.DurationFlag:
+		return f.Value != 0
+	}
+	t.Errorf("--%s: unknown flag type %T", flag.Names()[0], flag)
+	return false
+}
+
+// TestLegacyModeDefaults checks that every flag with a default either is
+// turned off by --legacy-mode or is known to keep the original behavior.
+func TestLegacyModeDefaults(t *testing.T) {
+	legacy := make(map[string]bool)
+	for _, setting := range legacyModeDefaults {
+		legacy[setting[0]] = true
+	}
+	flags := make(map[string]bool)
+	for _, flag := range newApp().Flags {
+		name := flag.Names()[0]
+		flags[name] = true
+		if !flagHasDefault(t, flag) || legacy[name] {
+			continue
+		}
+		if _, ok := legacyModeAllowlist[name]; !ok {
+			t.Errorf("--%s has a default, add it to legacyModeDefaults or to legacyModeAllowlist", name)
+		}
+	}
+	for name := range legacy {
+		if !flags[name] {
+			t.Errorf("legacyModeDefaults lists unknown flag --%s", name)
+		}
+	}
+	for name := range legacyModeAllowlist {
+		if !flags[name] {
+			t.Errorf("legacyModeAllowlist lists unknown flag --%s", name)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	cli "github.com/urfave/cli/v2"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+// migrateLabelsFlags are the flags of the migrate-labels subcommand.
+var migrateLabelsFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:     "from-label-key",
+		Usage:    "node label holding the legacy configuration, removed once migrated",
+		Required: true,
+	},
+	&cli.StringFlag{
+		Name:     "to-annotation-key",
+		Usage:    "node annotation the value of --from-label-key is written to",
+		Required: true,
+	},
+	&cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "print the changes without patching the nodes",
+	},
+}
+
+// migrateLabelsCommand moves the value of a label to an annotation on every
+// node of the cluster, removing the label, in a single merge patch per node.
+// Nodes without the label, either already migrated or never labelled, are
+// skipped.
+func migrateLabelsCommand(c *cli.Context) error {
+	from := c.String("from-label-key")
+	to := c.String("to-annotation-key")
+	dryRun := c.Bool("dry-run")
+	if errs := validation.IsQualifiedName(from); len(errs) != 0 {
+		return fmt.Errorf("invalid --from-label-key: %s", strings.Join(errs, "; "))
+	}
+	if errs := validation.IsQualifiedName(to); len(errs) != 0 {
+		return fmt.Errorf("invalid --to-annotation-key: %s", strings.Join(errs, "; "))
+	}
+
+	config, err := buildKubernetesConfig()
+	if err != nil {
+		return fmt.Errorf("error building kubernetes clientcmd config: %s", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("error building kubernetes clientset from config: %s", err)
+	}
+
+	nodes, err := clientset.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing nodes: %s", err)
+	}
+
+	var migrated, skipped, failed int
+	for _, node := range nodes.Items {
+		value, ok := node.Labels[from]
+		if !ok {
+			skipped++
+			continue
+		}
+		if dryRun {
+			fmt.Printf("%s: would move label %s=%s to annotation %s\n", node.Name, from, value, to)
+			migrated++
+			continue
+		}
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"labels":      map[string]interface{}{from: nil},
+				"annotations": map[string]interface{}{to: value},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("error encoding node patch: %s", err)
+		}
+		_, err = clientset.CoreV1().Nodes().Patch(context.Background(), node.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil {
+			log.Errorf("Error migrating node %s: %s", node.Name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s: moved label %s=%s to annotation %s\n", node.Name, from, value, to)
+		migrated++
+	}
+
+	verb := "migrated"
+	if dryRun {
+		verb = "to migrate"
+	}
+	fmt.Printf("%d %s, %d skipped (already migrated or not labelled), %d errors\n", migrated, verb, skipped, failed)
+	if failed != 0 {
+		return fmt.Errorf("failed to migrate %d nodes", failed)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Steps of the CC mode change pipeline recorded in a ModeChangeTrace.
+const (
+	TraceLabelReceived     = "label_received"
+	TraceValidationStart   = "validation_start"
+	TraceValidationEnd     = "validation_end"
+	TracePreHookStart      = "pre_hook_start"
+	TracePreHookEnd        = "pre_hook_end"
+	TraceScriptStart       = "script_start"
+	TraceScriptEnd         = "script_end"
+	TracePostHookEnd       = "post_hook_end"
+	TraceAnnotationWritten = "annotation_written"
+)
+
+// ModeChangeTrace records when each step of a CC mode change was reached.
+// The pre and post hooks are the acquisition and release of the mode change
+// lock and coordination Lease. Steps that were not reached, e.g. because
+// validation failed, are missing.
+type ModeChangeTrace struct {
+	Node  string                `json:"node"`
+	Mode  string                `json:"mode"`
+	Steps []ModeChangeTraceStep `json:"steps"`
+	Error string                `json:"error,omitempty"`
+}
+
+type ModeChangeTraceStep struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+}
+
+// Mark records that step was reached now. It is a no-op on a nil trace.
+func (t *ModeChangeTrace) Mark(step string) {
+	if t == nil {
+		return
+	}
+	t.Steps = append(t.Steps, ModeChangeTraceStep{Name: step, Time: time.Now().UTC()})
+}
+
+// ModeChangeTraceLog appends ModeChangeTraces as JSON lines to the file set
+// with --mode-change-trace-file.
+type ModeChangeTraceLog struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func OpenModeChangeTraceLog(path string) (*ModeChangeTraceLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening mode change trace file: %s", err)
+	}
+	return &ModeChangeTraceLog{file: file}, nil
+}
+
+// Start returns a new trace of a CC mode change whose label was just
+// received. It returns nil on a nil log so that tracing costs nothing when
+// disabled.
+func (l *ModeChangeTraceLog) Start() *ModeChangeTrace {
+	if l == nil {
+		return nil
+	}
+	trace := &ModeChangeTrace{Node: os.Getenv("NODE_NAME")}
+	trace.Mark(TraceLabelReceived)
+	return trace
+}
+
+// Write appends trace to the log with the CC mode it applied and the outcome
+// of the change. It is a no-op on a nil log.
+func (l *ModeChangeTraceLog) Write(trace *ModeChangeTrace, mode string, err error) error {
+	if l == nil || trace == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	trace.Mode = mode
+	if err != nil {
+		trace.Error = err.Error()
+	}
+	data, err := json.Marshal(trace)
+	if err != nil {
+		return fmt.Errorf("error encoding mode change trace: %s", err)
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("error writing mode change trace: %s", err)
+	}
+	return nil
+}
+
+// Close closes the trace file.
+func (l *ModeChangeTraceLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
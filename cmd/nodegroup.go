@@ -0,0 +1,169 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+var (
+	nodeGroupDefaultMutex sync.Mutex
+	nodeGroupDefault      string
+	// reloadedDefault replaces --default-cc-mode once reloaded from the
+	// config directory, the flag itself is never written after startup
+	reloadedDefault string
+)
+
+// getDefaultCCMode returns the CC mode applied when none is requested for
+// the node: the default of the node group if one is configured, otherwise
+// --default-cc-mode.
+func getDefaultCCMode() string {
+	nodeGroupDefaultMutex.Lock()
+	defer nodeGroupDefaultMutex.Unlock()
+	if nodeGroupDefault != "" {
+		return nodeGroupDefault
+	}
+	return globalDefaultCCMode()
+}
+
+// getGlobalDefaultCCMode returns --default-cc-mode, as last reloaded.
+func getGlobalDefaultCCMode() string {
+	nodeGroupDefaultMutex.Lock()
+	defer nodeGroupDefaultMutex.Unlock()
+	return globalDefaultCCMode()
+}
+
+// globalDefaultCCMode must be called with nodeGroupDefaultMutex held.
+func globalDefaultCCMode() string {
+	if reloadedDefault != "" {
+		return reloadedDefault
+	}
+	return defaultCCModeFlag
+}
+
+// setNodeGroupDefault sets the default CC mode of the node group and reports
+// whether it changed. An empty mode restores --default-cc-mode.
+func setNodeGroupDefault(mode string) bool {
+	nodeGroupDefaultMutex.Lock()
+	defer nodeGroupDefaultMutex.Unlock()
+	changed := nodeGroupDefault != mode
+	nodeGroupDefault = mode
+	return changed
+}
+
+// setDefaultCCMode replaces --default-cc-mode at runtime and reports whether
+// it changed.
+func setDefaultCCMode(mode string) bool {
+	nodeGroupDefaultMutex.Lock()
+	defer nodeGroupDefaultMutex.Unlock()
+	changed := globalDefaultCCMode() != mode
+	reloadedDefault = mode
+	return changed
+}
+
+// nodeGroupDefaultFromConfigMap returns the CC mode the ConfigMap maps group
+// to, or an empty string if it has no entry for the group.
+func nodeGroupDefaultFromConfigMap(cm *v1.ConfigMap, group string) (string, error) {
+	mode, ok := cm.Data[group]
+	if !ok {
+		return "", nil
+	}
+	if err := ValidateCCMode(mode); err != nil {
+		return "", fmt.Errorf("invalid cc mode for node group '%s' in ConfigMap %s/%s: %s", group, cm.Namespace, cm.Name, err)
+	}
+	return mode, nil
+}
+
+// LoadNodeGroupDefault sets the default CC mode of the node group from the
+// ConfigMap.
+func LoadNodeGroupDefault(ctx context.Context, clientset *kubernetes.Clientset, namespace, name, group string) error {
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting ConfigMap %s/%s: %s", namespace, name, err)
+	}
+	mode, err := nodeGroupDefaultFromConfigMap(cm, group)
+	if err != nil {
+		return err
+	}
+	if mode == "" {
+		log.Warnf("ConfigMap %s/%s has no cc mode for node group '%s', using the default cc mode '%s'", namespace, name, group, getGlobalDefaultCCMode())
+	} else {
+		log.Infof("Using default cc mode '%s' of node group '%s'", mode, group)
+	}
+	setNodeGroupDefault(mode)
+	return nil
+}
+
+// WatchNodeGroupDefault updates the default CC mode of the node group
+// whenever the ConfigMap changes. If no CC mode is requested for the node,
+// the new default is applied right away.
+func WatchNodeGroupDefault(clientset *kubernetes.Clientset, namespace, name, group string, ccModeConfig *SyncableCCModeConfig) chan struct{} {
+	listWatch := cache.NewListWatchFromClient(
+		clientset.CoreV1().RESTClient(),
+		"configmaps",
+		namespace,
+		fields.OneTermEqualSelector("metadata.name", name),
+	)
+
+	update := func(mode string) {
+		if !setNodeGroupDefault(mode) {
+			return
+		}
+		log.Infof("Default cc mode of node group '%s' changed to '%s'", group, getDefaultCCMode())
+		ccModeConfig.ResyncDefault()
+	}
+
+	_, controller := cache.NewInformer(
+		listWatch, &v1.ConfigMap{}, 0,
+		PanicRecoveryMiddleware("node group", cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				mode, err := nodeGroupDefaultFromConfigMap(obj.(*v1.ConfigMap), group)
+				if err != nil {
+					log.Errorf("Ignoring ConfigMap %s/%s: %s", namespace, name, err)
+					return
+				}
+				update(mode)
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				mode, err := nodeGroupDefaultFromConfigMap(newObj.(*v1.ConfigMap), group)
+				if err != nil {
+					log.Errorf("Ignoring update of ConfigMap %s/%s: %s", namespace, name, err)
+					return
+				}
+				update(mode)
+			},
+			DeleteFunc: func(obj interface{}) {
+				log.Warnf("ConfigMap %s/%s was deleted, using the default cc mode '%s'", namespace, name, getGlobalDefaultCCMode())
+				update("")
+			},
+		}),
+	)
+
+	stop := make(chan struct{})
+	go controller.Run(stop)
+	return stop
+}
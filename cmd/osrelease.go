@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// supportedOSVersions maps the os-release ID of the distributions supported
+// by cc-manager.sh to their minimum VERSION_ID.
+var supportedOSVersions = map[string]string{
+	"ubuntu":    "20.04",
+	"debian":    "11",
+	"rhel":      "8",
+	"rocky":     "8",
+	"almalinux": "8",
+	"sles":      "15",
+}
+
+// OSRelease holds the fields of an os-release(5) file used by the OS check.
+type OSRelease struct {
+	ID        string
+	VersionID string
+}
+
+// ReadOSRelease parses the ID and VERSION_ID fields of an os-release file.
+func ReadOSRelease(path string) (OSRelease, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return OSRelease{}, fmt.Errorf("error reading %s: %s", path, err)
+	}
+
+	var release OSRelease
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !found {
+			continue
+		}
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "ID":
+			release.ID = value
+		case "VERSION_ID":
+			release.VersionID = value
+		}
+	}
+	if release.ID == "" {
+		return OSRelease{}, fmt.Errorf("no ID field in %s", path)
+	}
+	return release, nil
+}
+
+// CheckOSSupported returns an error describing why release is not supported
+// by cc-manager.sh, or nil if it is.
+func CheckOSSupported(release OSRelease) error {
+	minimum, ok := supportedOSVersions[release.ID]
+	if !ok {
+		return fmt.Errorf("OS '%s' is not supported", release.ID)
+	}
+	if compareVersions(release.VersionID, minimum) < 0 {
+		return fmt.Errorf("OS '%s' version '%s' is not supported, the minimum version is %s", release.ID, release.VersionID, minimum)
+	}
+	return nil
+}
+
+// compareVersions compares dotted numeric versions, returning -1, 0 or 1.
+// Components that are not numbers compare as 0.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
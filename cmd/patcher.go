@@ -0,0 +1,210 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// LabelPatchStrategy selects how node labels and annotations are patched.
+type LabelPatchStrategy string
+
+const (
+	// LabelPatchMerge uses a strategic merge patch.
+	LabelPatchMerge LabelPatchStrategy = "merge"
+	// LabelPatchJSON uses a JSON patch with one add operation per key.
+	LabelPatchJSON LabelPatchStrategy = "json"
+	// LabelPatchApply uses server-side apply.
+	LabelPatchApply LabelPatchStrategy = "apply"
+
+	nodePatchFieldManager = "k8s-cc-manager"
+)
+
+// ParseLabelPatchStrategy parses the value of --label-patch-strategy.
+func ParseLabelPatchStrategy(s string) (LabelPatchStrategy, error) {
+	switch strategy := LabelPatchStrategy(s); strategy {
+	case LabelPatchMerge, LabelPatchJSON, LabelPatchApply:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("unknown label patch strategy '%s', must be one of %s, %s, %s", s, LabelPatchMerge, LabelPatchJSON, LabelPatchApply)
+}
+
+// NodeLabelPatcher writes labels and annotations of the current node with
+// the configured patch strategy.
+type NodeLabelPatcher struct {
+	clientset *kubernetes.Clientset
+	nodeName  string
+	strategy  LabelPatchStrategy
+}
+
+func NewNodeLabelPatcher(clientset *kubernetes.Clientset, nodeName string, strategy LabelPatchStrategy) *NodeLabelPatcher {
+	return &NodeLabelPatcher{
+		clientset: clientset,
+		nodeName:  nodeName,
+		strategy:  strategy,
+	}
+}
+
+// PatchLabels sets the given labels on the node and returns the patched node.
+func (p *NodeLabelPatcher) PatchLabels(ctx context.Context, labels map[string]string) (*v1.Node, error) {
+	node, err := p.patch(ctx, "labels", labels)
+	if err != nil {
+		return nil, fmt.Errorf("error patching node labels: %s", err)
+	}
+	return node, nil
+}
+
+// PatchAnnotations sets the given annotations on the node and returns the
+// patched node.
+func (p *NodeLabelPatcher) PatchAnnotations(ctx context.Context, annotations map[string]string) (*v1.Node, error) {
+	node, err := p.patch(ctx, "annotations", annotations)
+	if err != nil {
+		return nil, fmt.Errorf("error patching node annotations: %s", err)
+	}
+	return node, nil
+}
+
+func (p *NodeLabelPatcher) patch(ctx context.Context, field string, values map[string]string) (*v1.Node, error) {
+	switch p.strategy {
+	case LabelPatchJSON:
+		return p.jsonPatch(ctx, field, values)
+	case LabelPatchApply:
+		return p.applyPatch(ctx, field, values)
+	default:
+		return p.mergePatch(ctx, field, values)
+	}
+}
+
+// mergePatch sets the keys with a strategic merge patch of metadata.<field>.
+func (p *NodeLabelPatcher) mergePatch(ctx context.Context, field string, values map[string]string) (*v1.Node, error) {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			field: values,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding patch: %s", err)
+	}
+	return p.clientset.CoreV1().Nodes().Patch(ctx, p.nodeName, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+}
+
+// jsonPatch sets the keys with one JSON patch add operation each. The add
+// operation replaces existing keys, but requires metadata.<field> to exist,
+// which it does on every node registered by the kubelet.
+func (p *NodeLabelPatcher) jsonPatch(ctx context.Context, field string, values map[string]string) (*v1.Node, error) {
+	return p.jsonPatchWithOps(ctx, field, values, nil)
+}
+
+// PatchAnnotationsWithOps sets the annotations and applies the extra RFC 6902
+// operations in a single JSON patch, whatever the patch strategy, so that
+// either all of them or none are applied.
+func (p *NodeLabelPatcher) PatchAnnotationsWithOps(ctx context.Context, annotations map[string]string, extraOps []json.RawMessage) (*v1.Node, error) {
+	node, err := p.jsonPatchWithOps(ctx, "annotations", annotations, extraOps)
+	if err != nil {
+		return nil, fmt.Errorf("error patching node annotations: %s", err)
+	}
+	return node, nil
+}
+
+func (p *NodeLabelPatcher) jsonPatchWithOps(ctx context.Context, field string, values map[string]string, extraOps []json.RawMessage) (*v1.Node, error) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	ops := make([]interface{}, 0, len(keys)+len(extraOps))
+	for _, key := range keys {
+		ops = append(ops, map[string]string{
+			"op":    "add",
+			"path":  fmt.Sprintf("/metadata/%s/%s", field, escapeJSONPointer(key)),
+			"value": values[key],
+		})
+	}
+	for _, op := range extraOps {
+		ops = append(ops, op)
+	}
+	patch, err := json.Marshal(ops)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding patch: %s", err)
+	}
+	return p.clientset.CoreV1().Nodes().Patch(ctx, p.nodeName, types.JSONPatchType, patch, metav1.PatchOptions{})
+}
+
+// ParseJSONPatchOps parses and validates a JSON array of RFC 6902 patch
+// operations.
+func ParseJSONPatchOps(value string) ([]json.RawMessage, error) {
+	patch, err := jsonpatch.DecodePatch([]byte(value))
+	if err != nil {
+		return nil, err
+	}
+	for i, op := range patch {
+		switch op.Kind() {
+		case "add", "remove", "replace", "move", "copy", "test":
+		default:
+			return nil, fmt.Errorf("operation %d: unsupported op '%s'", i, op.Kind())
+		}
+		if _, err := op.Path(); err != nil {
+			return nil, fmt.Errorf("operation %d: %s", i, err)
+		}
+	}
+	var ops []json.RawMessage
+	if err := json.Unmarshal([]byte(value), &ops); err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+// applyPatch sets the keys with server-side apply. Labels and annotations
+// are applied by separate field managers, so that applying one does not
+// release the keys owned for the other. Keys applied before but omitted now
+// are released, and removed unless another manager owns them.
+func (p *NodeLabelPatcher) applyPatch(ctx context.Context, field string, values map[string]string) (*v1.Node, error) {
+	patch, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Node",
+		"metadata": map[string]interface{}{
+			"name": p.nodeName,
+			field:  values,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding patch: %s", err)
+	}
+	force := true
+	options := metav1.PatchOptions{
+		FieldManager: fmt.Sprintf("%s-%s", nodePatchFieldManager, field),
+		Force:        &force,
+	}
+	return p.clientset.CoreV1().Nodes().Patch(ctx, p.nodeName, types.ApplyPatchType, patch, options)
+}
+
+// escapeJSONPointer escapes a key for use in a JSON pointer (RFC 6901).
+func escapeJSONPointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
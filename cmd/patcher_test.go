@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestNodeLabelPatcherStrategies(t *testing.T) {
+	labels := map[string]string{"nvidia.com/cc.mode.state": "on"}
+	tests := []struct {
+		strategy    LabelPatchStrategy
+		contentType types.PatchType
+		body        string
+		query       url.Values
+	}{
+		{
+			LabelPatchMerge,
+			types.StrategicMergePatchType,
+			`{"metadata":{"labels":{"nvidia.com/cc.mode.state":"on"}}}`,
+			url.Values{},
+		},
+		{
+			LabelPatchJSON,
+			types.JSONPatchType,
+			`[{"op":"add","path":"/metadata/labels/nvidia.com~1cc.mode.state","value":"on"}]`,
+			url.Values{},
+		},
+		{
+			LabelPatchApply,
+			types.ApplyPatchType,
+			`{"apiVersion":"v1","kind":"Node","metadata":{"labels":{"nvidia.com/cc.mode.state":"on"},"name":"test"}}`,
+			url.Values{"fieldManager": {"k8s-cc-manager-labels"}, "force": {"true"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			patcher, requests := newStrategyPatchRecorder(t, tt.strategy, 0)
+			if _, err := patcher.PatchLabels(context.Background(), labels); err != nil {
+				t.Fatal(err)
+			}
+			patches := requests()
+			if len(patches) != 1 {
+				t.Fatalf("expected one patch, got %d", len(patches))
+			}
+			if patches[0].contentType != string(tt.contentType) {
+				t.Errorf("expected content type %s, got %s", tt.contentType, patches[0].contentType)
+			}
+			if patches[0].body != tt.body {
+				t.Errorf("expected patch %s, got %s", tt.body, patches[0].body)
+			}
+			if !reflect.DeepEqual(patches[0].query, tt.query) {
+				t.Errorf("expected query %v, got %v", tt.query, patches[0].query)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// runPrecheck invokes the precheck command with mode as its last argument.
+// It returns true if the command exits 0, meaning the node is already in
+// mode, and false if it exits non-zero. An error is returned only if the
+// command could not be run at all.
+func runPrecheck(ctx context.Context, mode string, command string) (bool, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return false, fmt.Errorf("empty precheck command")
+	}
+	if err := checkCCModeArg(mode); err != nil {
+		return false, err
+	}
+
+	cmd := exec.CommandContext(ctx, fields[0], append(fields[1:], mode)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.As(err, &exitErr):
+		return false, nil
+	default:
+		return false, fmt.Errorf("error running precheck command '%s': %w", command, err)
+	}
+}
@@ -0,0 +1,192 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// CCModeChangePreview describes what applying a CC mode would do.
+type CCModeChangePreview struct {
+	ResolvedMode         string        `json:"resolvedMode"`
+	SkipReason           string        `json:"skipReason,omitempty"`
+	RejectReason         string        `json:"rejectReason,omitempty"`
+	EstimatedDuration    time.Duration `json:"estimatedDuration"`
+	PreConditionWarnings []string      `json:"preConditionWarnings"`
+}
+
+// CCModeChangePreviewer dry-runs CC mode changes for the current node. It
+// only reads from the API server and only runs read-only cc-manager.sh
+// commands such as query.
+type CCModeChangePreviewer struct {
+	clientset *kubernetes.Clientset
+	nodeName  string
+	state     *SyncableCCModeState
+}
+
+func NewCCModeChangePreviewer(clientset *kubernetes.Clientset, nodeName string, state *SyncableCCModeState) *CCModeChangePreviewer {
+	return &CCModeChangePreviewer{
+		clientset: clientset,
+		nodeName:  nodeName,
+		state:     state,
+	}
+}
+
+// PreviewModeChange resolves and validates mode the same way the main loop
+// does and reports the pre-conditions that would affect the change. The
+// change is checked with checkCCModeChange from the last CC mode applied, a
+// failing check is reported as the reject reason, and the change windows and
+// taints that would defer it are reported as warnings. An empty mode
+// previews the configuration currently requested for the node.
+func (p *CCModeChangePreviewer) PreviewModeChange(ctx context.Context, mode string) (CCModeChangePreview, error) {
+	node, err := p.clientset.CoreV1().Nodes().Get(ctx, p.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return CCModeChangePreview{}, fmt.Errorf("error getting node %s: %s", p.nodeName, err)
+	}
+
+	resolved := mode
+	if resolved == "" {
+		resolved = getCCModeConfig(node)
+	}
+	if resolved == "" {
+		resolved = getDefaultCCMode()
+	}
+	var policy *CCModePolicy
+	if isCCModePolicy(resolved) {
+		parsed, err := ParseCCModePolicy(resolved)
+		if err != nil {
+			return CCModeChangePreview{}, fmt.Errorf("invalid '%s' annotation: %s", CCModePolicyAnnotation, err)
+		}
+		policy = &parsed
+		resolved = parsed.Default
+	}
+	if err := ValidateCCMode(resolved); err != nil {
+		return CCModeChangePreview{}, err
+	}
+
+	preview := CCModeChangePreview{
+		ResolvedMode:         resolved,
+		PreConditionWarnings: []string{},
+	}
+
+	state := p.state.Get()
+	if state.CurrentMode == resolved {
+		preview.SkipReason = fmt.Sprintf("CC mode %s is already applied", resolved)
+	} else {
+		preview.EstimatedDuration = state.LastChangeDuration
+	}
+	if err := checkCCModeChange(state.CurrentMode, resolved, policy); err != nil {
+		preview.RejectReason = err.Error()
+	}
+	preview.PreConditionWarnings = append(preview.PreConditionWarnings, ccModeChangeDeferrals(node, time.Now())...)
+
+	if !isNodeReady(node) {
+		preview.PreConditionWarnings = append(preview.PreConditionWarnings, "node is not Ready")
+	}
+	if node.Spec.Unschedulable {
+		preview.PreConditionWarnings = append(preview.PreConditionWarnings, "node is cordoned")
+	}
+
+	warnings, err := p.checkPodDisruptionBudgets(ctx)
+	if err != nil {
+		warnings = []string{fmt.Sprintf("unable to check PodDisruptionBudgets: %s", err)}
+	}
+	preview.PreConditionWarnings = append(preview.PreConditionWarnings, warnings...)
+
+	return preview, nil
+}
+
+// checkPodDisruptionBudgets returns a warning for every pod on the node that
+// is covered by a PodDisruptionBudget currently allowing no disruptions.
+func (p *CCModeChangePreviewer) checkPodDisruptionBudgets(ctx context.Context) ([]string, error) {
+	pods, err := p.clientset.CoreV1().Pods(v1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", p.nodeName).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing pods: %s", err)
+	}
+	pdbs, err := p.clientset.PolicyV1().PodDisruptionBudgets(v1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing PodDisruptionBudgets: %s", err)
+	}
+
+	var warnings []string
+	for _, pdb := range pdbs.Items {
+		if pdb.Status.DisruptionsAllowed > 0 {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil || selector.Empty() {
+			continue
+		}
+		for _, pod := range pods.Items {
+			if pod.Namespace != pdb.Namespace || !selector.Matches(labels.Set(pod.Labels)) {
+				continue
+			}
+			warnings = append(warnings, fmt.Sprintf("pod %s/%s is covered by PodDisruptionBudget %s which allows no disruptions", pod.Namespace, pod.Name, pdb.Name))
+		}
+	}
+	return warnings, nil
+}
+
+// ServeHTTP implements GET /preview?mode=<mode>.
+func (p *CCModeChangePreviewer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode != "" {
+		if err := ValidateCCMode(mode); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	preview, err := p.PreviewModeChange(r.Context(), mode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(preview); err != nil {
+		log.Warnf("Unable to write preview response: %s", err)
+	}
+}
+
+func isNodeReady(node *v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// mutexProfileFraction samples one in this many mutex contention events.
+	mutexProfileFraction = 5
+	// blockProfileRate samples blocking events lasting this many nanoseconds
+	// on average.
+	blockProfileRate = 1000000
+)
+
+// enableContentionProfiling turns on the mutex and block profiles requested
+// on the command line. Both add overhead to every contended lock or blocking
+// operation, whether or not the profiles are ever read.
+func enableContentionProfiling() {
+	if enablePprofMutexFlag {
+		log.Infof("Enabling mutex profiling, sampling 1 in %d contention events", mutexProfileFraction)
+		runtime.SetMutexProfileFraction(mutexProfileFraction)
+	}
+	if enablePprofBlockFlag {
+		log.Infof("Enabling block profiling, sampling every %dns spent blocked", blockProfileRate)
+		runtime.SetBlockProfileRate(blockProfileRate)
+	}
+}
+
+// registerProfileHandlers serves the profiles under /debug/pprof/ when mutex
+// or block profiling is enabled.
+func registerProfileHandlers(mux *http.ServeMux) {
+	if !enablePprofMutexFlag && !enablePprofBlockFlag {
+		return
+	}
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const GPUDirectRDMALabel = "nvidia.com/gpu-direct-rdma"
+
+// GPUDirectRDMACompatChecker warns when CC mode on is applied to a node with
+// GPUDirect RDMA enabled, which is incompatible in some configurations.
+type GPUDirectRDMACompatChecker struct {
+	clientset *kubernetes.Clientset
+	nodeName  string
+	events    *NodeEventRecorder
+}
+
+func NewGPUDirectRDMACompatChecker(clientset *kubernetes.Clientset, nodeName string, events *NodeEventRecorder) *GPUDirectRDMACompatChecker {
+	return &GPUDirectRDMACompatChecker{
+		clientset: clientset,
+		nodeName:  nodeName,
+		events:    events,
+	}
+}
+
+// Check emits a Warning event if mode is on and the node is labelled with
+// nvidia.com/gpu-direct-rdma=enabled. The change is never blocked. It is a
+// no-op on a nil checker.
+func (c *GPUDirectRDMACompatChecker) Check(mode string) {
+	if c == nil || mode != CCModeOn {
+		return
+	}
+	node, err := c.clientset.CoreV1().Nodes().Get(context.TODO(), c.nodeName, metav1.GetOptions{})
+	if err != nil {
+		log.Warnf("Unable to check GPUDirect RDMA compatibility: error getting node %s: %s", c.nodeName, err)
+		return
+	}
+	if node.Labels[GPUDirectRDMALabel] != "enabled" {
+		return
+	}
+	log.Warnf("Applying CC mode %s with GPUDirect RDMA enabled (%s=enabled), which is incompatible in some configurations", mode, GPUDirectRDMALabel)
+	c.events.Eventf(v1.EventTypeWarning, "CCModeGPUDirectRDMAIncompatible", "Applying CC mode %s with GPUDirect RDMA enabled, which is incompatible in some configurations", mode)
+}
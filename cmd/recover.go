@@ -0,0 +1,57 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"runtime/debug"
+
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/client-go/tools/cache"
+)
+
+// PanicRecoveryMiddleware wraps informer event handlers so that a panic in
+// one of them is logged instead of stopping the informer. The informer keeps
+// delivering the following events to the handlers.
+func PanicRecoveryMiddleware(name string, handlers cache.ResourceEventHandlerFuncs) cache.ResourceEventHandlerFuncs {
+	wrapped := cache.ResourceEventHandlerFuncs{}
+	if handlers.AddFunc != nil {
+		wrapped.AddFunc = func(obj interface{}) {
+			defer recoverHandlerPanic(name, "add")
+			handlers.AddFunc(obj)
+		}
+	}
+	if handlers.UpdateFunc != nil {
+		wrapped.UpdateFunc = func(oldObj, newObj interface{}) {
+			defer recoverHandlerPanic(name, "update")
+			handlers.UpdateFunc(oldObj, newObj)
+		}
+	}
+	if handlers.DeleteFunc != nil {
+		wrapped.DeleteFunc = func(obj interface{}) {
+			defer recoverHandlerPanic(name, "delete")
+			handlers.DeleteFunc(obj)
+		}
+	}
+	return wrapped
+}
+
+func recoverHandlerPanic(name, event string) {
+	if r := recover(); r != nil {
+		log.Errorf("panic in %s %s handler: %v\n%s", name, event, r, debug.Stack())
+	}
+}
@@ -0,0 +1,240 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	cli "github.com/urfave/cli/v2"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const DefaultConfigDir = "/etc/cc-manager"
+
+// runtimeConfigSettings are the settings a SignalledConfigReloader applies
+// without a restart. They return whether the effective value changed. All
+// other flags found in the config directory only take effect on startup.
+var runtimeConfigSettings = map[string]func(value string) (bool, error){
+	"default-cc-mode": func(value string) (bool, error) {
+		if err := ValidateCCMode(value); err != nil {
+			return false, err
+		}
+		return setDefaultCCMode(value), nil
+	},
+	"cc-mode-template": func(value string) (bool, error) {
+		if value == "" {
+			changed := getCCModeTemplate() != nil
+			setCCModeTemplate(nil)
+			return changed, nil
+		}
+		tmpl, err := ParseCCModeTemplate(value)
+		if err != nil {
+			return false, err
+		}
+		setCCModeTemplate(tmpl)
+		return true, nil
+	},
+}
+
+// ReadConfigDir returns the settings in dir, one file per flag named after
+// the flag. Hidden entries, such as the ..data symlink of a mounted
+// ConfigMap, are ignored.
+func ReadConfigDir(dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config directory: %s", err)
+	}
+	settings := make(map[string]string)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading config directory: %s", err)
+		}
+		if info.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading config directory: %s", err)
+		}
+		settings[entry.Name()] = strings.TrimSpace(string(data))
+	}
+	return settings, nil
+}
+
+// applyConfigDir sets every flag found in the config directory that was not
+// given on the command line or in the environment, so that the usual flag
+// validation covers them. It returns the settings read.
+func applyConfigDir(c *cli.Context, dir string) (map[string]string, error) {
+	settings, err := ReadConfigDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range settings {
+		if !isFlagName(c.App.Flags, name) {
+			log.Warnf("Ignoring unknown setting '%s' in config directory %s", name, dir)
+			continue
+		}
+		if c.IsSet(name) {
+			continue
+		}
+		if err := c.Set(name, value); err != nil {
+			return nil, fmt.Errorf("invalid setting '%s' in config directory %s: %s", name, dir, err)
+		}
+	}
+	return settings, nil
+}
+
+func isFlagName(flags []cli.Flag, name string) bool {
+	for _, flag := range flags {
+		for _, n := range flag.Names() {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SignalledConfigReloader watches the config directory and re-reads it when
+// a file is created or written, which includes the symlink rotation of a
+// ConfigMap volume update. Settings in runtimeConfigSettings are validated
+// and applied right away, a change to any other setting is only logged since
+// it requires a restart.
+type SignalledConfigReloader struct {
+	dir          string
+	clientset    *kubernetes.Clientset
+	ccModeConfig *SyncableCCModeConfig
+	watcher      *fsnotify.Watcher
+	current      map[string]string
+}
+
+func NewSignalledConfigReloader(dir string, initial map[string]string, clientset *kubernetes.Clientset, ccModeConfig *SyncableCCModeConfig) (*SignalledConfigReloader, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("error creating config directory watcher: %s", err)
+	}
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("error watching config directory %s: %s", dir, err)
+	}
+	current := make(map[string]string)
+	for name, value := range initial {
+		current[name] = value
+	}
+	return &SignalledConfigReloader{
+		dir:          dir,
+		clientset:    clientset,
+		ccModeConfig: ccModeConfig,
+		watcher:      watcher,
+		current:      current,
+	}, nil
+}
+
+// Run reloads the config directory on every change until Stop is called.
+func (r *SignalledConfigReloader) Run() {
+	for {
+		select {
+		case event, ok := <-r.watcher.Events:
+			if !ok {
+				return
+			}
+			if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				log.Errorf("Error reloading config directory %s: %s", r.dir, err)
+			}
+		case err, ok := <-r.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Errorf("Error watching config directory %s: %s", r.dir, err)
+		}
+	}
+}
+
+// Reload re-reads the config directory and applies the settings that
+// changed. An invalid setting is reported and keeps its previous value.
+func (r *SignalledConfigReloader) Reload() error {
+	settings, err := ReadConfigDir(r.dir)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	changed := make(map[string]bool)
+	for name, value := range settings {
+		if previous, ok := r.current[name]; ok && previous == value {
+			continue
+		}
+		apply, ok := runtimeConfigSettings[name]
+		if !ok {
+			log.Warnf("Setting '%s' in config directory %s changed, restart the daemon to apply it", name, r.dir)
+			r.current[name] = value
+			continue
+		}
+		updated, err := apply(value)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("invalid setting '%s': %s", name, err))
+			continue
+		}
+		log.Infof("Reloaded setting '%s' from config directory %s: '%s'", name, r.dir, value)
+		r.current[name] = value
+		changed[name] = updated
+	}
+	for name := range r.current {
+		if _, ok := settings[name]; !ok {
+			log.Warnf("Setting '%s' was removed from config directory %s, restart the daemon to apply its default", name, r.dir)
+			delete(r.current, name)
+		}
+	}
+
+	if changed["cc-mode-template"] {
+		node, err := r.clientset.CoreV1().Nodes().Get(context.TODO(), os.Getenv("NODE_NAME"), metav1.GetOptions{})
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("error getting node to evaluate the cc mode template: %s", err))
+		} else {
+			r.ccModeConfig.Set(getCCModeConfig(node))
+		}
+	}
+	if changed["default-cc-mode"] {
+		r.ccModeConfig.ResyncDefault()
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Stop stops watching the config directory.
+func (r *SignalledConfigReloader) Stop() {
+	r.watcher.Close()
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ModeRequirements lists the system resources a CC mode needs before it can
+// be applied.
+type ModeRequirements struct {
+	MinGPUMemoryGB       float64  `json:"minGPUMemoryGB,omitempty"`
+	RequiresKernelModule []string `json:"requiresKernelModule,omitempty"`
+}
+
+// ResourceState is the output of 'cc-manager.sh query'.
+type ResourceState struct {
+	KernelModules []string           `json:"kernelModules"`
+	GPUs          []GPUResourceState `json:"gpus"`
+}
+
+// GPUResourceState holds the resources of a single CC capable GPU. The free
+// memory is unknown when it cannot be queried on the node.
+type GPUResourceState struct {
+	ID           string   `json:"id"`
+	FreeMemoryGB *float64 `json:"freeMemoryGB,omitempty"`
+}
+
+// LoadModeRequirements reads a YAML file mapping CC mode names to their
+// requirements.
+func LoadModeRequirements(path string) (map[string]ModeRequirements, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading mode requirements file: %s", err)
+	}
+
+	var requirements map[string]ModeRequirements
+	if err := yaml.UnmarshalStrict(data, &requirements); err != nil {
+		return nil, fmt.Errorf("error parsing mode requirements file %s: %s", path, err)
+	}
+	for mode, r := range requirements {
+		if err := ValidateCCMode(mode); err != nil {
+			return nil, fmt.Errorf("error in mode requirements file %s: %s", path, err)
+		}
+		if r.MinGPUMemoryGB < 0 {
+			return nil, fmt.Errorf("error in mode requirements file %s: negative minGPUMemoryGB for mode '%s'", path, mode)
+		}
+	}
+	return requirements, nil
+}
+
+// QueryResourceState runs 'cc-manager.sh query' to obtain the current
+// resource state of the node.
+func QueryResourceState() (ResourceState, error) {
+	output, err := execScriptOutput([]string{"query"})
+	if err != nil {
+		return ResourceState{}, fmt.Errorf("error querying resource state: %w", err)
+	}
+
+	var state ResourceState
+	if err := json.Unmarshal(output, &state); err != nil {
+		return ResourceState{}, fmt.Errorf("error parsing resource state '%s': %s", strings.TrimSpace(string(output)), err)
+	}
+	return state, nil
+}
+
+// CheckModeRequirements returns a descriptive error listing every
+// requirement of mode that state does not satisfy.
+func CheckModeRequirements(mode string, requirements ModeRequirements, state ResourceState) error {
+	loaded := make(map[string]bool)
+	for _, m := range state.KernelModules {
+		loaded[normalizeKernelModule(m)] = true
+	}
+
+	var unmet []string
+	for _, m := range requirements.RequiresKernelModule {
+		if !loaded[normalizeKernelModule(m)] {
+			unmet = append(unmet, fmt.Sprintf("kernel module %s is not loaded", m))
+		}
+	}
+	if requirements.MinGPUMemoryGB > 0 {
+		for _, gpu := range state.GPUs {
+			switch {
+			case gpu.FreeMemoryGB == nil:
+				unmet = append(unmet, fmt.Sprintf("free memory of gpu %s is unknown", gpu.ID))
+			case *gpu.FreeMemoryGB < requirements.MinGPUMemoryGB:
+				unmet = append(unmet, fmt.Sprintf("gpu %s has %.1fGB of free memory, %.1fGB required", gpu.ID, *gpu.FreeMemoryGB, requirements.MinGPUMemoryGB))
+			}
+		}
+	}
+
+	if len(unmet) > 0 {
+		return fmt.Errorf("requirements of cc mode %s are not met: %s", mode, strings.Join(unmet, ", "))
+	}
+	return nil
+}
+
+// checkModeRequirements validates the requirements configured for mode, if
+// any, against the current state of the node.
+func checkModeRequirements(mode string) error {
+	requirements, ok := modeRequirements[mode]
+	if !ok {
+		return nil
+	}
+	state, err := QueryResourceState()
+	if err != nil {
+		return err
+	}
+	return CheckModeRequirements(mode, requirements, state)
+}
+
+// normalizeKernelModule accounts for /proc/modules reporting dashes in module
+// names as underscores.
+func normalizeKernelModule(name string) string {
+	return strings.ReplaceAll(name, "-", "_")
+}
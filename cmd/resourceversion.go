@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// NodeResourceVersionTracker persists the last seen resource version of the
+// node in --resource-version-file, so that the first list after a restart
+// can resume from it instead of listing from the beginning.
+type NodeResourceVersionTracker struct {
+	path string
+
+	mutex   sync.Mutex
+	stored  string
+	written string
+}
+
+// NewNodeResourceVersionTracker reads the resource version stored at path,
+// if any. A missing file is not an error.
+func NewNodeResourceVersionTracker(path string) (*NodeResourceVersionTracker, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("error reading resource version file: %s", err)
+	}
+	stored := strings.TrimSpace(string(data))
+	return &NodeResourceVersionTracker{
+		path:    path,
+		stored:  stored,
+		written: stored,
+	}, nil
+}
+
+// TakeStored returns the resource version read at startup the first time it
+// is called, and an empty string afterwards, so that only the initial list
+// resumes from it. It returns an empty string on a nil tracker.
+func (t *NodeResourceVersionTracker) TakeStored() string {
+	if t == nil {
+		return ""
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	stored := t.stored
+	t.stored = ""
+	return stored
+}
+
+// Record writes resourceVersion to the file if it changed. The file is
+// replaced atomically so that a crash never leaves a partial version. It is
+// a no-op on a nil tracker.
+func (t *NodeResourceVersionTracker) Record(resourceVersion string) {
+	if t == nil || resourceVersion == "" {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if resourceVersion == t.written {
+		return
+	}
+	tmp := filepath.Join(filepath.Dir(t.path), "."+filepath.Base(t.path)+".tmp")
+	if err := os.WriteFile(tmp, []byte(resourceVersion), 0o600); err != nil {
+		log.Warnf("Unable to write resource version file: %s", err)
+		return
+	}
+	if err := os.Rename(tmp, t.path); err != nil {
+		log.Warnf("Unable to write resource version file: %s", err)
+		return
+	}
+	t.written = resourceVersion
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// kubernetesRetryAttempts is the number of times a request is sent
+	// before a retryable status code is returned to the client.
+	kubernetesRetryAttempts     = 3
+	kubernetesRetryInitialDelay = 500 * time.Millisecond
+)
+
+// ParseRetryStatusCodes parses a comma-separated list of HTTP status codes.
+func ParseRetryStatusCodes(value string) (map[int]bool, error) {
+	codes := make(map[int]bool)
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		code, err := strconv.Atoi(s)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid HTTP status code '%s'", s)
+		}
+		codes[code] = true
+	}
+	return codes, nil
+}
+
+// retryRoundTripper resends Kubernetes API requests answered with one of the
+// configured status codes, with an exponential backoff. Requests whose body
+// cannot be replayed are never retried.
+type retryRoundTripper struct {
+	next  http.RoundTripper
+	codes map[int]bool
+}
+
+func newRetryRoundTripper(codes map[int]bool) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &retryRoundTripper{next: next, codes: codes}
+	}
+}
+
+func (t *retryRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	delay := kubernetesRetryInitialDelay
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if err != nil || !t.codes[resp.StatusCode] || attempt == kubernetesRetryAttempts {
+			return resp, err
+		}
+		if req.Body != nil && req.GetBody == nil {
+			return resp, err
+		}
+		log.Warnf("Kubernetes API request %s %s returned %d, retrying in %s (attempt %d/%d)", req.Method, req.URL.Path, resp.StatusCode, delay, attempt, kubernetesRetryAttempts)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// RuntimeClassFilter tracks the pods of the node using one of the
+// --runtime-class-filter RuntimeClasses. While there is none, the daemon is
+// passive: it keeps watching the node but does not change the CC mode. The
+// current CC mode is reapplied once the first such pod is scheduled.
+type RuntimeClassFilter struct {
+	clientset    *kubernetes.Clientset
+	nodeName     string
+	classes      map[string]bool
+	ccModeConfig *SyncableCCModeConfig
+
+	mutex    sync.Mutex
+	matching map[string]bool
+}
+
+func NewRuntimeClassFilter(clientset *kubernetes.Clientset, nodeName string, classes map[string]bool, ccModeConfig *SyncableCCModeConfig) *RuntimeClassFilter {
+	return &RuntimeClassFilter{
+		clientset:    clientset,
+		nodeName:     nodeName,
+		classes:      classes,
+		ccModeConfig: ccModeConfig,
+		matching:     make(map[string]bool),
+	}
+}
+
+// Run watches the pods of the node until the returned channel is closed. It
+// returns once the initial list of pods was processed.
+func (f *RuntimeClassFilter) Run() (chan struct{}, error) {
+	listWatch := cache.NewListWatchFromClient(
+		f.clientset.CoreV1().RESTClient(),
+		"pods",
+		v1.NamespaceAll,
+		fields.OneTermEqualSelector("spec.nodeName", f.nodeName),
+	)
+
+	_, controller := cache.NewInformer(
+		listWatch, &v1.Pod{}, 0,
+		PanicRecoveryMiddleware("runtime class filter", cache.ResourceEventHandlerFuncs{
+			AddFunc: f.update,
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				f.update(newObj)
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				if pod, ok := obj.(*v1.Pod); ok {
+					f.remove(pod)
+				}
+			},
+		}),
+	)
+
+	stop := make(chan struct{})
+	go controller.Run(stop)
+	if !cache.WaitForCacheSync(stop, controller.HasSynced) {
+		close(stop)
+		return nil, fmt.Errorf("error listing pods on node %s", f.nodeName)
+	}
+	if !f.Active() {
+		log.Infof("No pod on the node uses RuntimeClass %s, not changing the CC mode until one is scheduled", f.classNames())
+	}
+	return stop, nil
+}
+
+// Active reports whether a pod of the node uses one of the RuntimeClasses. A
+// nil filter is always active.
+func (f *RuntimeClassFilter) Active() bool {
+	if f == nil {
+		return true
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.matching) != 0
+}
+
+func (f *RuntimeClassFilter) update(obj interface{}) {
+	pod := obj.(*v1.Pod)
+	if pod.Spec.RuntimeClassName == nil || !f.classes[*pod.Spec.RuntimeClassName] ||
+		pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		f.remove(pod)
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	key := pod.Namespace + "/" + pod.Name
+	if f.matching[key] {
+		return
+	}
+	f.matching[key] = true
+	if len(f.matching) == 1 {
+		log.Infof("Pod %s uses RuntimeClass %s, applying the CC mode", key, *pod.Spec.RuntimeClassName)
+		f.ccModeConfig.Reapply()
+	}
+}
+
+func (f *RuntimeClassFilter) remove(pod *v1.Pod) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	key := pod.Namespace + "/" + pod.Name
+	if !f.matching[key] {
+		return
+	}
+	delete(f.matching, key)
+	if len(f.matching) == 0 {
+		log.Infof("No pod on the node uses RuntimeClass %s anymore, not changing the CC mode until one is scheduled", f.classNames())
+	}
+}
+
+func (f *RuntimeClassFilter) classNames() string {
+	var names []string
+	for name := range f.classes {
+		names = append(names, name)
+	}
+	return strings.Join(names, ", ")
+}
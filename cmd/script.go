@@ -0,0 +1,492 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// CCManagerScript changes the CC mode. It is a variable so that tests can run
+// a stub instead.
+var CCManagerScript = "/usr/bin/cc-manager.sh"
+
+const (
+	// DefaultScriptArgsTemplate renders the arguments cc-manager.sh has
+	// always been invoked with to set the cc mode.
+	DefaultScriptArgsTemplate = "set-cc-mode -a -m {{.Mode}}"
+)
+
+// scriptArgPattern is what the strings the daemon does not control must
+// match before they reach a script, unless
+// --disable-script-shell-injection-protection is set: every argument
+// rendered from --script-args-template, which includes the discovered device
+// IDs, and the process names of a CC mode policy. Arguments are never
+// interpreted by a shell, but cc-manager.sh word-splits some of them and may
+// mishandle characters meaningful to a shell.
+var scriptArgPattern = regexp.MustCompile(`^[a-zA-Z0-9_.,:=/+-]+$`)
+
+// ccModeArgPattern is what a CC mode must match before runScript renders it
+// into the cc-manager.sh arguments or it is passed to
+// --cc-mode-change-precheck-cmd, under the same condition. A CC mode is a
+// single word, so it is held to a stricter pattern than the rest.
+var ccModeArgPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// checkScriptArg returns an error if arg, described by what, does not match
+// scriptArgPattern.
+func checkScriptArg(what, arg string) error {
+	if disableInjectionProtectionFlag || scriptArgPattern.MatchString(arg) {
+		return nil
+	}
+	return fmt.Errorf("refusing to pass %s '%s' to a script, it must match %s", what, arg, scriptArgPattern)
+}
+
+// checkCCModeArg returns an error if mode does not match ccModeArgPattern.
+func checkCCModeArg(mode string) error {
+	if disableInjectionProtectionFlag || ccModeArgPattern.MatchString(mode) {
+		return nil
+	}
+	return fmt.Errorf("refusing to pass cc mode '%s' to a script, it must match %s", mode, ccModeArgPattern)
+}
+
+// ScriptArgs holds the variables available to --script-args-template.
+type ScriptArgs struct {
+	Mode       string
+	DeviceIDs  []string
+	AllDevices bool
+}
+
+var scriptArgsTemplateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// ParseScriptArgsTemplate parses the template and renders it once for every
+// CC mode so that errors surface at startup.
+func ParseScriptArgsTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("script-args").Funcs(scriptArgsTemplateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, mode := range ValidCCModes {
+		args, err := renderScriptArgs(tmpl, ScriptArgs{Mode: mode, DeviceIDs: ccCapableDeviceIDs(), AllDevices: true})
+		if err != nil {
+			return nil, fmt.Errorf("cc mode %s: %s", mode, err)
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("cc mode %s: template renders no arguments", mode)
+		}
+		for _, arg := range args {
+			if err := checkScriptArg("cc-manager.sh argument", arg); err != nil {
+				return nil, fmt.Errorf("cc mode %s: %s", mode, err)
+			}
+		}
+	}
+	return tmpl, nil
+}
+
+// renderScriptArgs executes tmpl and splits the result on whitespace into
+// the cc-manager.sh argument list.
+func renderScriptArgs(tmpl *template.Template, data ScriptArgs) ([]string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return strings.Fields(buf.String()), nil
+}
+
+// ccCapableDeviceIDs returns the entries of CC_CAPABLE_DEVICE_IDS in the
+// format set by --device-id-format.
+func ccCapableDeviceIDs() []string {
+	var ids []string
+	for _, id := range strings.Split(os.Getenv("CC_CAPABLE_DEVICE_IDS"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, FormatDeviceID(id, deviceIDFormat))
+		}
+	}
+	return ids
+}
+
+// setCCCapableDeviceIDs sets CC_CAPABLE_DEVICE_IDS to ids, restricted to the
+// devices matching --device-filter. The env is updated in place so that
+// cc-manager.sh, which inherits it, only changes the selected devices.
+func setCCCapableDeviceIDs(ids []string) error {
+	if deviceFilter != nil {
+		matched, err := FilterDeviceIDs(ids, deviceFilter)
+		if err != nil {
+			return fmt.Errorf("error applying --device-filter: %s", err)
+		}
+		if len(matched) == 0 {
+			return fmt.Errorf("no device in %s matches --device-filter", strings.Join(ids, ","))
+		}
+		log.Infof("Restricting CC capable devices to %s (see --device-filter)", strings.Join(matched, ","))
+		ids = matched
+	}
+	return os.Setenv("CC_CAPABLE_DEVICE_IDS", strings.Join(ids, ","))
+}
+
+func runScript(ccMode string) error {
+	if err := checkCCModeArg(ccMode); err != nil {
+		return err
+	}
+	args, err := renderScriptArgs(scriptArgsTemplate, ScriptArgs{
+		Mode:       ccMode,
+		DeviceIDs:  ccCapableDeviceIDs(),
+		AllDevices: true,
+	})
+	if err != nil {
+		return fmt.Errorf("error rendering cc-manager.sh arguments: %s", err)
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("--script-args-template renders no cc-manager.sh arguments for cc mode %s", ccMode)
+	}
+	for _, arg := range args {
+		if err := checkScriptArg("cc-manager.sh argument", arg); err != nil {
+			return err
+		}
+	}
+	return execScript(args)
+}
+
+func runPolicyScript(policy CCModePolicy) error {
+	for _, p := range policy.Processes {
+		if err := checkScriptArg("cc mode policy process name", p.Name); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return fmt.Errorf("error encoding cc mode policy: %s", err)
+	}
+	args := []string{
+		"set-cc-mode-policy",
+		"-a",
+		"-p", string(data),
+	}
+	return execScript(args)
+}
+
+// execScript runs cc-manager.sh. A failure is returned as a DeviceError for
+// the last GPU the script reported working on, if any.
+func execScript(args []string) error {
+	stdout := newScriptOutputWriter("output", newScriptLogWriter(scriptStdoutLogLevel))
+	defer stdout.Close()
+	stderr := newScriptOutputWriter("error output", newScriptLogWriter(scriptStderrLogLevel))
+	defer stderr.Close()
+
+	var devices scriptDeviceTracker
+	cmd := newScriptCommand(args)
+	cmd.Stdout = io.MultiWriter(stdout, &devices)
+	cmd.Stderr = stderr
+	if err := startScriptCommand(cmd); err != nil {
+		return err
+	}
+	if err := cmd.Wait(); err != nil {
+		if device := devices.Device(); device != "" {
+			return NewDeviceError(device, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// scriptDevicePattern matches the PCI address of the GPU cc-manager.sh
+// reports working on, as in "setting cc mode of gpu 0000:41:00.0 to on".
+var scriptDevicePattern = regexp.MustCompile(`\bgpu ([0-9a-fA-F]{4,}:[0-9a-fA-F]{2}:[0-9a-fA-F]{2}\.[0-9a-fA-F])`)
+
+// scriptDeviceTracker records the last GPU named on the script output written
+// to it. It must not be read before the script output is fully copied, which
+// cmd.Wait guarantees.
+type scriptDeviceTracker struct {
+	line   []byte
+	device string
+}
+
+func (t *scriptDeviceTracker) Write(p []byte) (int, error) {
+	t.line = append(t.line, p...)
+	for {
+		i := bytes.IndexByte(t.line, '\n')
+		if i < 0 {
+			break
+		}
+		t.scan(t.line[:i])
+		t.line = t.line[i+1:]
+	}
+	return len(p), nil
+}
+
+func (t *scriptDeviceTracker) scan(line []byte) {
+	if matches := scriptDevicePattern.FindAllSubmatch(line, -1); len(matches) != 0 {
+		t.device = string(matches[len(matches)-1][1])
+	}
+}
+
+// Device returns the last GPU named on the output, including its unterminated
+// last line.
+func (t *scriptDeviceTracker) Device() string {
+	t.scan(t.line)
+	t.line = nil
+	return t.device
+}
+
+// execScriptOutput runs cc-manager.sh and returns its standard output. Only
+// the standard error is transcoded from --script-output-encoding, the
+// returned output is left untouched for parsing.
+func execScriptOutput(args []string) ([]byte, error) {
+	stderr := newScriptOutputWriter("error output", newScriptLogWriter(scriptStderrLogLevel))
+	defer stderr.Close()
+
+	var stdout bytes.Buffer
+	cmd := newScriptCommand(args)
+	cmd.Stdout = &stdout
+	cmd.Stderr = stderr
+	if err := startScriptCommand(cmd); err != nil {
+		return nil, err
+	}
+	err := cmd.Wait()
+	return stdout.Bytes(), err
+}
+
+// startScriptCommand starts cmd and moves it to the cgroup set by
+// --script-cgroup. Failing to move it only logs a warning so that the CC mode
+// change still happens.
+func startScriptCommand(cmd *exec.Cmd) error {
+	if err := startScriptCommandWithPriority(cmd); err != nil {
+		return err
+	}
+	if scriptCgroupFlag != "" {
+		if err := moveToCgroup(cmd.Process.Pid, scriptCgroupFlag); err != nil {
+			log.Warnf("Unable to move cc-manager.sh to cgroup %s, running it in the daemon's cgroup: %s", scriptCgroupFlag, err)
+		}
+	}
+	return nil
+}
+
+// startScriptCommandWithPriority starts cmd with the nice value set by
+// --script-nice-value, in the network namespace set by
+// --script-network-namespace. On Linux both are per-thread attributes
+// inherited by child processes, so the command is started from a locked
+// thread whose priority and namespace were adjusted. The thread is never
+// unlocked, which makes the runtime terminate it with the goroutine instead of
+// reusing it for the daemon itself.
+func startScriptCommandWithPriority(cmd *exec.Cmd) error {
+	if scriptNiceValueFlag == 0 && scriptNetworkNamespaceFlag == "" {
+		return cmd.Start()
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		if scriptNiceValueFlag != 0 {
+			if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, scriptNiceValueFlag); err != nil {
+				errCh <- fmt.Errorf("error setting nice value %d for cc-manager.sh: %w", scriptNiceValueFlag, err)
+				return
+			}
+		}
+		if scriptNetworkNamespaceFlag != "" {
+			if err := enterNetworkNamespace(scriptNetworkNamespaceFlag); err != nil {
+				log.Warnf("Unable to enter network namespace %s, running cc-manager.sh in the daemon's network namespace: %s", scriptNetworkNamespaceFlag, err)
+			}
+		}
+		errCh <- cmd.Start()
+	}()
+	return <-errCh
+}
+
+// enterNetworkNamespace moves the calling thread into the network namespace
+// of the namespace file path, such as /proc/1/ns/net, as nsenter --net does.
+func enterNetworkNamespace(path string) error {
+	ns, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer ns.Close()
+	return unix.Setns(int(ns.Fd()), unix.CLONE_NEWNET)
+}
+
+// ValidateNetworkNamespace returns an error if path is not absolute. A path
+// that does not exist only logs a warning, cc-manager.sh then runs in the
+// daemon's network namespace.
+func ValidateNetworkNamespace(path string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("network namespace path '%s' must be absolute", path)
+	}
+	if _, err := os.Stat(path); err != nil {
+		log.Warnf("Network namespace %s not found, cc-manager.sh may run in the daemon's network namespace: %s", path, err)
+	}
+	return nil
+}
+
+// moveToCgroup moves the process into the cgroup v2 directory.
+func moveToCgroup(pid int, cgroup string) error {
+	return os.WriteFile(filepath.Join(cgroup, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0)
+}
+
+// ValidateCgroup returns an error if cgroup is not an absolute path. A path
+// that is not a cgroup v2 directory yet only logs a warning, since the cgroup
+// may be created after the daemon started.
+func ValidateCgroup(cgroup string) error {
+	if !filepath.IsAbs(cgroup) {
+		return fmt.Errorf("cgroup path '%s' must be absolute", cgroup)
+	}
+	if _, err := os.Stat(filepath.Join(cgroup, "cgroup.procs")); err != nil {
+		log.Warnf("'%s' is not a cgroup v2 directory, cc-manager.sh may run in the daemon's cgroup: %s", cgroup, err)
+	}
+	return nil
+}
+
+// ValidateNiceValue returns an error if value is not a valid nice value.
+func ValidateNiceValue(value int) error {
+	if value < -20 || value > 19 {
+		return fmt.Errorf("nice value %d out of range, must be between -20 and 19", value)
+	}
+	return nil
+}
+
+// newScriptCommand returns the command running cc-manager.sh with args and
+// the process attributes requested on the command line. GPUs found unhealthy
+// by the DeviceHealthMonitor are excluded from every command but the ones it
+// runs itself.
+func newScriptCommand(args []string) *exec.Cmd {
+	cmd := exec.Command(CCManagerScript, args...)
+	extra := scriptEnvironment.Environ()
+	healthCommand := len(args) > 0 && deviceHealthCommands[args[0]]
+	if excluded := deviceHealth.Unhealthy(); len(excluded) != 0 && !healthCommand {
+		extra = append(extra, "EXCLUDED_GPUS="+strings.Join(excluded, ","))
+	}
+	if len(extra) != 0 {
+		cmd.Env = append(os.Environ(), extra...)
+	}
+	if scriptUser != nil || scriptGID != nil {
+		credential := &syscall.Credential{
+			Uid: uint32(os.Getuid()),
+			Gid: uint32(os.Getgid()),
+		}
+		if scriptUser != nil {
+			credential.Uid = scriptUser.UID
+			credential.Gid = scriptUser.GID
+			credential.Groups = scriptUser.Groups
+		}
+		if scriptGID != nil {
+			credential.Gid = *scriptGID
+			credential.Groups = []uint32{*scriptGID}
+		}
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: credential}
+	}
+	return cmd
+}
+
+// ScriptUser is the user cc-manager.sh is run as with --script-user.
+type ScriptUser struct {
+	UID    uint32
+	GID    uint32
+	Groups []uint32
+}
+
+// LookupScriptUser resolves a user name or UID to the UID, primary GID and
+// supplementary GIDs of an existing user. It fails if the daemon is not
+// allowed to switch to that user.
+func LookupScriptUser(name string) (*ScriptUser, error) {
+	var u *user.User
+	var err error
+	if _, convErr := strconv.ParseUint(name, 10, 32); convErr == nil {
+		u, err = user.LookupId(name)
+	} else {
+		u, err = user.Lookup(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid '%s' for user %s", u.Uid, u.Username)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid '%s' for user %s", u.Gid, u.Username)
+	}
+	scriptUser := &ScriptUser{UID: uint32(uid), GID: uint32(gid)}
+
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("error looking up the groups of user %s: %s", u.Username, err)
+	}
+	for _, id := range groupIDs {
+		group, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gid '%s' for a group of user %s", id, u.Username)
+		}
+		scriptUser.Groups = append(scriptUser.Groups, uint32(group))
+	}
+
+	if scriptUser.UID != uint32(os.Geteuid()) {
+		if err := requireCapability(unix.CAP_SETUID, "CAP_SETUID"); err != nil {
+			return nil, err
+		}
+		if err := requireCapability(unix.CAP_SETGID, "CAP_SETGID"); err != nil {
+			return nil, err
+		}
+	}
+	return scriptUser, nil
+}
+
+// requireCapability returns an error unless capability is in the effective
+// capability set of the daemon.
+func requireCapability(capability int, name string) error {
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&header, &data[0]); err != nil {
+		return fmt.Errorf("error reading the capabilities of the daemon: %s", err)
+	}
+	if data[capability/32].Effective&(1<<uint(capability%32)) == 0 {
+		return fmt.Errorf("the daemon lacks %s to switch users", name)
+	}
+	return nil
+}
+
+// LookupScriptGroup resolves a group name or GID to the GID of an existing
+// group.
+func LookupScriptGroup(group string) (uint32, error) {
+	var g *user.Group
+	var err error
+	if _, convErr := strconv.ParseUint(group, 10, 32); convErr == nil {
+		g, err = user.LookupGroupId(group)
+	} else {
+		g, err = user.LookupGroup(group)
+	}
+	if err != nil {
+		return 0, err
+	}
+	gid, err := strconv.ParseUint(g.Gid, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid gid '%s' for group %s", g.Gid, g.Name)
+	}
+	return uint32(gid), nil
+}
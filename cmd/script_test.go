@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"text/template"
+)
+
+// TestScriptPermissionError checks that the permission error of a script
+// that cannot be executed survives every layer of wrapping up to
+// applyCCModeConfig, as --terminate-on-script-permission-error relies on.
+func TestScriptPermissionError(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "cc-manager.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nexit 0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := ParseScriptArgsTemplate(DefaultScriptArgsTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(script string) { CCManagerScript = script }(CCManagerScript)
+	defer func() { scriptArgsTemplate, transitions = nil, nil }()
+	CCManagerScript = script
+	scriptArgsTemplate = tmpl
+
+	tests := []struct {
+		name        string
+		transitions *CCModeStateMachine
+	}{
+		{"set-cc-mode", nil},
+		{"get-cc-mode before a transition", NewCCModeStateMachine(TransitionTable{})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transitions = tt.transitions
+			_, err := applyCCModeConfig(CCModeOn, nil)
+			if err == nil {
+				t.Fatal("expected an error running a non-executable script")
+			}
+			if !errors.Is(err, os.ErrPermission) {
+				t.Errorf("expected a permission error, got: %s", err)
+			}
+		})
+	}
+}
+
+// TestScriptDeviceError checks that a failing cc-manager.sh is reported for
+// the last GPU it named on its output.
+func TestScriptDeviceError(t *testing.T) {
+	tmpl, err := ParseScriptArgsTemplate(DefaultScriptArgsTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(script string) { CCManagerScript = script }(CCManagerScript)
+	defer func() { scriptArgsTemplate = nil }()
+	scriptArgsTemplate = tmpl
+
+	tests := []struct {
+		name   string
+		output string
+		device string
+	}{
+		{"no device", "evicting operands", ""},
+		{"last device", "unbinding gpu 0000:41:00.0\\nsetting cc mode of gpu 0000:41:00.0 to on\\nunbinding gpu 0000:c1:00.0", "0000:c1:00.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := filepath.Join(t.TempDir(), "cc-manager.sh")
+			if err := os.WriteFile(script, []byte("#!/bin/sh\nprintf '"+tt.output+"'\nexit 1\n"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			CCManagerScript = script
+
+			err := runScript(CCModeOn)
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) {
+				t.Fatalf("expected the exit error of the script, got: %v", err)
+			}
+			var deviceErr *DeviceError
+			if errors.As(err, &deviceErr) != (tt.device != "") {
+				t.Fatalf("unexpected device error result: %v", err)
+			}
+			if tt.device != "" && deviceErr.DeviceID != tt.device {
+				t.Errorf("expected device %s, got %s", tt.device, deviceErr.DeviceID)
+			}
+		})
+	}
+}
+
+func TestParseScriptArgsTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		wantErr  bool
+	}{
+		{"default", DefaultScriptArgsTemplate, false},
+		{"invalid syntax", "set-cc-mode -m {{.Mode", true},
+		{"unknown field", "set-cc-mode -m {{.Modes}}", true},
+		{"no arguments for a mode", `{{if eq .Mode "on"}}set-cc-mode -a -m on{{end}}`, true},
+		{"disallowed character for a mode", `set-cc-mode -a -m {{.Mode}}{{if eq .Mode "devtools"}};{{end}}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseScriptArgsTemplate(tt.template)
+			if tt.wantErr != (err != nil) {
+				t.Errorf("unexpected error result: %v", err)
+			}
+		})
+	}
+}
+
+func TestRunScriptNoArgs(t *testing.T) {
+	defer func() { scriptArgsTemplate = nil }()
+	scriptArgsTemplate = template.Must(template.New("script-args").Parse(`{{if eq .Mode "on"}}set-cc-mode -a -m on{{end}}`))
+
+	if err := runScript(CCModeOff); err == nil {
+		t.Error("expected an error for a template rendering no arguments")
+	}
+}
+
+func TestCheckCCModeArg(t *testing.T) {
+	tests := []struct {
+		mode    string
+		wantErr bool
+	}{
+		{"on", false},
+		{"dev_tools-2", false},
+		{"on,off", true},
+		{"on;reboot", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			err := checkCCModeArg(tt.mode)
+			if tt.wantErr != (err != nil) {
+				t.Errorf("unexpected error result: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ScriptEnvironment holds the environment variables loaded from the Secret
+// set by --script-environment-secret. The variables are replaced as a whole
+// when the Secret changes, so every script invocation sees either the old or
+// the new set. Values are never logged.
+type ScriptEnvironment struct {
+	mutex sync.Mutex
+	env   []string
+}
+
+func NewScriptEnvironment() *ScriptEnvironment {
+	return &ScriptEnvironment{}
+}
+
+// Set replaces the variables with the data of secret.
+func (e *ScriptEnvironment) Set(secret *v1.Secret) {
+	keys := make([]string, 0, len(secret.Data))
+	for key := range secret.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	env := make([]string, 0, len(keys))
+	for _, key := range keys {
+		env = append(env, fmt.Sprintf("%s=%s", key, secret.Data[key]))
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.env = env
+	log.Infof("Loaded %d script environment variables from Secret %s/%s: %v", len(keys), secret.Namespace, secret.Name, keys)
+}
+
+// Clear removes all variables.
+func (e *ScriptEnvironment) Clear() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.env = nil
+}
+
+// Environ returns the variables in the form expected by exec.Cmd.Env.
+func (e *ScriptEnvironment) Environ() []string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]string(nil), e.env...)
+}
+
+// LoadScriptEnvironment reads the Secret into env.
+func LoadScriptEnvironment(ctx context.Context, clientset *kubernetes.Clientset, namespace, name string, env *ScriptEnvironment) error {
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting Secret %s/%s: %s", namespace, name, err)
+	}
+	env.Set(secret)
+	return nil
+}
+
+// WatchScriptEnvironment reloads env whenever the Secret is rotated. Deleting
+// the Secret removes all variables.
+func WatchScriptEnvironment(clientset *kubernetes.Clientset, namespace, name string, env *ScriptEnvironment) chan struct{} {
+	listWatch := cache.NewListWatchFromClient(
+		clientset.CoreV1().RESTClient(),
+		"secrets",
+		namespace,
+		fields.OneTermEqualSelector("metadata.name", name),
+	)
+
+	_, controller := cache.NewInformer(
+		listWatch, &v1.Secret{}, 0,
+		PanicRecoveryMiddleware("script environment", cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				env.Set(obj.(*v1.Secret))
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				if oldObj.(*v1.Secret).ResourceVersion != newObj.(*v1.Secret).ResourceVersion {
+					env.Set(newObj.(*v1.Secret))
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				log.Warnf("Secret %s/%s was deleted, removing script environment variables", namespace, name)
+				env.Clear()
+			},
+		}),
+	)
+
+	stop := make(chan struct{})
+	go controller.Run(stop)
+	return stop
+}
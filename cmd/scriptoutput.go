@@ -0,0 +1,139 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+var scriptOutputEncodings = map[string]encoding.Encoding{
+	"utf8":         unicode.UTF8,
+	"latin1":       charmap.ISO8859_1,
+	"windows-1252": charmap.Windows1252,
+}
+
+// ParseScriptOutputEncoding returns the --script-output-encoding named name.
+func ParseScriptOutputEncoding(name string) (encoding.Encoding, error) {
+	enc, ok := scriptOutputEncodings[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported encoding '%s', must be one of utf8, latin1, windows-1252", name)
+	}
+	return enc, nil
+}
+
+// scriptOutputWriter transcodes the output of cc-manager.sh from
+// --script-output-encoding to UTF-8. Bytes that cannot be decoded are
+// replaced with the Unicode replacement character.
+type scriptOutputWriter struct {
+	name     string
+	writer   *transform.Writer
+	counter  *replacementCounter
+	encoding string
+}
+
+func newScriptOutputWriter(name string, w io.Writer) *scriptOutputWriter {
+	counter := &replacementCounter{writer: w}
+	return &scriptOutputWriter{
+		name:     name,
+		writer:   transform.NewWriter(counter, scriptOutputEncoding.NewDecoder()),
+		counter:  counter,
+		encoding: scriptOutputEncodingFlag,
+	}
+}
+
+func (w *scriptOutputWriter) Write(p []byte) (int, error) {
+	return w.writer.Write(p)
+}
+
+// Close flushes the remaining output and logs how many replacement
+// characters had to be written.
+func (w *scriptOutputWriter) Close() error {
+	err := w.writer.Close()
+	if lines, ok := w.counter.writer.(*scriptLogWriter); ok {
+		lines.Flush()
+	}
+	if w.counter.replacements > 0 {
+		log.Warnf("Replaced %d invalid byte sequences in the cc-manager.sh %s, expected %s", w.counter.replacements, w.name, w.encoding)
+	}
+	return err
+}
+
+var replacementChar = []byte("\uFFFD")
+
+// replacementCounter counts the replacement characters written to writer.
+type replacementCounter struct {
+	writer       io.Writer
+	replacements int
+}
+
+func (c *replacementCounter) Write(p []byte) (int, error) {
+	c.replacements += bytes.Count(p, replacementChar)
+	return c.writer.Write(p)
+}
+
+// parseScriptLogLevel parses a log level for the output of cc-manager.sh.
+// Levels that would exit the daemon, fatal and panic, are refused.
+func parseScriptLogLevel(s string) (log.Level, error) {
+	level, err := log.ParseLevel(s)
+	if err != nil {
+		return 0, err
+	}
+	if level < log.ErrorLevel {
+		return 0, fmt.Errorf("log level '%s' is not allowed, must be one of trace, debug, info, warn, error", s)
+	}
+	return level, nil
+}
+
+// scriptLogWriter logs every line written to it at level, as set with
+// --script-stdout-log-level and --script-stderr-log-level.
+type scriptLogWriter struct {
+	level log.Level
+	line  []byte
+}
+
+func newScriptLogWriter(level log.Level) *scriptLogWriter {
+	return &scriptLogWriter{level: level}
+}
+
+func (w *scriptLogWriter) Write(p []byte) (int, error) {
+	w.line = append(w.line, p...)
+	for {
+		i := bytes.IndexByte(w.line, '\n')
+		if i < 0 {
+			break
+		}
+		log.StandardLogger().Log(w.level, string(bytes.TrimRight(w.line[:i], "\r")))
+		w.line = w.line[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush logs the last line if it was not terminated by a newline.
+func (w *scriptLogWriter) Flush() {
+	if len(w.line) != 0 {
+		log.StandardLogger().Log(w.level, string(w.line))
+		w.line = nil
+	}
+}
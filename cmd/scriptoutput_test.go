@@ -0,0 +1,59 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestParseScriptLogLevel(t *testing.T) {
+	tests := []struct {
+		value   string
+		expect  log.Level
+		wantErr bool
+	}{
+		{"trace", log.TraceLevel, false},
+		{"debug", log.DebugLevel, false},
+		{"info", log.InfoLevel, false},
+		{"warn", log.WarnLevel, false},
+		{"warning", log.WarnLevel, false},
+		{"WARN", log.WarnLevel, false},
+		{"error", log.ErrorLevel, false},
+		{"fatal", 0, true},
+		{"panic", 0, true},
+		{"", 0, true},
+		{"verbose", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			level, err := parseScriptLogLevel(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error parsing '%s', got %s", tt.value, level)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if level != tt.expect {
+				t.Errorf("expected %s, got %s", tt.expect, level)
+			}
+		})
+	}
+}
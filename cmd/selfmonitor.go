@@ -0,0 +1,113 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	CCModeInterruptedAnnotation   = "nvidia.com/cc.mode.interrupted"
+	CCModeInterruptedAtAnnotation = "nvidia.com/cc.mode.interrupted-at"
+)
+
+// DaemonSetSelfMonitor watches the daemon's own pod and runs a cleanup sequence
+// as soon as the pod is marked for deletion, for instance by an eviction,
+// which may happen well before SIGTERM is received. A CC mode change in
+// progress is recorded on the node, and with --cleanup-on-exit the node
+// annotations and conditions of the daemon are removed.
+type DaemonSetSelfMonitor struct {
+	clientset   *kubernetes.Clientset
+	namespace   string
+	name        string
+	nodeName    string
+	state       *SyncableCCModeState
+	annotations *NodeAnnotationCache
+	once        sync.Once
+}
+
+func NewDaemonSetSelfMonitor(clientset *kubernetes.Clientset, namespace, name, nodeName string, state *SyncableCCModeState, annotations *NodeAnnotationCache) *DaemonSetSelfMonitor {
+	return &DaemonSetSelfMonitor{
+		clientset:   clientset,
+		namespace:   namespace,
+		name:        name,
+		nodeName:    nodeName,
+		state:       state,
+		annotations: annotations,
+	}
+}
+
+// Run watches the pod until the returned channel is closed.
+func (m *DaemonSetSelfMonitor) Run() chan struct{} {
+	listWatch := cache.NewListWatchFromClient(
+		m.clientset.CoreV1().RESTClient(),
+		"pods",
+		m.namespace,
+		fields.OneTermEqualSelector("metadata.name", m.name),
+	)
+
+	check := func(obj interface{}) {
+		pod := obj.(*v1.Pod)
+		if pod.DeletionTimestamp != nil {
+			m.once.Do(m.onTerminating)
+		}
+	}
+
+	_, controller := cache.NewInformer(
+		listWatch, &v1.Pod{}, 0,
+		PanicRecoveryMiddleware("self monitor", cache.ResourceEventHandlerFuncs{
+			AddFunc: check,
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				check(newObj)
+			},
+		}),
+	)
+
+	stop := make(chan struct{})
+	go controller.Run(stop)
+	return stop
+}
+
+func (m *DaemonSetSelfMonitor) onTerminating() {
+	log.Warnf("Pod %s/%s is being deleted", m.namespace, m.name)
+
+	if mode := m.state.Applying(); mode != "" {
+		log.Warnf("Pod deleted during change to CC mode %s, recording the interrupted change on the node", mode)
+		err := m.annotations.Patch(context.Background(), map[string]string{
+			CCModeInterruptedAnnotation:   mode,
+			CCModeInterruptedAtAnnotation: time.Now().UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			log.Errorf("Unable to record interrupted CC mode change on the node: %s", err)
+		}
+	}
+
+	if cleanupOnExitFlag {
+		if err := cleanupNode(context.Background(), m.clientset, m.nodeName); err != nil {
+			log.Errorf("Error cleaning up node %s: %s", m.nodeName, err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const SelfTestAnnotation = "nvidia.com/cc-manager-test"
+
+// runStartupSelfTest checks that the API server, cc-manager.sh and node
+// annotation writes all work, and returns an error naming the first step
+// that failed.
+func runStartupSelfTest(ctx context.Context, clientset *kubernetes.Clientset, nodeName string) error {
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"API server connectivity", func() error {
+			_, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{Limit: 1})
+			return err
+		}},
+		{"cc-manager.sh health-check", func() error {
+			return execScript([]string{"health-check"})
+		}},
+		{"annotation write and read", func() error {
+			return selfTestAnnotationCycle(ctx, clientset, nodeName)
+		}},
+	}
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			return fmt.Errorf("startup self-test step '%s' failed: %s", step.name, err)
+		}
+		log.Infof("Startup self-test step '%s' passed", step.name)
+	}
+	return nil
+}
+
+// selfTestAnnotationCycle writes the test annotation, reads it back and
+// removes it. It bypasses NodeLabelPatcher so that server-side apply does not
+// release the annotations the daemon owns.
+func selfTestAnnotationCycle(ctx context.Context, clientset *kubernetes.Clientset, nodeName string) error {
+	value := time.Now().UTC().Format(time.RFC3339Nano)
+	if err := patchSelfTestAnnotation(ctx, clientset, nodeName, &value); err != nil {
+		return err
+	}
+	defer func() {
+		if err := patchSelfTestAnnotation(ctx, clientset, nodeName, nil); err != nil {
+			log.Warnf("Unable to remove annotation '%s': %s", SelfTestAnnotation, err)
+		}
+	}()
+
+	node, err := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting node %s: %s", nodeName, err)
+	}
+	if got := node.Annotations[SelfTestAnnotation]; got != value {
+		return fmt.Errorf("annotation '%s' read back as '%s', expected '%s'", SelfTestAnnotation, got, value)
+	}
+	return nil
+}
+
+// patchSelfTestAnnotation sets the test annotation, or removes it if value
+// is nil.
+func patchSelfTestAnnotation(ctx context.Context, clientset *kubernetes.Clientset, nodeName string, value *string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]*string{SelfTestAnnotation: value},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding patch: %s", err)
+	}
+	_, err = clientset.CoreV1().Nodes().Patch(ctx, nodeName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("error patching annotation '%s': %s", SelfTestAnnotation, err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// WatchAnnotationStaleness checks the nvidia.com/cc.mode.applied-at
+// annotation every interval and emits a CCModeAnnotationStale Warning event
+// while it is older than threshold. Nodes on which no cc mode was applied yet
+// are not reported.
+func WatchAnnotationStaleness(annotations *NodeAnnotationCache, events *NodeEventRecorder, threshold, interval time.Duration) chan struct{} {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				age, err := appliedAnnotationAge(context.Background(), annotations)
+				if err != nil {
+					log.Warnf("Unable to check staleness of '%s' annotation: %s", CCModeAppliedAtAnnotation, err)
+					continue
+				}
+				if age > threshold {
+					log.Warnf("Annotation '%s' is %s old, no cc mode was applied since", CCModeAppliedAtAnnotation, age.Round(time.Second))
+					events.Eventf(v1.EventTypeWarning, "CCModeAnnotationStale", "No cc mode was applied for %s, more than %s", age.Round(time.Second), threshold)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return stop
+}
+
+// ExpireAppliedAtAnnotation checks the nvidia.com/cc.mode.applied-at
+// annotation every interval and removes it once it is older than ttl. Only the
+// annotation is removed, the applied cc mode is left as is.
+func ExpireAppliedAtAnnotation(annotations *NodeAnnotationCache, ttl, interval time.Duration) chan struct{} {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				age, err := appliedAnnotationAge(context.Background(), annotations)
+				if err != nil {
+					log.Warnf("Unable to check expiry of '%s' annotation: %s", CCModeAppliedAtAnnotation, err)
+					continue
+				}
+				if age <= ttl {
+					continue
+				}
+				if err := annotations.Remove(context.Background(), CCModeAppliedAtAnnotation); err != nil {
+					log.Warnf("Unable to remove expired '%s' annotation: %s", CCModeAppliedAtAnnotation, err)
+					continue
+				}
+				log.Debugf("Removed '%s' annotation, %s old and older than %s", CCModeAppliedAtAnnotation, age.Round(time.Second), ttl)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return stop
+}
+
+// appliedAnnotationAge returns the time elapsed since the applied-at
+// annotation was written, or 0 if it is not set.
+func appliedAnnotationAge(ctx context.Context, annotations *NodeAnnotationCache) (time.Duration, error) {
+	current, err := annotations.Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+	value, ok := current[CCModeAppliedAtAnnotation]
+	if !ok {
+		return 0, nil
+	}
+	appliedAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp '%s': %s", value, err)
+	}
+	return time.Since(appliedAt), nil
+}
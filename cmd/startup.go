@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// StartupWatchdog caps the whole startup sequence, from creating the
+// Kubernetes client to syncing the caches, at --startup-timeout. The daemon
+// exits with the phase that was in progress if startup takes longer, so that
+// the pod is restarted instead of hanging. A nil watchdog does nothing.
+type StartupWatchdog struct {
+	mutex sync.Mutex
+	phase string
+	done  chan struct{}
+}
+
+// NewStartupWatchdog starts a watchdog firing after timeout, nil if timeout
+// is not positive.
+func NewStartupWatchdog(timeout time.Duration) *StartupWatchdog {
+	if timeout <= 0 {
+		return nil
+	}
+	w := &StartupWatchdog{
+		phase: "starting",
+		done:  make(chan struct{}),
+	}
+	go func() {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			log.Fatalf("Startup timed out after %s while %s (see --startup-timeout)", timeout, w.Phase())
+		case <-w.done:
+		}
+	}()
+	return w
+}
+
+// SetPhase records the startup phase now in progress.
+func (w *StartupWatchdog) SetPhase(phase string) {
+	if w == nil {
+		return
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.phase = phase
+}
+
+func (w *StartupWatchdog) Phase() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.phase
+}
+
+// Done stops the watchdog once startup completed. It must be called once.
+func (w *StartupWatchdog) Done() {
+	if w == nil {
+		return
+	}
+	close(w.done)
+}
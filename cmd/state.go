@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// CCModeState describes the last CC mode change applied by the daemon.
+type CCModeState struct {
+	CurrentMode        string        `json:"currentMode"`
+	LastChangeTime     time.Time     `json:"lastChangeTime"`
+	LastChangeDuration time.Duration `json:"lastChangeDuration"`
+}
+
+// SyncableCCModeState guards a CCModeState shared between the main loop and
+// readers such as the HTTP server.
+type SyncableCCModeState struct {
+	mutex    sync.Mutex
+	state    CCModeState
+	applying string
+}
+
+func NewSyncableCCModeState() *SyncableCCModeState {
+	return &SyncableCCModeState{}
+}
+
+// RecordChange records a successful change to mode that took duration.
+func (s *SyncableCCModeState) RecordChange(mode string, duration time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.state.CurrentMode = mode
+	s.state.LastChangeTime = time.Now()
+	s.state.LastChangeDuration = duration
+}
+
+// SetApplying records the CC mode being applied, empty once the change is
+// over.
+func (s *SyncableCCModeState) SetApplying(mode string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.applying = mode
+}
+
+// Applying returns the CC mode being applied, if a change is in progress.
+func (s *SyncableCCModeState) Applying() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.applying
+}
+
+// Get returns a copy of the current state.
+func (s *SyncableCCModeState) Get() CCModeState {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.state
+}
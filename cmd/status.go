@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//go:embed templates/status.html
+var statusTemplates embed.FS
+
+// ParseStatusPageTemplate parses the HTML template of the /status page from
+// path, or the built-in template if path is empty.
+func ParseStatusPageTemplate(path string) (*template.Template, error) {
+	if path == "" {
+		return template.ParseFS(statusTemplates, "templates/status.html")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", path, err)
+	}
+	tmpl, err := template.New("status").Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %s", path, err)
+	}
+	return tmpl, nil
+}
+
+// StatusHandler serves the CCModeState of the daemon on /status, as JSON or,
+// for clients accepting text/html, as an HTML page.
+type StatusHandler struct {
+	state *SyncableCCModeState
+	page  *template.Template
+}
+
+func NewStatusHandler(state *SyncableCCModeState, page *template.Template) *StatusHandler {
+	return &StatusHandler{state: state, page: page}
+}
+
+// ServeHTTP implements GET /status.
+func (h *StatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	state := h.state.Get()
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		// render to a buffer first so that a template error is not
+		// reported after part of the page was written
+		var buf bytes.Buffer
+		if err := h.page.Execute(&buf, state); err != nil {
+			http.Error(w, fmt.Sprintf("error rendering status page: %s", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			log.Warnf("Unable to write status response: %s", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(state); err != nil {
+		log.Warnf("Unable to write status response: %s", err)
+	}
+}
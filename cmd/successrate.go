@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// ChangeSuccessRateTracker computes the success rate of the last window CC
+// mode changes and emits a Warning event when it drops below threshold.
+type ChangeSuccessRateTracker struct {
+	threshold float64
+	events    *NodeEventRecorder
+
+	mutex     sync.Mutex
+	outcomes  []bool
+	next      int
+	count     int
+	successes int
+	alerting  bool
+}
+
+func NewChangeSuccessRateTracker(window int, threshold float64, events *NodeEventRecorder) *ChangeSuccessRateTracker {
+	return &ChangeSuccessRateTracker{
+		threshold: threshold,
+		events:    events,
+		outcomes:  make([]bool, window),
+	}
+}
+
+// Record adds the outcome of a change to the window. It is a no-op on a nil
+// tracker.
+func (t *ChangeSuccessRateTracker) Record(err error) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.count == len(t.outcomes) {
+		if t.outcomes[t.next] {
+			t.successes--
+		}
+	} else {
+		t.count++
+	}
+	t.outcomes[t.next] = err == nil
+	if err == nil {
+		t.successes++
+	}
+	t.next = (t.next + 1) % len(t.outcomes)
+
+	rate := t.rate()
+	switch {
+	case rate < t.threshold && !t.alerting:
+		t.alerting = true
+		log.Warnf("CC mode change success rate dropped to %.1f%% over the last %d changes", rate*100, t.count)
+		t.events.Eventf(v1.EventTypeWarning, "CCModeChangeSuccessRateLow", "CC mode change success rate is %.1f%% over the last %d changes, below %.1f%%", rate*100, t.count, t.threshold*100)
+	case rate >= t.threshold && t.alerting:
+		t.alerting = false
+		log.Infof("CC mode change success rate recovered to %.1f%% over the last %d changes", rate*100, t.count)
+	}
+}
+
+// Rate returns the success rate over the window, 1 if no change was made.
+func (t *ChangeSuccessRateTracker) Rate() float64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.rate()
+}
+
+func (t *ChangeSuccessRateTracker) rate() float64 {
+	if t.count == 0 {
+		return 1
+	}
+	return float64(t.successes) / float64(t.count)
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// IsBlockedByTaint reports whether the node has a taint with taintKey,
+// whatever its value and effect.
+func IsBlockedByTaint(node *v1.Node, taintKey string) bool {
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == taintKey {
+			return true
+		}
+	}
+	return false
+}
+
+// modeForDrainCheck returns the CC mode checked against --skip-modes-on-drain
+// for a value read from SyncableCCModeConfig: the mode itself, or the default
+// of a policy.
+func modeForDrainCheck(value string) string {
+	if !isCCModePolicy(value) {
+		return value
+	}
+	policy, err := ParseCCModePolicy(value)
+	if err != nil {
+		// applying the policy fails and reports the error
+		return ""
+	}
+	return policy.Default
+}
+
+// waitForUncordon returns once the node is schedulable again or mode is not
+// in skipModes, checking every interval. It reports whether it had to wait.
+// Errors getting the node are logged and retried.
+func waitForUncordon(clientset *kubernetes.Clientset, nodeName, mode string, skipModes map[string]bool, interval time.Duration) bool {
+	if !skipModes[mode] {
+		return false
+	}
+	waited := false
+	for {
+		node, err := clientset.CoreV1().Nodes().Get(context.Background(), nodeName, metav1.GetOptions{})
+		if err != nil {
+			log.Warnf("Unable to check whether node %s is cordoned, retrying in %s: %s", nodeName, interval, err)
+		} else if !node.Spec.Unschedulable {
+			return waited
+		} else if !waited {
+			log.Warnf("Node is cordoned, deferring change to CC mode '%s' until it is uncordoned (see --skip-modes-on-drain)", mode)
+		}
+		waited = true
+		time.Sleep(interval)
+	}
+}
+
+// waitForTaintRemoval returns once the node no longer has a taint with
+// taintKey, checking every interval. It reports whether it had to wait.
+// Errors getting the node are logged and retried.
+func waitForTaintRemoval(clientset *kubernetes.Clientset, nodeName, taintKey string, interval time.Duration) bool {
+	waited := false
+	for {
+		node, err := clientset.CoreV1().Nodes().Get(context.Background(), nodeName, metav1.GetOptions{})
+		if err != nil {
+			log.Warnf("Unable to check taints of node %s, retrying in %s: %s", nodeName, interval, err)
+		} else if !IsBlockedByTaint(node, taintKey) {
+			return waited
+		} else {
+			log.Infof("Node has taint '%s', deferring CC mode change for %s", taintKey, interval)
+		}
+		waited = true
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// DeviceTemperatureGuard delays CC mode changes while a GPU is hotter than
+// --device-temp-max-celsius.
+type DeviceTemperatureGuard struct {
+	maxCelsius float64
+	retryDelay time.Duration
+	events     *NodeEventRecorder
+}
+
+func NewDeviceTemperatureGuard(maxCelsius float64, retryDelay time.Duration, events *NodeEventRecorder) *DeviceTemperatureGuard {
+	return &DeviceTemperatureGuard{
+		maxCelsius: maxCelsius,
+		retryDelay: retryDelay,
+		events:     events,
+	}
+}
+
+// Wait returns once no GPU exceeds the temperature threshold, checking again
+// every retry delay. GPUs whose temperature cannot be read are not waited
+// for. It is a no-op on a nil guard.
+func (g *DeviceTemperatureGuard) Wait(mode string) error {
+	if g == nil {
+		return nil
+	}
+	for {
+		overheated, err := g.overheatedDevices()
+		if err != nil {
+			return err
+		}
+		if len(overheated) == 0 {
+			return nil
+		}
+		log.Warnf("GPUs above %.1fC, delaying change to CC mode %s by %s: %s", g.maxCelsius, mode, g.retryDelay, strings.Join(overheated, ", "))
+		g.events.Eventf(v1.EventTypeWarning, "CCModeChangeDelayedTemperature", "Delaying change to CC mode %s, GPUs above %.1fC: %s", mode, g.maxCelsius, strings.Join(overheated, ", "))
+		time.Sleep(g.retryDelay)
+	}
+}
+
+// overheatedDevices returns the GPUs above the threshold with their
+// temperature, as "<id> (<temperature>C)". GPUs excluded as unhealthy are
+// not checked, cc-manager.sh does not change them.
+func (g *DeviceTemperatureGuard) overheatedDevices() ([]string, error) {
+	state, err := QueryResourceState()
+	if err != nil {
+		return nil, err
+	}
+	var overheated []string
+	for _, gpu := range state.GPUs {
+		if !deviceHealth.Healthy(gpu.ID) {
+			continue
+		}
+		temperature, err := getDeviceTemperature(gpu.ID)
+		if err != nil {
+			log.Warnf("Unable to check temperature of GPU %s: %s", gpu.ID, err)
+			continue
+		}
+		if temperature > g.maxCelsius {
+			overheated = append(overheated, fmt.Sprintf("%s (%.1fC)", gpu.ID, temperature))
+		}
+	}
+	return overheated, nil
+}
+
+// getDeviceTemperature returns the temperature of a GPU in degrees celsius.
+func getDeviceTemperature(id string) (float64, error) {
+	output, err := execScriptOutput([]string{"get-temperature", "-d", id})
+	if err != nil {
+		return 0, NewDeviceError(id, fmt.Errorf("error getting temperature: %w", err))
+	}
+	temperature, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil {
+		return 0, NewDeviceError(id, fmt.Errorf("error parsing temperature '%s': %s", strings.TrimSpace(string(output)), err))
+	}
+	return temperature, nil
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TLSReloader holds the server certificate of the HTTP server and, for
+// mutual TLS, the CA bundle client certificates are verified against. Both
+// are read again from disk on SIGHUP; a failed reload keeps the previous
+// ones.
+type TLSReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mutex     sync.Mutex
+	config    *tls.Config
+	stopWatch chan os.Signal
+}
+
+func NewTLSReloader(certFile, keyFile, clientCAFile string) (*TLSReloader, error) {
+	r := &TLSReloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the certificate, key and client CA bundle from disk.
+func (r *TLSReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("error loading TLS certificate: %s", err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if r.clientCAFile != "" {
+		data, err := os.ReadFile(r.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("error reading client CA file: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificate found in client CA file %s", r.clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.config = config
+	return nil
+}
+
+// TLSConfig returns the configuration to serve with. Every handshake uses the
+// certificates loaded last.
+func (r *TLSReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mutex.Lock()
+			defer r.mutex.Unlock()
+			return r.config, nil
+		},
+	}
+}
+
+// WatchSIGHUP reloads the certificates whenever the process receives SIGHUP
+// until Stop is called.
+func (r *TLSReloader) WatchSIGHUP() {
+	r.stopWatch = make(chan os.Signal, 1)
+	signal.Notify(r.stopWatch, syscall.SIGHUP)
+	go func() {
+		for range r.stopWatch {
+			if err := r.Reload(); err != nil {
+				log.Errorf("Unable to reload TLS certificates, keeping the previous ones: %s", err)
+				continue
+			}
+			log.Infof("Reloaded TLS certificates")
+		}
+	}()
+}
+
+// Stop stops watching for SIGHUP.
+func (r *TLSReloader) Stop() {
+	if r.stopWatch == nil {
+		return
+	}
+	signal.Stop(r.stopWatch)
+	close(r.stopWatch)
+}
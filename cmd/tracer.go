@@ -0,0 +1,50 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CriticalSectionTracer is a mutex that logs a warning when it was held for
+// longer than --mutex-hold-warning-threshold. Used as the Locker of a
+// sync.Cond, the time spent in Wait is not counted since Wait unlocks it.
+type CriticalSectionTracer struct {
+	name     string
+	mutex    sync.Mutex
+	acquired time.Time
+}
+
+func NewCriticalSectionTracer(name string) *CriticalSectionTracer {
+	return &CriticalSectionTracer{name: name}
+}
+
+func (t *CriticalSectionTracer) Lock() {
+	t.mutex.Lock()
+	t.acquired = time.Now()
+}
+
+func (t *CriticalSectionTracer) Unlock() {
+	held := time.Since(t.acquired)
+	t.mutex.Unlock()
+	if mutexHoldWarningThresholdFlag > 0 && held > mutexHoldWarningThresholdFlag {
+		log.Warnf("%s mutex held for %s, more than %s", t.name, held, mutexHoldWarningThresholdFlag)
+	}
+}
@@ -0,0 +1,229 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/yaml"
+)
+
+const TransitionTableConfigMapKey = "transition-table"
+
+// TransitionTable maps a CC mode to the CC modes that may be applied
+// directly after it. Modes that are not in the table may change to any mode.
+type TransitionTable map[string][]string
+
+// ParseTransitionTable parses and validates the transition-table key of a
+// ConfigMap, in YAML or JSON. CC modes must be quoted in YAML, which reads
+// unquoted on and off as booleans.
+func ParseTransitionTable(data string) (TransitionTable, error) {
+	var table TransitionTable
+	if err := yaml.UnmarshalStrict([]byte(data), &table); err != nil {
+		return nil, fmt.Errorf("error parsing transition table: %s", err)
+	}
+	for from, allowed := range table {
+		if err := ValidateCCMode(from); err != nil {
+			return nil, fmt.Errorf("invalid transition table: %s", err)
+		}
+		for _, to := range allowed {
+			if err := ValidateCCMode(to); err != nil {
+				return nil, fmt.Errorf("invalid transition from %s: %s", from, err)
+			}
+		}
+	}
+	return table, nil
+}
+
+// CCModeStateMachine enforces the transitions of a TransitionTable. A change
+// the table does not allow is made through the shortest sequence of allowed
+// intermediate modes, or rejected if there is none.
+type CCModeStateMachine struct {
+	mutex sync.Mutex
+	table TransitionTable
+}
+
+func NewCCModeStateMachine(table TransitionTable) *CCModeStateMachine {
+	return &CCModeStateMachine{table: table}
+}
+
+// SetTable replaces the transition table, nil allows all transitions.
+func (m *CCModeStateMachine) SetTable(table TransitionTable) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.table = table
+}
+
+// Steps returns the CC modes to apply in order to change from the current
+// mode to mode, mode being the last one. An unknown current mode allows
+// every transition. It returns only mode on a nil state machine.
+func (m *CCModeStateMachine) Steps(current, mode string) ([]string, error) {
+	if m == nil {
+		return []string{mode}, nil
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if current == "" || current == mode || m.allowed(current, mode) {
+		return []string{mode}, nil
+	}
+
+	// breadth first search for the shortest sequence of allowed transitions
+	previous := map[string]string{current: ""}
+	queue := []string{current}
+	for len(queue) != 0 {
+		from := queue[0]
+		queue = queue[1:]
+		for _, to := range m.next(from) {
+			if _, seen := previous[to]; seen {
+				continue
+			}
+			previous[to] = from
+			if to != mode {
+				queue = append(queue, to)
+				continue
+			}
+			var steps []string
+			for step := mode; step != current; step = previous[step] {
+				steps = append([]string{step}, steps...)
+			}
+			return steps, nil
+		}
+	}
+	return nil, fmt.Errorf("transition from CC mode %s to %s is not allowed by the transition table", current, mode)
+}
+
+func (m *CCModeStateMachine) allowed(from, to string) bool {
+	for _, mode := range m.next(from) {
+		if mode == to {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *CCModeStateMachine) next(from string) []string {
+	if allowed, ok := m.table[from]; ok {
+		return allowed
+	}
+	return ValidCCModes
+}
+
+// runCCModeTransition applies mode with cc-manager.sh, going through the
+// intermediate modes required by the transition table first. With
+// --graceful-transition set, it first waits for the GPUs to become idle. With
+// --mode-verification-poll-interval set, every mode is verified before the
+// next one is applied.
+func runCCModeTransition(mode string) error {
+	current := ""
+	if transitions != nil {
+		var err error
+		current, err = queryCCMode()
+		if err != nil {
+			return fmt.Errorf("unable to check cc mode transition: %w", err)
+		}
+	}
+	steps, err := transitions.Steps(current, mode)
+	if err != nil {
+		return err
+	}
+	if gracefulTransitionFlag {
+		if err := waitForIdleDevices(mode, gracefulTimeoutFlag, gracefulTimeoutPolicy); err != nil {
+			return err
+		}
+	}
+	for _, step := range steps {
+		if step != mode {
+			log.Infof("Applying intermediate CC mode %s on the way from %s to %s", step, current, mode)
+		}
+		if err := runScript(step); err != nil {
+			return err
+		}
+		if modeVerificationPollFlag > 0 {
+			if err := verifyCCMode(step, modeVerificationPollFlag, modeVerificationTimeoutFlag); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// LoadTransitionTable reads the transition table from the ConfigMap.
+func LoadTransitionTable(ctx context.Context, clientset *kubernetes.Clientset, namespace, name string) (TransitionTable, error) {
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting ConfigMap %s/%s: %s", namespace, name, err)
+	}
+	return transitionTableFromConfigMap(cm)
+}
+
+// WatchTransitionTable updates the table of machine whenever the ConfigMap
+// changes. Invalid updates are logged and ignored, and deleting the
+// ConfigMap allows all transitions.
+func WatchTransitionTable(clientset *kubernetes.Clientset, namespace, name string, machine *CCModeStateMachine) chan struct{} {
+	listWatch := cache.NewListWatchFromClient(
+		clientset.CoreV1().RESTClient(),
+		"configmaps",
+		namespace,
+		fields.OneTermEqualSelector("metadata.name", name),
+	)
+
+	update := func(obj interface{}) {
+		table, err := transitionTableFromConfigMap(obj.(*v1.ConfigMap))
+		if err != nil {
+			log.Errorf("Ignoring update of ConfigMap %s/%s: %s", namespace, name, err)
+			return
+		}
+		log.Infof("Updating CC mode transition table from ConfigMap %s/%s", namespace, name)
+		machine.SetTable(table)
+	}
+
+	_, controller := cache.NewInformer(
+		listWatch, &v1.ConfigMap{}, 0,
+		PanicRecoveryMiddleware("transition table", cache.ResourceEventHandlerFuncs{
+			AddFunc: update,
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				update(newObj)
+			},
+			DeleteFunc: func(obj interface{}) {
+				log.Warnf("ConfigMap %s/%s was deleted, allowing all CC mode transitions", namespace, name)
+				machine.SetTable(nil)
+			},
+		}),
+	)
+
+	stop := make(chan struct{})
+	go controller.Run(stop)
+	return stop
+}
+
+func transitionTableFromConfigMap(cm *v1.ConfigMap) (TransitionTable, error) {
+	data, ok := cm.Data[TransitionTableConfigMapKey]
+	if !ok {
+		return nil, fmt.Errorf("ConfigMap %s/%s has no '%s' key", cm.Namespace, cm.Name, TransitionTableConfigMapKey)
+	}
+	return ParseTransitionTable(data)
+}
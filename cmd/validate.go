@@ -0,0 +1,177 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	cli "github.com/urfave/cli/v2"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// validateConfigOnly runs the checks that need more than the flags, which
+// validateFlagsOrEnv already validated, and prints a summary of the
+// configuration. It returns the first failed check.
+func validateConfigOnly(out io.Writer) error {
+	info, err := os.Stat(CCManagerScript)
+	if err != nil {
+		return fmt.Errorf("cc-manager.sh not found: %s", err)
+	}
+	if info.Mode()&0111 == 0 {
+		return fmt.Errorf("%s is not executable", CCManagerScript)
+	}
+
+	config, err := buildKubernetesConfig()
+	if err != nil {
+		return fmt.Errorf("error building kubernetes clientcmd config: %s", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("error building kubernetes clientset from config: %s", err)
+	}
+	version, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		return fmt.Errorf("error connecting to the API server %s: %s", config.Host, err)
+	}
+	node, err := clientset.CoreV1().Nodes().Get(context.Background(), os.Getenv("NODE_NAME"), metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting node %s: %s", os.Getenv("NODE_NAME"), err)
+	}
+
+	summary := []struct {
+		name  string
+		value string
+	}{
+		{"API server", fmt.Sprintf("%s (%s)", config.Host, version.GitVersion)},
+		{"Node", node.Name},
+		{"Requested cc mode", getCCModeConfig(node)},
+		{"Default cc mode", getDefaultCCMode()},
+		{"CC capable device IDs", strings.Join(ccCapableDeviceIDs(), ",")},
+		{"Script arguments template", scriptArgsTemplateFlag},
+		{"Feature gates", featureGates.String()},
+		{"Label patch strategy", string(labelPatchStrategy)},
+		{"HTTP address", httpAddrFlag},
+	}
+	for _, entry := range summary {
+		value := entry.value
+		if value == "" {
+			value = "<none>"
+		}
+		fmt.Fprintf(out, "%-28s %s\n", entry.name+":", value)
+	}
+	fmt.Fprintln(out, "Configuration is valid")
+	return nil
+}
+
+// redactedFlags hold secrets, their values are never printed or logged.
+var redactedFlags = map[string]bool{
+	"kubernetes-proxy-password": true,
+}
+
+const redactedValue = "<redacted>"
+
+// sanitizeSetting returns value, or a placeholder if the flag name holds a
+// secret and is set.
+func sanitizeSetting(name, value string) string {
+	if redactedFlags[name] && value != "" {
+		return redactedValue
+	}
+	if name == "kubernetes-proxy-url" {
+		// the URL may carry the proxy credentials
+		if u, err := url.Parse(value); err == nil {
+			return u.Redacted()
+		}
+	}
+	return value
+}
+
+// SanitizeConfig returns the value of every flag of the daemon by name, with
+// the values of redactedFlags replaced by a placeholder, for logging.
+func SanitizeConfig(c *cli.Context) map[string]string {
+	config := make(map[string]string)
+	for _, flag := range c.App.Flags {
+		name := flag.Names()[0]
+		if name == "help" {
+			continue
+		}
+		config[name] = sanitizeSetting(name, fmt.Sprint(c.Value(name)))
+	}
+	return config
+}
+
+// ResolvedSetting is the value of a flag after validation, and where it was
+// set: on the command line or in the env, in the config directory, or not at
+// all.
+type ResolvedSetting struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// validateConfigCommand runs the flag validation of the daemon with the
+// settings of the config directory, DefaultConfigDir unless given as
+// argument, and prints the resolved settings as JSON.
+func validateConfigCommand(c *cli.Context) error {
+	if c.NArg() > 1 {
+		return fmt.Errorf("usage: %s validate-config [<config-dir>]", c.App.Name)
+	}
+	dir := DefaultConfigDir
+	if c.NArg() == 1 {
+		dir = c.Args().First()
+	}
+	root := c.Lineage()[1]
+
+	preset := make(map[string]bool)
+	for _, flag := range c.App.Flags {
+		name := flag.Names()[0]
+		preset[name] = root.IsSet(name)
+	}
+	if err := root.Set("config-dir", dir); err != nil {
+		return err
+	}
+	if err := validateFlags(root); err != nil {
+		return fmt.Errorf("invalid configuration: %s", err)
+	}
+
+	var settings []ResolvedSetting
+	for _, flag := range c.App.Flags {
+		name := flag.Names()[0]
+		if name == "help" {
+			continue
+		}
+		setting := ResolvedSetting{Name: name, Value: fmt.Sprint(root.Value(name)), Source: "default"}
+		switch _, inConfigDir := configDirSettings[name]; {
+		case preset[name]:
+			setting.Source = "flag-or-env"
+		case inConfigDir:
+			setting.Source = "config-dir"
+		}
+		setting.Value = sanitizeSetting(name, setting.Value)
+		settings = append(settings, setting)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(settings)
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// queryCCMode returns the CC mode all CC capable GPUs report, as printed on
+// the last line of cc-manager.sh get-cc-mode.
+func queryCCMode() (string, error) {
+	output, err := execScriptOutput([]string{"get-cc-mode", "-a"})
+	if err != nil {
+		return "", fmt.Errorf("error getting cc mode: %w", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty output from cc-manager.sh get-cc-mode")
+	}
+	return fields[len(fields)-1], nil
+}
+
+// verifyCCMode polls the CC mode of the GPUs every interval until they report
+// mode, and returns an error if they do not within timeout.
+func verifyCCMode(mode string, interval, timeout time.Duration) error {
+	started := time.Now()
+	deadline := started.Add(timeout)
+	var err error
+	for attempt := 1; ; attempt++ {
+		var current string
+		current, err = queryCCMode()
+		switch {
+		case err != nil:
+			log.Infof("Verifying CC mode %s, attempt %d: %s", mode, attempt, err)
+		case current == mode:
+			log.Infof("Verified CC mode %s after %s", mode, time.Since(started).Round(time.Millisecond))
+			return nil
+		default:
+			log.Infof("Verifying CC mode %s, attempt %d: GPUs report %s", mode, attempt, current)
+		}
+		if time.Now().Add(interval).After(deadline) {
+			if err != nil {
+				return fmt.Errorf("GPUs did not report cc mode %s within %s: %w", mode, timeout, err)
+			}
+			return fmt.Errorf("GPUs did not report cc mode %s within %s", mode, timeout)
+		}
+		time.Sleep(interval)
+	}
+}
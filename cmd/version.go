@@ -0,0 +1,50 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultMinKubernetesVersion is the oldest Kubernetes version the daemon is
+// tested against. Server-side apply, used by --label-patch-strategy=apply, is
+// GA since 1.22.
+const DefaultMinKubernetesVersion = "1.22.0"
+
+// checkKubernetesVersion logs a warning if the API server is older than
+// minVersion.
+func checkKubernetesVersion(clientset *kubernetes.Clientset, minVersion *version.Version) error {
+	info, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		return fmt.Errorf("error getting Kubernetes server version: %s", err)
+	}
+	serverVersion, err := version.ParseGeneric(info.GitVersion)
+	if err != nil {
+		return fmt.Errorf("error parsing Kubernetes server version '%s': %s", info.GitVersion, err)
+	}
+	if serverVersion.LessThan(minVersion) {
+		log.Warnf("Kubernetes server version %s is older than the minimum supported version %s, some features may not work (see --min-kubernetes-version)", info.GitVersion, minVersion)
+		return nil
+	}
+	log.Infof("Kubernetes server version %s", info.GitVersion)
+	return nil
+}
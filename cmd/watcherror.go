@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const watchErrorHandlerTimeout = 30 * time.Second
+
+// WatchFailureTracker counts consecutive failures of the list and watch
+// calls made by the node informer. Once threshold consecutive calls failed,
+// the handler is invoked as `<handler> <node-name> <last-mode> <error>`. It
+// is invoked once per run of failures and rearmed by the next successful
+// call.
+type WatchFailureTracker struct {
+	handler   string
+	threshold int
+	nodeName  string
+	state     *SyncableCCModeState
+
+	mutex    sync.Mutex
+	failures int
+}
+
+func NewWatchFailureTracker(handler string, threshold int, nodeName string, state *SyncableCCModeState) *WatchFailureTracker {
+	return &WatchFailureTracker{
+		handler:   handler,
+		threshold: threshold,
+		nodeName:  nodeName,
+		state:     state,
+	}
+}
+
+// Observe records the outcome of a list or watch call. It is safe to call
+// on a nil tracker.
+func (t *WatchFailureTracker) Observe(err error) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if err == nil {
+		t.failures = 0
+		return
+	}
+	t.failures++
+	if t.failures != t.threshold {
+		return
+	}
+	log.Warnf("Node watch failed %d consecutive times, invoking watch error handler: %s", t.failures, err)
+	// run the handler asynchronously so that it does not delay the retries
+	// of the informer
+	go t.runHandler(t.state.Get().CurrentMode, err.Error())
+}
+
+func (t *WatchFailureTracker) runHandler(lastMode, message string) {
+	ctx, cancel := context.WithTimeout(context.Background(), watchErrorHandlerTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, t.handler, t.nodeName, lastMode, message)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Errorf("Error running watch error handler '%s': %s", t.handler, err)
+	}
+}
@@ -4,6 +4,8 @@ set -x
 
 NODE_NAME=${NODE_NAME:?"Missing NODE_NAME env"}
 CC_CAPABLE_DEVICE_IDS=${CC_CAPABLE_DEVICE_IDS:?"Missing CC_CAPABLE_DEVICE_IDS env"}
+# comma separated pci addresses of gpus to leave alone, e.g. because they are unhealthy
+EXCLUDED_GPUS=${EXCLUDED_GPUS:-""}
 SANDBOX_VALIDATOR_DEPLOYED=""
 SANDBOX_PLUGIN_DEPLOYED=""
 VGPU_DEVICE_MANAGER_DEPLOYED=""
@@ -68,6 +70,11 @@ _get_all_cc_capable_gpus() {
         fi
         local pci_bdf=$(basename $dev)
 
+        if [[ ",$EXCLUDED_GPUS," == *",$pci_bdf,"* ]]; then
+            # stdout is parsed by the daemon for some commands
+            echo "excluding gpu $pci_bdf" >&2
+            continue
+        fi
         if ! _array_contains gpus "$pci_bdf"; then
             # Add new element at the end of the array
             gpus+=("$pci_bdf")
@@ -404,6 +411,42 @@ set_gpu_cc_mode() {
     fi
 }
 
+# Per-process cc mode policies cannot be enforced by gpu_cc_tool, so only the
+# default mode of the policy is applied to the gpus
+set_cc_mode_policy() {
+    local default_mode
+
+    default_mode=$(_parse_policy_default "$CC_MODE_POLICY")
+    if [ $? -ne 0 ]; then
+        echo "unable to parse cc mode policy $CC_MODE_POLICY"
+        return 1
+    fi
+    if ! _is_valid_mode $default_mode; then
+        return 1
+    fi
+
+    _parse_policy_processes "$CC_MODE_POLICY" | while read name mode; do
+        echo "per-process cc mode $mode for process $name is not supported, ignoring"
+    done
+
+    CC_MODE=$default_mode
+    if [ "$DEVICE_ID" != "" ]; then
+        set_gpu_cc_mode $DEVICE_ID
+    else
+        set_cc_mode
+    fi
+}
+
+_parse_policy_default() {
+    local policy=$1
+    python3 -c 'import json,sys; print(json.loads(sys.argv[1])["default"])' "$policy" 2>/dev/null
+}
+
+_parse_policy_processes() {
+    local policy=$1
+    python3 -c 'import json,sys; [print(p["name"], p["mode"]) for p in json.loads(sys.argv[1]).get("processes", [])]' "$policy" 2>/dev/null
+}
+
 get_cc_mode() {
     local mode=""
 
@@ -449,6 +492,129 @@ get_gpu_cc_mode() {
     return 0
 }
 
+# print the resources relevant to cc mode requirements as json
+query() {
+    local memory=""
+    if command -v nvidia-smi > /dev/null 2>&1; then
+        memory=$(nvidia-smi --query-gpu=pci.bus_id,memory.free --format=csv,noheader,nounits 2>/dev/null)
+    fi
+    GPU_FREE_MEMORY="$memory" python3 -c '
+import json, os, sys
+free = {}
+for line in os.environ["GPU_FREE_MEMORY"].splitlines():
+    bus_id, mib = [f.strip() for f in line.split(",")]
+    free[bus_id.lower()[-12:]] = int(mib) / 1024
+modules = [l.split()[0] for l in open("/proc/modules")]
+gpus = []
+for gpu in sys.argv[1:]:
+    entry = {"id": gpu}
+    if gpu.lower()[-12:] in free:
+        entry["freeMemoryGB"] = free[gpu.lower()[-12:]]
+    gpus.append(entry)
+print(json.dumps({"kernelModules": modules, "gpus": gpus}))
+' "${gpus[@]}"
+}
+
+# print the temperature of a gpu in degrees celsius
+get_gpu_temperature() {
+    local gpu=$1
+    if ! command -v nvidia-smi > /dev/null 2>&1; then
+        echo "nvidia-smi not found, unable to get temperature of gpu $gpu" >&2
+        return 1
+    fi
+    temperature=$(nvidia-smi -i "$gpu" --query-gpu=temperature.gpu --format=csv,noheader,nounits 2>&1)
+    if [ $? -ne 0 ]; then
+        echo "unable to get temperature of gpu $gpu, output $temperature" >&2
+        return 1
+    fi
+    echo "$temperature"
+    return 0
+}
+
+# check that the cc mode of a gpu can still be queried
+device_health() {
+    local gpu=$1
+    output=$(python3 /usr/bin/gpu_cc_tool.py --query-cc-mode --gpu-bdf=$gpu 2>&1)
+    if [ $? -ne 0 ]; then
+        echo "gpu $gpu is not accessible, output $output" >&2
+        return 1
+    fi
+    echo "ok"
+    return 0
+}
+
+# wait until no compute process runs on a gpu, for at most timeout seconds
+# returns 2 if the gpu is still busy after the timeout
+wait_idle() {
+    local gpu=$1
+    local timeout=$2
+    if ! command -v nvidia-smi > /dev/null 2>&1; then
+        echo "nvidia-smi not found, unable to check if gpu $gpu is idle" >&2
+        return 1
+    fi
+    local deadline=$(( $(date +%s) + timeout ))
+    while true; do
+        processes=$(nvidia-smi -i "$gpu" --query-compute-apps=pid --format=csv,noheader 2>&1)
+        if [ $? -ne 0 ]; then
+            echo "unable to get compute processes of gpu $gpu, output $processes" >&2
+            return 1
+        fi
+        if [ "$processes" = "" ]; then
+            echo "gpu $gpu is idle"
+            return 0
+        fi
+        if [ $(date +%s) -ge $deadline ]; then
+            echo "gpu $gpu still running compute processes after ${timeout}s: $(echo $processes)" >&2
+            return 2
+        fi
+        sleep 1
+    done
+}
+
+# print the cc modes supported by a gpu, one per line
+list_modes() {
+    local gpu=$1
+    for g in "${gpus[@]}"
+    do
+        if [ "$g" = "$gpu" ]; then
+            # a gpu that reports its cc mode supports every cc mode, any
+            # other gpu can only be left with cc off
+            output=$(python3 /usr/bin/gpu_cc_tool.py --query-cc-mode --gpu-bdf=$gpu 2>&1)
+            if [ $? -ne 0 ] || ! _parse_mode "$output" > /dev/null; then
+                echo "gpu $gpu does not report its cc mode: $output" >&2
+                echo "off"
+                return 0
+            fi
+            printf "on\noff\ndevtools\n"
+            return 0
+        fi
+    done
+    echo "gpu $gpu is not a cc capable gpu" >&2
+    return 1
+}
+
+# check that the tools needed to change the cc mode are available
+health_check() {
+    local missing=0
+    for tool in python3 kubectl; do
+        if ! command -v $tool > /dev/null 2>&1; then
+            echo "$tool not found" >&2
+            missing=1
+        fi
+    done
+    if [ ! -f /usr/bin/gpu_cc_tool.py ]; then
+        echo "/usr/bin/gpu_cc_tool.py not found" >&2
+        missing=1
+    fi
+    if [ ${#gpus[@]} -eq 0 ]; then
+        echo "no cc capable gpus found" >&2
+        missing=1
+    fi
+    [ $missing -eq 0 ] || return 1
+    echo "ok"
+    return 0
+}
+
 handle_set_cc_mode() {
     if [ "$DEVICE_ID" != "" ]; then
         set_gpu_cc_mode $DEVICE_ID
@@ -459,6 +625,16 @@ handle_set_cc_mode() {
     fi
 }
 
+handle_set_cc_mode_policy() {
+    if [ "$CC_MODE_POLICY" = "" ]; then
+        usage
+    elif [ "$DEVICE_ID" != "" ] || [ "$ALL_DEVICES" = "true" ]; then
+        set_cc_mode_policy
+    else
+        usage
+    fi
+}
+
 handle_get_cc_mode() {
     if [ "$DEVICE_ID" != "" ]; then
         get_gpu_cc_mode $DEVICE_ID
@@ -475,7 +651,14 @@ usage() {
 
     Commands:
     set-cc-mode [-a | --all] [-d | --device-id] [-m | --mode]
+    set-cc-mode-policy [-a | --all] [-d | --device-id] [-p | --policy]
     get-cc-mode [-a | --all] [-d | --device-id]
+    get-temperature [-d | --device-id]
+    list-modes [-d | --device-id]
+    wait-idle [-d | --device-id] [-t | --timeout]
+    device-health [-d | --device-id]
+    health-check
+    query
     help [-h]
 EOF
     exit 0
@@ -488,7 +671,14 @@ fi
 command=$1; shift
 case "${command}" in
     set-cc-mode) options=$(getopt -o ad:m: --long all,device-id,mode: -- "$@");;
+    set-cc-mode-policy) options=$(getopt -o ad:p: --long all,device-id:,policy: -- "$@");;
     get-cc-mode ) options=$(getopt -o ad: --long all,device-id: -- "$@");;
+    get-temperature) options=$(getopt -o d: --long device-id: -- "$@");;
+    list-modes) options=$(getopt -o d: --long device-id: -- "$@");;
+    wait-idle) options=$(getopt -o d:t: --long device-id:,timeout: -- "$@");;
+    device-health) options=$(getopt -o d: --long device-id: -- "$@");;
+    query) options=$(getopt -o "" -- "$@");;
+    health-check) options=$(getopt -o "" -- "$@");;
     help) options="" ;;
     *) usage ;;
 esac
@@ -504,6 +694,8 @@ for opt in ${options}; do
     -a | --all) ALL_DEVICES=true; shift 1 ;;
     -d | --device-id) DEVICE_ID=$2; shift 2 ;;
     -m | --mode) CC_MODE=$2; shift 2 ;;
+    -p | --policy) CC_MODE_POLICY=$2; shift 2 ;;
+    -t | --timeout) TIMEOUT=$2; shift 2 ;;
     -h | --help) shift;;
     --) shift; break ;;
     esac
@@ -518,6 +710,37 @@ _populate_cc_capable_device_ids || exit 1
 # get all cc capable gpus
 _get_all_cc_capable_gpus || exit 1
 
+# query, health-check, get-temperature, list-modes, wait-idle and device-health only read the node state, they do not need the operand labels
+# and do not indicate readiness
+if [ "$command" = "query" ]; then
+    query || exit 1
+    exit 0
+fi
+if [ "$command" = "health-check" ]; then
+    health_check || exit 1
+    exit 0
+fi
+if [ "$command" = "get-temperature" ]; then
+    [ "$DEVICE_ID" != "" ] || usage
+    get_gpu_temperature $DEVICE_ID || exit 1
+    exit 0
+fi
+if [ "$command" = "list-modes" ]; then
+    [ "$DEVICE_ID" != "" ] || usage
+    list_modes $DEVICE_ID || exit 1
+    exit 0
+fi
+if [ "$command" = "wait-idle" ]; then
+    [ "$DEVICE_ID" != "" ] && [ "$TIMEOUT" != "" ] || usage
+    wait_idle $DEVICE_ID $TIMEOUT
+    exit $?
+fi
+if [ "$command" = "device-health" ]; then
+    [ "$DEVICE_ID" != "" ] || usage
+    device_health $DEVICE_ID || exit 1
+    exit 0
+fi
+
 # fetch current values of operand deployment labels
 _fetch_current_labels || exit 1
 
@@ -525,6 +748,8 @@ if [ "$command" = "help" ]; then
     usage
 elif [ "$command" = "set-cc-mode" ]; then
     handle_set_cc_mode || exit 1
+elif [ "$command" = "set-cc-mode-policy" ]; then
+    handle_set_cc_mode_policy || exit 1
 elif [ "$command" = "get-cc-mode" ]; then
     handle_get_cc_mode || exit 1
 else